@@ -0,0 +1,210 @@
+// Package config loads the user's default settings for the roll application.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Config holds default settings read from the configuration file.
+// Command line flags override these defaults.
+type Config struct {
+	Sort         string            // Default sort order: "", "ascending" or "descending"
+	Compact      bool              // Print each roll on a single line by default
+	Subtotals    bool              // Print a subtotal for each dice group by default
+	ExplosionCap int               // Maximum extra rolls for an exploding die (0 keeps the built-in cap)
+	Separators   []string          // Extra separators allowed between dice groups, e.g. "and" or "&"
+	Cancel       []string          // Pairs of opposing symbols on fancy dice faces, e.g. "success/failure"
+	Macros       map[string]string // Named dice expressions, e.g. "fireball" = "8d6"
+	Batches      map[string]string // Named lists of expressions separated by ";", e.g. "combat" = "1d20+5; 2d6+3"
+}
+
+// macroNameRegex matches valid macro names.
+var macroNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// DefaultPath returns the conventional location of the configuration file,
+// e.g. ~/.config/roll/config.toml on Linux.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "roll", "config.toml")
+}
+
+// DefaultDiceDir returns the conventional directory of the user's own fancy dice files, which are
+// loaded at startup, e.g. ~/.config/roll/dice on Linux.
+func DefaultDiceDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "roll", "dice")
+}
+
+// Load reads the configuration file at path. A missing file is not an error and yields the defaults.
+// Unknown keys are reported as warnings rather than errors.
+func Load(path string) (Config, []string, error) {
+	if path == "" {
+		return Config{}, nil, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Config{}, nil, nil
+	}
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("cannot open config file: %v", err)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads configuration in a small subset of TOML: "key = value" lines, [macros] and
+// [batches] sections, and "#" comments. Values are quoted strings, booleans or integers.
+func Parse(r io.Reader) (Config, []string, error) {
+	cfg := Config{Macros: make(map[string]string), Batches: make(map[string]string)}
+	var warnings []string
+
+	section := ""
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+
+		// Skip empty lines and comments.
+		if line == "" {
+			continue
+		}
+
+		// Section headers.
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section != "macros" && section != "batches" {
+				warnings = append(warnings, fmt.Sprintf("line %d: unknown section [%s]", lineNum, section))
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return Config{}, warnings, fmt.Errorf("line %d: expected 'key = value'", lineNum)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		warning, err := cfg.set(section, key, value)
+		if err != nil {
+			return Config{}, warnings, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if warning != "" {
+			warnings = append(warnings, fmt.Sprintf("line %d: %s", lineNum, warning))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Config{}, warnings, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	return cfg, warnings, nil
+}
+
+// set applies a single key in the given section, returning a warning for unknown keys.
+func (cfg *Config) set(section, key, value string) (string, error) {
+	switch section {
+	case "macros":
+		if !macroNameRegex.MatchString(key) {
+			return "", fmt.Errorf("invalid macro name '%s'", key)
+		}
+		expression, err := parseString(value)
+		if err != nil {
+			return "", err
+		}
+		cfg.Macros[key] = expression
+		return "", nil
+	case "batches":
+		if !macroNameRegex.MatchString(key) {
+			return "", fmt.Errorf("invalid batch name '%s'", key)
+		}
+		batch, err := parseString(value)
+		if err != nil {
+			return "", err
+		}
+		cfg.Batches[key] = batch
+		return "", nil
+	case "":
+		// Top-level settings.
+	default:
+		return fmt.Sprintf("ignoring key '%s' in unknown section [%s]", key, section), nil
+	}
+
+	var err error
+	switch key {
+	case "sort":
+		cfg.Sort, err = parseString(value)
+		if err == nil && cfg.Sort != "ascending" && cfg.Sort != "descending" && cfg.Sort != "none" {
+			err = fmt.Errorf("sort must be \"ascending\", \"descending\" or \"none\", got %q", cfg.Sort)
+		}
+		if cfg.Sort == "none" {
+			cfg.Sort = ""
+		}
+	case "compact":
+		cfg.Compact, err = strconv.ParseBool(value)
+	case "subtotals":
+		cfg.Subtotals, err = strconv.ParseBool(value)
+	case "explosion_cap":
+		cfg.ExplosionCap, err = strconv.Atoi(value)
+		if err == nil && cfg.ExplosionCap <= 0 {
+			err = fmt.Errorf("explosion_cap must be positive, got %d", cfg.ExplosionCap)
+		}
+	case "separators":
+		var separators string
+		separators, err = parseString(value)
+		cfg.Separators = strings.Fields(separators)
+	case "cancel":
+		var pairs string
+		pairs, err = parseString(value)
+		cfg.Cancel = strings.Fields(pairs)
+	default:
+		return fmt.Sprintf("unknown key '%s'", key), nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("invalid value for '%s': %v", key, err)
+	}
+	return "", nil
+}
+
+// parseString parses a double-quoted string value.
+func parseString(value string) (string, error) {
+	unquoted, err := strconv.Unquote(value)
+	if err != nil || !strings.HasPrefix(value, `"`) {
+		return "", fmt.Errorf("expected a quoted string, got %s", value)
+	}
+	return unquoted, nil
+}
+
+// stripComment removes a "#" comment that is not inside a quoted string.
+func stripComment(line string) string {
+	inString := false
+	for i, r := range line {
+		switch {
+		case r == '"' && (i == 0 || line[i-1] != '\\'):
+			inString = !inString
+		case r == '#' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# Roll defaults
+sort = "descending"
+compact = true
+explosion_cap = 20 # keep chains short
+separators = "and &"
+cancel = "success/failure advantage/threat"
+color = "always"
+
+[macros]
+fireball = "8d6"
+attack = "1d20+5 # not a comment"
+
+[batches]
+combat = "attack: 1d20+5; damage: 2d6+3"
+`
+
+	cfg, warnings, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+
+	if cfg.Sort != "descending" {
+		t.Errorf("Expected sort 'descending', got %q", cfg.Sort)
+	}
+	if !cfg.Compact {
+		t.Error("Expected compact to be true")
+	}
+	if cfg.Subtotals {
+		t.Error("Expected subtotals to default to false")
+	}
+	if cfg.ExplosionCap != 20 {
+		t.Errorf("Expected explosion cap 20, got %d", cfg.ExplosionCap)
+	}
+	if strings.Join(cfg.Separators, " ") != "and &" {
+		t.Errorf("Expected separators [and &], got %q", cfg.Separators)
+	}
+	if strings.Join(cfg.Cancel, " ") != "success/failure advantage/threat" {
+		t.Errorf("Expected two symbol pairs, got %q", cfg.Cancel)
+	}
+	if cfg.Macros["fireball"] != "8d6" {
+		t.Errorf("Expected fireball macro '8d6', got %q", cfg.Macros["fireball"])
+	}
+	if cfg.Macros["attack"] != "1d20+5 # not a comment" {
+		t.Errorf("Expected attack macro to keep its quoted '#', got %q", cfg.Macros["attack"])
+	}
+
+	if cfg.Batches["combat"] != "attack: 1d20+5; damage: 2d6+3" {
+		t.Errorf("Expected combat batch, got %q", cfg.Batches["combat"])
+	}
+
+	// The unknown "color" key is a warning, not an error.
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "color") {
+		t.Errorf("Expected one warning about 'color', got %v", warnings)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"sort = descending",
+		`sort = "sideways"`,
+		"compact = maybe",
+		"explosion_cap = 0",
+		"just some words",
+		"[macros]\n2bad = \"1d6\"",
+		"[batches]\ncombat = 1d20",
+	}
+
+	for _, input := range tests {
+		if _, _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) expected error, got nil", input)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, warnings, err := Load(filepath.Join(t.TempDir(), "missing.toml"))
+	if err != nil {
+		t.Fatalf("Load() of a missing file unexpected error: %v", err)
+	}
+	if cfg.Sort != "" || cfg.Compact || len(cfg.Macros) != 0 || len(warnings) != 0 {
+		t.Errorf("Expected default config for a missing file, got %+v with warnings %v", cfg, warnings)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("subtotals = true\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write config file: %v", err)
+	}
+
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if !cfg.Subtotals {
+		t.Error("Expected subtotals to be true")
+	}
+}
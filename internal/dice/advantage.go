@@ -0,0 +1,17 @@
+package dice
+
+import (
+	"regexp"
+	"strings"
+)
+
+// advantageRegex matches the advantage and disadvantage aliases, e.g. "adv", "1d20adv" or "d20dis".
+var advantageRegex = regexp.MustCompile(`^(?:1?d20)?(?i:(adv|dis))$`)
+
+// parseAdvantage returns the node for an advantage ("adv") or disadvantage ("dis") roll:
+// two d20s keeping the higher or lower respectively.
+func (p *expressionParser) parseAdvantage(text, keyword string) node {
+	group := p.addGroup(text)
+	pool := []Die{{Sides: 20, group: group}, {Sides: 20, group: group}}
+	return &selectNode{highest: strings.EqualFold(keyword, "adv"), arg: &poolNode{pool: pool}}
+}
@@ -0,0 +1,85 @@
+package dice
+
+import "strings"
+
+// Alternative is the result of one sub-expression of a best(...) or worst(...) selection.
+type Alternative struct {
+	Notation string // The canonical notation of the sub-expression (e.g., "2d6+1")
+	Total    int    // The total the sub-expression rolled
+	Chosen   bool   // Whether this sub-expression was selected
+}
+
+// bestNode rolls each of its arguments as a whole expression and keeps the one with the
+// highest or lowest total, e.g. "best(2d6+1, 1d12, 3d4)". Ties go to the earlier argument.
+type bestNode struct {
+	highest bool
+	args    []node
+}
+
+func (n *bestNode) eval(result *RollResult) int {
+	starts := make([]int, len(n.args)+1)
+	totals := make([]int, len(n.args))
+	modifiers := make([]int, len(n.args))
+	modifier := result.Modifier
+
+	best := 0
+	for i, arg := range n.args {
+		starts[i] = len(result.DieRolls)
+		result.Modifier = 0
+		totals[i] = arg.eval(result)
+		modifiers[i] = result.Modifier
+		if (n.highest && totals[i] > totals[best]) || (!n.highest && totals[i] < totals[best]) {
+			best = i
+		}
+	}
+	starts[len(n.args)] = len(result.DieRolls)
+
+	// Only the chosen alternative's dice and flat modifiers count.
+	result.Modifier = modifier + modifiers[best]
+	for i := range n.args {
+		result.Alternatives = append(result.Alternatives, Alternative{
+			Notation: n.args[i].canonical(),
+			Total:    totals[i],
+			Chosen:   i == best,
+		})
+		if i == best {
+			continue
+		}
+		for j := starts[i]; j < starts[i+1]; j++ {
+			result.DieRolls[j].Dropped = true
+		}
+	}
+	return totals[best]
+}
+
+func (n *bestNode) dice() []Die {
+	var all []Die
+	for _, arg := range n.args {
+		all = append(all, arg.dice()...)
+	}
+	return all
+}
+
+func (n *bestNode) bounds() (int, int) {
+	low, high := n.args[0].bounds()
+	for _, arg := range n.args[1:] {
+		argLow, argHigh := arg.bounds()
+		if n.highest {
+			low, high = max(low, argLow), max(high, argHigh)
+		} else {
+			low, high = min(low, argLow), min(high, argHigh)
+		}
+	}
+	return low, high
+}
+
+func (n *bestNode) canonical() string {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.canonical()
+	}
+	if n.highest {
+		return "best(" + strings.Join(args, ", ") + ")"
+	}
+	return "worst(" + strings.Join(args, ", ") + ")"
+}
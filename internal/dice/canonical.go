@@ -0,0 +1,120 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Canonicalize parses dice notation and re-emits it in a normalized form, so that equivalent
+// notations such as "d6 d6 d6" and "3d6" compare equal. The canonical form follows these rules:
+//
+//   - Every dice group has an explicit count and groups are joined by "+", e.g. "1d20+2d6".
+//   - Added dice groups are merged and counted together, then ordered regular, fancy, exclusive
+//     regular and exclusive fancy, each by ascending size, e.g. "6d6+3d6" becomes "9d6".
+//   - Exclusive dice keep their original order, since their position decides which dice
+//     are exclusive with each other; only consecutive identical dice are counted together.
+//   - Other added terms follow in their original order, then subtracted terms, then all flat
+//     modifiers summed into one, e.g. "1d20+5-2" becomes "1d20+3".
+//   - Functions and braced groups are written as "highest(...)", "lowest(...)" and "{...}kh3".
+//   - Aliases are expanded: "adv" becomes "highest(2d20)" and "sw d8" becomes "1d8!!".
+//   - Conditional bonuses keep their condition: "1d20+5 on>=18 add 1d6".
+//   - Multiplying, dividing and remainders follow the whole expression, spaced: "2d6+3 / 2".
+//   - Crit notation canonicalizes each side: "1d20+5 crit 2d6+3".
+func Canonicalize(notation string) (string, error) {
+	if IsCritNotation(notation) {
+		critRoll, err := ParseCritNotation(notation)
+		if err != nil {
+			return "", err
+		}
+		return critRoll.Attack.root.canonical() + " crit " + critRoll.Damage.root.canonical(), nil
+	}
+
+	diceSet, err := ParseDiceNotation(notation)
+	if err != nil {
+		return "", err
+	}
+	return diceSet.root.canonical(), nil
+}
+
+func (n *poolNode) canonical() string {
+	return strings.Join(countDice(n.pool, !hasExclusiveDice(n.pool)), "+")
+}
+
+func (n *constNode) canonical() string {
+	return fmt.Sprintf("%d", n.value)
+}
+
+func (n *sumNode) canonical() string {
+	var pool []Die
+	var added, subtracted []string
+	modifier := 0
+
+	for i, term := range n.terms {
+		switch t := term.(type) {
+		case *constNode:
+			modifier += n.signs[i] * t.value
+			continue
+		case *poolNode:
+			// Merging pools that both hold exclusive dice would make them exclusive with each other.
+			if n.signs[i] > 0 && (len(pool) == 0 || !hasExclusiveDice(pool) || !hasExclusiveDice(t.pool)) {
+				pool = append(pool, t.pool...)
+				continue
+			}
+		}
+		if n.signs[i] > 0 {
+			added = append(added, term.canonical())
+		} else {
+			subtracted = append(subtracted, term.canonical())
+		}
+	}
+
+	if len(pool) > 0 {
+		added = append([]string{(&poolNode{pool: pool}).canonical()}, added...)
+	}
+	text := strings.Join(added, "+")
+	for _, term := range subtracted {
+		text += "-" + term
+	}
+	switch {
+	case text == "":
+		// Only flat modifiers, as in the braces of "{2+3} d6".
+		return fmt.Sprintf("%d", modifier)
+	case modifier != 0:
+		text += fmt.Sprintf("%+d", modifier)
+	}
+	return text
+}
+
+func (n *selectNode) canonical() string {
+	if n.highest {
+		return "highest(" + n.arg.canonical() + ")"
+	}
+	return "lowest(" + n.arg.canonical() + ")"
+}
+
+func (n *keepNode) canonical() string {
+	var mode string
+	switch {
+	case n.middle:
+		mode = "mid"
+	case n.drop && n.highest:
+		mode = "dl"
+	case n.drop:
+		mode = "dh"
+	case n.highest:
+		mode = "kh"
+	default:
+		mode = "kl"
+	}
+	return fmt.Sprintf("{%s}%s%d", n.arg.canonical(), mode, n.count)
+}
+
+// hasExclusiveDice reports whether any of the dice are exclusive.
+func hasExclusiveDice(dice []Die) bool {
+	for _, die := range dice {
+		if die.Sides > 1000 || die.Sides < -1000 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package dice
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExactTotals limits the number of distinct totals tracked while computing an exact
+// distribution; larger expressions are estimated by sampling instead.
+const maxExactTotals = 10000
+
+// chanceQueryRegex splits a query such as "3d6+2 >= 15" into its expression, comparison and target.
+// The last comparison is the query, so "6d10>=7 >= 3" asks for at least three successes.
+var chanceQueryRegex = regexp.MustCompile(`^(.*[^<>=\s])\s*(>=|<=|==|=|>|<)\s*(-?\d+)\s*$`)
+
+// ChanceQuery asks how likely the total of a dice expression is to meet a target, e.g. "3d6+2 >= 15".
+type ChanceQuery struct {
+	Dice     DiceSet // The dice expression whose total is compared
+	Notation string  // The notation of the dice expression (e.g., "3d6+2")
+	target   comparison
+}
+
+// Chance is the probability that a ChanceQuery is met.
+type Chance struct {
+	Probability float64 // The probability, between 0 and 1
+	Exact       bool    // True if computed from the exact distribution rather than estimated
+	Samples     int     // The number of rolls behind an estimate, or 0 if exact
+}
+
+// ParseChanceQuery parses a dice expression followed by a comparison with a whole number,
+// e.g. "3d6+2 >= 15". The comparisons are those of success targets: ">=", "<=", ">", "<" and "=".
+func ParseChanceQuery(query string) (ChanceQuery, error) {
+	match := chanceQueryRegex.FindStringSubmatch(query)
+	if match == nil {
+		return ChanceQuery{}, fmt.Errorf("a chance query is a dice expression compared with a number, e.g. 3d6+2 >= 15")
+	}
+
+	diceSet, err := ParseDiceNotation(match[1])
+	if err != nil {
+		return ChanceQuery{}, err
+	}
+	target, err := strconv.Atoi(match[3])
+	if err != nil {
+		return ChanceQuery{}, fmt.Errorf("invalid number: %s", match[3])
+	}
+	op := match[2]
+	if op == "==" {
+		op = "="
+	}
+
+	return ChanceQuery{
+		Dice:     diceSet,
+		Notation: match[1],
+		target:   comparison{op: op, target: target},
+	}, nil
+}
+
+// String returns the query with single spaces around the comparison, e.g. "3d6+2 >= 15".
+func (q ChanceQuery) String() string {
+	return fmt.Sprintf("%s %s %d", strings.Join(strings.Fields(q.Notation), " "), q.target.op, q.target.target)
+}
+
+// Chance returns the probability that the query is met. It is computed exactly from the distribution
+// of the total when every die is independent and the expression only adds, subtracts, scales, clamps,
+// counts successes or keeps dice by a condition; otherwise the expression is rolled the given number of times to estimate it.
+func (q ChanceQuery) Chance(samples int) (Chance, error) {
+	if q.Dice.root != nil {
+		if distribution, exact := exactDistribution(q.Dice.root); exact {
+			probability := 0.0
+			for total, p := range distribution {
+				if q.target.matches(total) {
+					probability += p
+				}
+			}
+			return Chance{Probability: probability, Exact: true}, nil
+		}
+	}
+
+	estimate, err := q.Dice.Sample(samples)
+	if err != nil {
+		return Chance{}, err
+	}
+	met := 0
+	for total, count := range estimate.Counts {
+		if q.target.matches(total) {
+			met += count
+		}
+	}
+	return Chance{Probability: float64(met) / float64(samples), Samples: samples}, nil
+}
+
+// exactDistribution returns the probability of each total the node can produce, or false if
+// the node has dependent or unbounded dice, or too many totals, to compute it exactly.
+func exactDistribution(n node) (map[int]float64, bool) {
+	switch n := n.(type) {
+	case *constNode:
+		return map[int]float64{n.value: 1}, true
+	case *poolNode:
+		distribution := map[int]float64{0: 1}
+		for _, die := range n.pool {
+			faces, exact := dieDistribution(die)
+			if !exact {
+				return nil, false
+			}
+			if distribution, exact = convolve(distribution, faces, 1); !exact {
+				return nil, false
+			}
+		}
+		return distribution, true
+	case *sumNode:
+		distribution := map[int]float64{0: 1}
+		for i, term := range n.terms {
+			termDistribution, exact := exactDistribution(term)
+			if !exact {
+				return nil, false
+			}
+			if distribution, exact = convolve(distribution, termDistribution, n.signs[i]); !exact {
+				return nil, false
+			}
+		}
+		return distribution, true
+	case *clampNode:
+		distribution, exact := exactDistribution(n.arg)
+		if !exact {
+			return nil, false
+		}
+		clamped := make(map[int]float64)
+		for total, p := range distribution {
+			clamped[min(max(total, n.low), n.high)] += p
+		}
+		return clamped, true
+	case *scaleNode:
+		distribution, exact := exactDistribution(n.arg)
+		if !exact {
+			return nil, false
+		}
+		scaled := make(map[int]float64)
+		for total, p := range distribution {
+			value, _, _ := n.apply(total, rounding)
+			scaled[value] += p
+		}
+		return scaled, true
+	case *keepIfNode:
+		// Each die of a plain pool adds its value if it meets the condition and nothing otherwise.
+		pool, isPool := n.arg.(*poolNode)
+		if !isPool {
+			return nil, false
+		}
+		distribution := map[int]float64{0: 1}
+		for _, die := range pool.pool {
+			faces, exact := dieDistribution(die)
+			if !exact {
+				return nil, false
+			}
+			kept := make(map[int]float64)
+			for value, p := range faces {
+				if n.condition.matches(value) {
+					kept[value] += p
+				} else {
+					kept[0] += p
+				}
+			}
+			if distribution, exact = convolve(distribution, kept, 1); !exact {
+				return nil, false
+			}
+		}
+		return distribution, true
+	case *conditionalNode:
+		// The condition tests the primary die alone, so it is combined with the rest of the roll
+		// and the bonus separately.
+		primary, rest, split := splitPrimary(n.arg)
+		if !split {
+			return nil, false
+		}
+		faces, exact := dieDistribution(primary)
+		if !exact {
+			return nil, false
+		}
+		distribution, exact := exactDistribution(rest)
+		if !exact {
+			return nil, false
+		}
+		bonus, exact := exactDistribution(n.bonus)
+		if !exact {
+			return nil, false
+		}
+		// Rolls that meet the condition spread out over the bonus; the others stay as they are.
+		combined := make(map[int]float64)
+		for roll, p := range faces {
+			for total, q := range distribution {
+				if !n.condition.matches(roll) {
+					combined[roll+total] += p * q
+					continue
+				}
+				for added, r := range bonus {
+					combined[roll+total+added] += p * q * r
+				}
+			}
+		}
+		if len(combined) > maxExactTotals {
+			return nil, false
+		}
+		return combined, true
+	case *successNode:
+		// Each die independently succeeds or not, so the count is a sum of coin flips.
+		pool, isPool := n.arg.(*poolNode)
+		if !isPool {
+			return nil, false
+		}
+		distribution := map[int]float64{0: 1}
+		for _, die := range pool.pool {
+			faces, exact := dieDistribution(die)
+			if !exact {
+				return nil, false
+			}
+			success := 0.0
+			for score, p := range faces {
+				if n.target.matches(score) {
+					success += p
+				}
+			}
+			distribution, _ = convolve(distribution, map[int]float64{0: 1 - success, 1: success}, 1)
+		}
+		return distribution, true
+	}
+	return nil, false
+}
+
+// dieDistribution returns the probability of each score of a single die, or false for exploding
+// and exclusive dice, whose scores are unbounded or depend on other dice.
+func dieDistribution(die Die) (map[int]float64, bool) {
+	if die.Explode != ExplodeNone || die.Sides > 1000 || die.Sides < -1000 {
+		return nil, false
+	}
+
+	var faces []FancyDieValue
+	switch {
+	case len(die.Faces) > 0:
+		faces = die.Faces
+	case die.Sides < 0:
+		faces = fancyDiceValues[fmt.Sprintf("f%d", -die.Sides)]
+	case die.Sides > 0:
+		distribution := make(map[int]float64, die.Sides)
+		for face := 1; face <= die.Sides; face++ {
+			distribution[face+die.Offset] = 1 / float64(die.Sides)
+		}
+		return distribution, true
+	}
+
+	if len(faces) == 0 {
+		return map[int]float64{0: 1}, true
+	}
+	distribution := make(map[int]float64)
+	for _, face := range faces {
+		distribution[face.Value] += 1 / float64(len(faces))
+	}
+	return distribution, true
+}
+
+// convolve returns the distribution of a + sign*b for independent a and b, or false if it has
+// more than maxExactTotals totals.
+func convolve(a, b map[int]float64, sign int) (map[int]float64, bool) {
+	sum := make(map[int]float64, len(a)+len(b))
+	for x, p := range a {
+		for y, q := range b {
+			sum[x+sign*y] += p * q
+		}
+		if len(sum) > maxExactTotals {
+			return nil, false
+		}
+	}
+	return sum, true
+}
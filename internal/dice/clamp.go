@@ -0,0 +1,96 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Clamp records a total that was brought into range by a clamp, e.g. "1d20+5 clamp(1,20)".
+type Clamp struct {
+	Unclamped int // The total before clamping
+	Low       int // The lowest total allowed
+	High      int // The highest total allowed
+}
+
+// String describes the clamp, e.g. "would be 23, clamped to 20".
+func (c Clamp) String() string {
+	return fmt.Sprintf("would be %d, clamped to %d", c.Unclamped, min(max(c.Unclamped, c.Low), c.High))
+}
+
+// clampNode limits the total of a whole expression to a range, e.g. "1d20+5 clamp(1,20)".
+type clampNode struct {
+	arg       node
+	low, high int
+}
+
+func (n *clampNode) eval(result *RollResult) int {
+	total := n.arg.eval(result)
+	if total < n.low || total > n.high {
+		result.Clamped = &Clamp{Unclamped: total, Low: n.low, High: n.high}
+	}
+	return min(max(total, n.low), n.high)
+}
+
+func (n *clampNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *clampNode) bounds() (int, int) {
+	low, high := n.arg.bounds()
+	return min(max(low, n.low), n.high), min(max(high, n.low), n.high)
+}
+
+func (n *clampNode) canonical() string {
+	return fmt.Sprintf("%s clamp(%d,%d)", n.arg.canonical(), n.low, n.high)
+}
+
+// isClamp reports whether the parser is at a "clamp(" that ends the expression.
+func (p *expressionParser) isClamp() bool {
+	tok := p.peek()
+	return tok.kind == tokenWord && strings.EqualFold(tok.text, "clamp") && p.tokens[p.pos+1].kind == tokenLeftParen
+}
+
+// parseClamp parses "clamp(low,high)" after a complete expression.
+func (p *expressionParser) parseClamp(arg node) (node, error) {
+	p.next() // Consume "clamp".
+	p.next() // Consume the opening parenthesis.
+
+	low, err := p.parseClampBound()
+	if err != nil {
+		return nil, err
+	}
+	if p.next().kind != tokenComma {
+		return nil, fmt.Errorf("clamp takes two numbers, e.g. clamp(1,20)")
+	}
+	high, err := p.parseClampBound()
+	if err != nil {
+		return nil, err
+	}
+	if p.next().kind != tokenRightParen {
+		return nil, fmt.Errorf("clamp takes two numbers, e.g. clamp(1,20)")
+	}
+
+	if low > high {
+		return nil, fmt.Errorf("clamp low bound %d is above high bound %d", low, high)
+	}
+	return &clampNode{arg: arg, low: low, high: high}, nil
+}
+
+// parseClampBound parses a possibly negative whole number.
+func (p *expressionParser) parseClampBound() (int, error) {
+	sign := 1
+	if p.peek().kind == tokenMinus {
+		p.next()
+		sign = -1
+	}
+	tok := p.next()
+	if tok.kind != tokenWord || !isNumber(tok.text) {
+		return 0, fmt.Errorf("clamp takes two numbers, e.g. clamp(1,20)")
+	}
+	value, err := strconv.Atoi(tok.text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", tok.text)
+	}
+	return sign * value, nil
+}
@@ -0,0 +1,167 @@
+package dice
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Bonus records whether the bonus of a conditional roll such as "1d20+5 on>=18 add 1d6" was rolled.
+type Bonus struct {
+	Roll      int    // The natural roll of the primary die that the condition was tested against
+	Condition string // The condition, e.g. ">=18"
+	Triggered bool   // True if the condition was met, so the bonus was rolled and added
+	Added     int    // The total the bonus added, if it was triggered
+}
+
+// String describes the outcome, e.g. "bonus triggered: natural 19 meets >=18, adding 4" or
+// "no bonus: natural 12 does not meet >=18".
+func (b Bonus) String() string {
+	if b.Triggered {
+		return fmt.Sprintf("bonus triggered: natural %d meets %s, adding %d", b.Roll, b.Condition, b.Added)
+	}
+	return fmt.Sprintf("no bonus: natural %d does not meet %s", b.Roll, b.Condition)
+}
+
+// conditionalNode rolls a bonus only if the natural roll of the primary die meets a condition,
+// e.g. "1d20+5 on>=18 add 1d6" adds 1d6 on a natural 18 or more, whatever the modifier. The primary
+// die is the first kept die of the roll before "on", such as the d20 kept by "adv".
+type conditionalNode struct {
+	arg       node
+	condition comparison
+	bonus     node
+}
+
+func (n *conditionalNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	total := n.arg.eval(result)
+	outcome := &Bonus{Roll: primaryRoll(result.DieRolls[start:], total), Condition: n.condition.String()}
+	result.Bonus = outcome
+	if !n.condition.matches(outcome.Roll) {
+		return total
+	}
+	outcome.Triggered = true
+	outcome.Added = n.bonus.eval(result)
+	return addScore(result, total, outcome.Added)
+}
+
+// primaryRoll returns the natural roll of the first kept die, before any explosion adds to it, or
+// the total if no dice were rolled.
+func primaryRoll(dieRolls []DieRoll, total int) int {
+	for _, roll := range dieRolls {
+		switch {
+		case roll.Dropped || roll.Exploded:
+			continue
+		case len(roll.Rolls) > 0:
+			// A compounding die sums its chain into its result.
+			return roll.Rolls[0]
+		default:
+			return roll.Score
+		}
+	}
+	return total
+}
+
+func (n *conditionalNode) dice() []Die {
+	return append(n.arg.dice(), n.bonus.dice()...)
+}
+
+func (n *conditionalNode) bounds() (int, int) {
+	low, high := n.arg.bounds()
+	bonusLow, bonusHigh := n.bonus.bounds()
+	// Any die of the primary roll may be the one kept, so test the condition against all of them.
+	rollLow, rollHigh := low, high
+	for i, die := range n.arg.dice() {
+		dieLow, dieHigh := naturalBounds(die)
+		if i == 0 {
+			rollLow, rollHigh = dieLow, dieHigh
+		}
+		rollLow, rollHigh = min(rollLow, dieLow), max(rollHigh, dieHigh)
+	}
+	switch {
+	case n.condition.certain(rollLow, rollHigh):
+		return low + bonusLow, high + bonusHigh
+	case n.condition.possible(rollLow, rollHigh):
+		return low + min(0, bonusLow), high + max(0, bonusHigh)
+	default:
+		return low, high
+	}
+}
+
+// naturalBounds returns the lowest and highest natural roll of a die, before any explosion.
+func naturalBounds(die Die) (int, int) {
+	switch {
+	case die.Sides > 1000:
+		// An exclusive regular die.
+		return 1, die.Sides - 1000
+	case die.Sides < -1000:
+		// An exclusive fancy die scores as its ordinary type.
+		return dieBounds(Die{Sides: die.Sides + 1000})
+	case die.Explode != ExplodeNone:
+		return 1 + die.Offset, die.Sides + die.Offset
+	}
+	return dieBounds(die)
+}
+
+// splitPrimary separates the primary die from the rest of a primary roll made only of independent
+// dice and numbers added together, as in "1d20+5" or "1d20+1d4", so that the distribution of the
+// primary die can be computed apart from the rest. It returns false for any other roll.
+func splitPrimary(n node) (Die, node, bool) {
+	switch n := n.(type) {
+	case *poolNode:
+		if len(n.pool) == 0 {
+			return Die{}, nil, false
+		}
+		return n.pool[0], &poolNode{pool: n.pool[1:]}, true
+	case *sumNode:
+		for i, term := range n.terms {
+			if len(term.dice()) == 0 {
+				continue
+			}
+			pool, isPool := term.(*poolNode)
+			if !isPool || n.signs[i] < 0 {
+				return Die{}, nil, false
+			}
+			primary, rest, _ := splitPrimary(pool)
+			terms := append(slices.Clone(n.terms[:i]), rest)
+			terms = append(terms, n.terms[i+1:]...)
+			return primary, &sumNode{terms: terms, signs: n.signs}, true
+		}
+	}
+	return Die{}, nil, false
+}
+
+func (n *conditionalNode) canonical() string {
+	return fmt.Sprintf("%s on%s add %s", n.arg.canonical(), n.condition, n.bonus.canonical())
+}
+
+// isCondition reports whether the parser is at an "on" followed by a comparison, which ends the
+// primary roll of a conditional expression.
+func (p *expressionParser) isCondition() bool {
+	tok := p.peek()
+	return tok.kind == tokenWord && strings.EqualFold(tok.text, "on") && p.tokens[p.pos+1].kind == tokenCompare
+}
+
+// parseConditional parses "on<comparison> add <expression>" after the primary roll.
+func (p *expressionParser) parseConditional(arg node) (node, error) {
+	p.next() // Consume "on".
+	condition, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.next(); tok.kind != tokenWord || !strings.EqualFold(tok.text, "add") {
+		return nil, fmt.Errorf("expected 'add' and the bonus after the condition, e.g. 1d20+5 on>=18 add 1d6")
+	}
+	if p.peek().kind == tokenEOF {
+		return nil, fmt.Errorf("missing bonus after 'add', e.g. 1d20+5 on>=18 add 1d6")
+	}
+	bonus, err := p.parseSum(true)
+	if err != nil {
+		return nil, err
+	}
+	if p.isCondition() {
+		return nil, fmt.Errorf("an expression can have only one condition")
+	}
+	return &conditionalNode{arg: arg, condition: condition, bonus: bonus}, nil
+}
@@ -0,0 +1,113 @@
+package dice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// critSeparator matches the "crit" keyword separating an attack from its damage.
+var critSeparator = regexp.MustCompile(`(?i)\s+crit\s+`)
+
+// CritRoll is an attack roll followed by a damage roll whose dice are doubled
+// when the attack shows a natural 20, e.g. "1d20+5 crit 2d6+3".
+type CritRoll struct {
+	Attack         DiceSet // The attack roll, which must include a d20
+	Damage         DiceSet // The damage roll
+	AttackNotation string  // The notation of the attack roll (e.g., "1d20+5")
+	DamageNotation string  // The notation of the damage roll (e.g., "2d6+3")
+}
+
+// CritResult represents the result of rolling an attack and its damage.
+type CritResult struct {
+	Attack   RollResult // The attack roll
+	Damage   RollResult // The damage roll, including any doubled dice
+	Critical bool       // True if the attack showed a natural 20
+}
+
+// IsCritNotation reports whether the notation uses the "crit" keyword.
+func IsCritNotation(notation string) bool {
+	return critSeparator.MatchString(notation)
+}
+
+// ParseCritNotation parses notation of the form "<attack> crit <damage>".
+// The grammar is deliberately limited: exactly one "crit" keyword separating two
+// ordinary dice expressions, where the attack expression includes at least one d20.
+func ParseCritNotation(notation string) (CritRoll, error) {
+	return ParseCritNotationWithVariables(notation, nil)
+}
+
+// ParseCritNotationWithVariables parses crit notation in which named variables stand for numbers,
+// as in ParseDiceNotationWithVariables.
+func ParseCritNotationWithVariables(notation string, variables map[string]int) (CritRoll, error) {
+	parts := critSeparator.Split(notation, -1)
+	if len(parts) != 2 {
+		return CritRoll{}, fmt.Errorf("crit notation must have the form '<attack> crit <damage>'")
+	}
+
+	attack, err := ParseDiceNotationWithVariables(parts[0], variables)
+	if err != nil {
+		return CritRoll{}, fmt.Errorf("invalid attack: %v", err)
+	}
+
+	hasD20 := false
+	for _, die := range attack.Dice {
+		if die.Sides == 20 && die.Offset == 0 {
+			hasD20 = true
+		}
+	}
+	if !hasD20 {
+		return CritRoll{}, fmt.Errorf("crit attack must include a d20: %s", parts[0])
+	}
+
+	damage, err := ParseDiceNotationWithVariables(parts[1], variables)
+	if err != nil {
+		return CritRoll{}, fmt.Errorf("invalid damage: %v", err)
+	}
+
+	return CritRoll{
+		Attack:         attack,
+		Damage:         damage,
+		AttackNotation: strings.TrimSpace(parts[0]),
+		DamageNotation: strings.TrimSpace(parts[1]),
+	}, nil
+}
+
+// Roll rolls the attack and then the damage. On a natural 20 on any kept d20 the
+// damage expression is rolled a second time and its dice added, so keep and drop apply
+// to the extra dice too; modifiers are not doubled.
+func (c CritRoll) Roll() CritResult {
+	result := CritResult{Attack: c.Attack.Roll()}
+	twenties, _ := result.Attack.NaturalD20s()
+	result.Critical = twenties > 0
+
+	result.Damage = c.Damage.Roll()
+	if result.Critical {
+		extra := c.Damage.Roll()
+		result.Damage.Total = addScore(&result.Damage, result.Damage.Total, extra.Total-extra.Modifier)
+		result.Damage.DieRolls = append(result.Damage.DieRolls, extra.DieRolls...)
+		result.Damage.Overflow = result.Damage.Overflow || extra.Overflow
+		if result.Damage.Err == nil {
+			result.Damage.Err = extra.Err
+		}
+		result.Damage.deriveIndividualRolls()
+	}
+
+	return result
+}
+
+// NaturalD20s counts the kept d20s that show a natural 20 and a natural 1.
+func (r RollResult) NaturalD20s() (twenties, ones int) {
+	for _, roll := range r.DieRolls {
+		if roll.Dropped || roll.Die.Sides != 20 || roll.Die.Offset != 0 || roll.FancyValue != "" {
+			continue
+		}
+		switch roll.Result {
+		case 20:
+			twenties++
+		case 1:
+			ones++
+		}
+	}
+	return twenties, ones
+}
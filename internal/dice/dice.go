@@ -4,30 +4,62 @@ package dice
 import (
 	"bufio"
 	"fmt"
-	"math/rand/v2"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 // Die represents a single die with a specified number of sides.
 type Die struct {
-	Sides int
+	Sides      int
+	Faces      []FancyDieValue // Faces of an anonymous inline die such as "d{red,green,blue}" (Sides is 0)
+	Explode    ExplodeMode     // How the die rolls again on its maximum (regular dice only)
+	Percentile bool            // Rolled as a tens d10 and a units d10 read together, as in "d%%" (Sides is 100)
+	Offset     int             // Added to every roll of a regular die, so it reads Offset+1 to Offset+Sides; "d10z" has -1 and "d[3-8]" 2
+	explodeOn  comparison      // The rolls that explode, as in "d10!>=9"; the zero value explodes on the maximum only
+	sharePool  int             // The pool an exclusive die draws from, set by shared(...) or separate(...); 0 if adjacency decides
+	group      int             // Index of the dice group in the parsed expression that created the die
 }
 
+// Limits on dice. Die.Sides encodes exclusive dice by adding 1000 to the sides of regular dice and
+// subtracting 1000 from the negated type of fancy dice, so larger dice would be mistaken for them.
+// The count limit stops a single group such as "99999999d6" exhausting memory, and the range limit
+// keeps the faces of range dice far from overflowing an int.
+const (
+	maxSides     = 1000    // Most sides of a regular die, or faces of a fancy die
+	maxDiceCount = 10000   // Most dice in a single dice group
+	maxRangeFace = 1000000 // Largest face, positive or negative, of a range die such as "d[3-8]"
+)
+
 // DiceSet represents a collection of dice to be rolled together.
 type DiceSet struct {
-	Dice []Die
+	Dice   []Die
+	root   node     // Parsed expression structure (nil for sets built directly from dice)
+	groups []string // Notation of each dice group in the parsed expression
+	label  string   // Label given after the notation, e.g. "attack" in "1d20+5 #attack"
 }
 
 // DieRoll represents a single die roll with its result.
 type DieRoll struct {
 	Die        Die    // The die that was rolled
 	Result     int    // The result of the roll
+	Score      int    // The value the roll contributes to the total (a fancy face's scoring value)
 	Type       string // Type identifier (e.g., "d6", "f4")
 	FancyValue string // For fancy dice, the display value (e.g., "♠", "heads")
+	Dropped    bool   // True if the die was rolled but does not count towards the total
+	Exploded   bool   // True if the die was added by an exploding die rolling its maximum
+	Penetrated bool   // True if the die was added by a penetrating die, as in "3d6!p", so its Result is one less than it rolled
+	Rolls      []int  // For compounding dice, the individual rolls summed into Result
+	Success    bool   // True if the die met a success target, such as the ">=7" in "6d10>=7"
+	Tens       int    // For a percentile die such as "d%%", the digit shown by the tens d10 (0 to 9)
+	Units      int    // For a percentile die such as "d%%", the digit shown by the units d10 (0 to 9)
+	Rerolls    int    // How many times unique(...) rerolled the die because it duplicated an earlier die
+	Duplicate  bool   // True if unique(...) gave up rerolling the die and it still duplicates an earlier die
+	Group      int    // Index into RollResult.Groups of the dice group that rolled the die
 }
 
 // FancyDieValue represents a single value for a fancy die.
@@ -38,13 +70,34 @@ type FancyDieValue struct {
 
 // RollResult represents the result of rolling a set of dice.
 type RollResult struct {
-	DieRolls        []DieRoll // Individual die rolls with their dice info
-	IndividualRolls []int     // Just the roll values (for backward compatibility)
-	Total           int       // Sum of all rolls
+	DieRolls        []DieRoll     // Individual die rolls with their dice info
+	IndividualRolls []int         // Just the roll values, derived from DieRolls as rolled (for backward compatibility)
+	Modifier        int           // Sum of the flat numeric modifiers (e.g., +5 in "1d20+5")
+	Total           int           // Sum of all kept rolls plus modifiers
+	Groups          []string      // Notation of each dice group in the expression (e.g., "2d6", "f4")
+	Alternatives    []Alternative // Every sub-expression of any best(...) or worst(...) selection
+	Successes       int           // Number of dice that met a success target, as in "6d10>=7"
+	RollUnder       *RollUnder    // The graded outcome of a single die rolled under a target, e.g. "d100<=45"
+	Label           string        // The label given with the notation, e.g. "attack" in "1d20+5 #attack"
+	Clamped         *Clamp        // The unclamped total, if a clamp such as "clamp(1,20)" changed it
+	Scaled          *Scale        // The total before it was multiplied or divided, as in "1d6/2"
+	Bonus           *Bonus        // Whether a conditional bonus, as in "1d20+5 on>=18 add 1d6", was rolled
+	KeptIf          *KeptIf       // How many dice a conditional keep, as in "6d6 keep>=5", kept
+	Symbols         *Symbols      // The symbols left once opposing ones cancel, if any symbol dice were rolled
+	HasFancy        bool          // True if any fancy or inline die was rolled, so some rolls have face names
+	HasExclusive    bool          // True if any exclusive dice, such as "3D6", were rolled
+	Overflow        bool          // True if the total or modifier went beyond the range of int and is meaningless
+	Err             error         // Why some dice could not be rolled, e.g. a fancy type no longer registered; the total is then meaningless
+}
+
+// GroupTotal is the subtotal of the kept dice of one dice group in an expression.
+type GroupTotal struct {
+	Notation string // The notation of the group (e.g., "2d6")
+	Subtotal int    // The sum of the scores of the group's kept dice
 }
 
 // Standard values for fancy dice.
-var fancyDiceValues = map[string][]FancyDieValue{
+var builtinFancyDice = map[string][]FancyDieValue{
 	"f2":  {{"heads", 1}, {"tails", 0}},
 	"f4":  {{"♠", 4}, {"♥", 3}, {"♦", 2}, {"♣", 1}},                           // Suit characters
 	"f6":  {{"1⚀", 1}, {"2⚁", 2}, {"3⚂", 3}, {"4⚃", 4}, {"5⚄", 5}, {"6⚅", 6}}, // Unicode dice faces (U+2680-U+2685)
@@ -54,6 +107,46 @@ var fancyDiceValues = map[string][]FancyDieValue{
 	"f52": generatePlayingCardValues(),
 }
 
+// fancyDiceValues is the live registry of fancy dice: the built-in dice plus any loaded custom dice.
+var fancyDiceValues = maps.Clone(builtinFancyDice)
+
+// ResetFancyDice discards all loaded custom fancy dice and restores the built-in ones.
+func ResetFancyDice() {
+	fancyDiceValues = maps.Clone(builtinFancyDice)
+}
+
+// FancyDie describes a registered fancy die and its faces.
+type FancyDie struct {
+	Type  string          // The dice type used in notation (e.g., "f4")
+	Faces []FancyDieValue // The faces in roll order
+}
+
+// ListFancyDice returns every registered fancy die, built-in and custom, ordered by number of faces
+// and then by type.
+func ListFancyDice() []FancyDie {
+	list := make([]FancyDie, 0, len(fancyDiceValues))
+	for fancyType, values := range fancyDiceValues {
+		list = append(list, FancyDie{Type: fancyType, Faces: append([]FancyDieValue{}, values...)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].Faces) != len(list[j].Faces) {
+			return len(list[i].Faces) < len(list[j].Faces)
+		}
+		return list[i].Type < list[j].Type
+	})
+	return list
+}
+
+// FancyDieFaces returns a copy of the faces of the named fancy die (e.g., "f13") in roll order,
+// or false if no such die is registered.
+func FancyDieFaces(typeName string) ([]FancyDieValue, bool) {
+	values, exists := fancyDiceValues[typeName]
+	if !exists {
+		return nil, false
+	}
+	return append([]FancyDieValue{}, values...), true
+}
+
 // generateZodiacValues creates zodiac sign values.
 func generateZodiacValues() []FancyDieValue {
 	zodiacSigns := []string{"♈", "♉", "♊", "♋", "♌", "♍", "♎", "♏", "♐", "♑", "♒", "♓"}
@@ -85,7 +178,15 @@ func LoadCustomFancyDice(globPattern string) error {
 	return nil
 }
 
-// loadSingleFancyDiceFile loads a single fancy dice file.
+// fancyDiceSection is one die defined in a fancy dice file, with the header line that introduced it.
+type fancyDiceSection struct {
+	header string // The header line, e.g. "[suits]", or "" for values before the first header
+	values []FancyDieValue
+}
+
+// loadSingleFancyDiceFile loads a single fancy dice file. A file may define several dice, each
+// introduced by a header line such as "[suits]"; values before the first header form a die of their own.
+// Each die is named by its number of values, so two dice in a file cannot have the same number.
 func loadSingleFancyDiceFile(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -93,16 +194,31 @@ func loadSingleFancyDiceFile(filename string) error {
 	}
 	defer file.Close()
 
+	var sections []fancyDiceSection
 	var values []FancyDieValue
+	header := ""
 	scanner := bufio.NewScanner(file)
 	lineNum := 0
 
 	for scanner.Scan() {
 		lineNum++
-		line := strings.TrimSpace(scanner.Text())
+		line := strings.TrimSpace(StripComment(scanner.Text()))
 
 		// Skip empty lines and comments.
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" {
+			continue
+		}
+
+		// A header line starts the next die.
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if header != "" && len(values) == 0 {
+				return fmt.Errorf("section %s contains no valid fancy dice values", header)
+			}
+			if len(values) > 0 {
+				sections = append(sections, fancyDiceSection{header: header, values: values})
+			}
+			values = nil
+			header = line
 			continue
 		}
 
@@ -119,19 +235,71 @@ func loadSingleFancyDiceFile(filename string) error {
 		return fmt.Errorf("error reading file: %v", err)
 	}
 
-	if len(values) == 0 {
+	if header != "" && len(values) == 0 {
+		return fmt.Errorf("section %s contains no valid fancy dice values", header)
+	}
+	if len(values) > 0 {
+		sections = append(sections, fancyDiceSection{header: header, values: values})
+	}
+	if len(sections) == 0 {
 		return fmt.Errorf("file contains no valid fancy dice values")
 	}
+	defined := make(map[int]string)
+	for _, section := range sections {
+		if len(section.values) > maxSides {
+			return fmt.Errorf("a fancy die can have at most %d values, got %d", maxSides, len(section.values))
+		}
+		if earlier, exists := defined[len(section.values)]; exists {
+			return fmt.Errorf("%s and %s both have %d values, so both would be f%d", sectionName(earlier), sectionName(section.header), len(section.values), len(section.values))
+		}
+		defined[len(section.values)] = section.header
+	}
 
 	// The dice type is determined by the number of values (rank of the dice).
-	diceType := fmt.Sprintf("f%d", len(values))
+	for _, section := range sections {
+		diceType := fmt.Sprintf("f%d", len(section.values))
 
-	// Store the custom fancy dice values.
-	fancyDiceValues[diceType] = values
+		// Store the custom fancy dice values.
+		fancyDiceValues[diceType] = section.values
+	}
 
 	return nil
 }
 
+// sectionName names a section of a fancy dice file in an error message.
+func sectionName(header string) string {
+	if header == "" {
+		return "the values before the first header"
+	}
+	return "section " + header
+}
+
+// StripComment removes a comment from a line of a fancy dice file or a file of dice expressions.
+// A comment is a line starting with "#" or a trailing "#" after whitespace, so a face such as
+// "C#" is kept intact.
+func StripComment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// SplitLabel separates dice notation from a label written after it as a comment,
+// e.g. "1d20+5 #attack" gives "1d20+5" and "attack". The label may contain spaces.
+func SplitLabel(notation string) (string, string) {
+	expression := StripComment(notation)
+	if len(expression) == len(notation) {
+		return notation, ""
+	}
+	label := strings.TrimPrefix(strings.TrimSpace(notation[len(expression):]), "#")
+	return strings.TrimSpace(expression), strings.TrimSpace(label)
+}
+
 // parseFancyDiceLine parses a single line from a fancy dice file.
 // Format: "name, value" or "name" (defaults to position).
 func parseFancyDiceLine(line string, defaultValue int) (FancyDieValue, error) {
@@ -185,42 +353,390 @@ func NewDie(sides int) Die {
 	return Die{Sides: sides}
 }
 
+// NewZeroBasedDie creates a regular die that reads 0 to sides-1, like "d10z".
+func NewZeroBasedDie(sides int) (Die, error) {
+	if sides < 1 || sides > maxSides {
+		return Die{}, fmt.Errorf("a die must have between 1 and %d sides, got %d", maxSides, sides)
+	}
+	return Die{Sides: sides, Offset: -1}, nil
+}
+
+// NewRangeDie creates a regular die that reads every whole number from low to high, like "d[3-8]".
+func NewRangeDie(low, high int) (Die, error) {
+	if low > high {
+		return Die{}, fmt.Errorf("die range d[%d-%d] has its minimum above its maximum", low, high)
+	}
+	if low < -maxRangeFace || high > maxRangeFace {
+		return Die{}, fmt.Errorf("die range d[%d-%d] goes beyond %d", low, high, maxRangeFace)
+	}
+	if high-low >= maxSides {
+		return Die{}, fmt.Errorf("too many sides: d[%d-%d] (at most %d)", low, high, maxSides)
+	}
+	return Die{Sides: high - low + 1, Offset: low - 1}, nil
+}
+
+// Min returns the lowest result of a regular die, 1 unless it has an offset.
+func (d Die) Min() int {
+	return 1 + d.Offset
+}
+
+// Max returns the highest result of a regular die before any explosions.
+func (d Die) Max() int {
+	return d.Sides + d.Offset
+}
+
 // Roll rolls a single die and returns the result.
 func (d Die) Roll() int {
+	if len(d.Faces) > 0 {
+		// Inline die - return a random index + 1.
+		if averaging() {
+			return nearestMeanFace(d.Faces)
+		}
+		return randomIntN(len(d.Faces)) + 1
+	}
 	if d.Sides <= 0 {
 		// Handle fancy dice (negative sides) or invalid dice.
 		if d.Sides < 0 {
 			// This is a fancy die - return a random index + 1.
 			fancyType := fmt.Sprintf("f%d", -d.Sides)
 			if values, exists := fancyDiceValues[fancyType]; exists {
-				return rand.IntN(len(values)) + 1
+				if averaging() {
+					return nearestMeanFace(values)
+				}
+				return randomIntN(len(values)) + 1
 			}
 		}
 		return 0 // Defensive check: avoid rolling invalid dice.
 	}
-	return rand.IntN(d.Sides) + 1
+	if d.Offset != 0 {
+		return randomIntN(d.Sides) + 1 + d.Offset
+	}
+	return randomIntN(d.Sides) + 1
+}
+
+// nearestMeanFace returns the 1-based position of the first face whose score is nearest the mean
+// score of all the faces, which is what a fancy die shows under SetAverage.
+func nearestMeanFace(faces []FancyDieValue) int {
+	sum := 0
+	for _, face := range faces {
+		sum += face.Value
+	}
+	// Compare score*n with the sum rather than dividing, so the mean need not be a whole number.
+	n := len(faces)
+	best, bestDistance := 0, -1
+	for i, face := range faces {
+		distance := face.Value*n - sum
+		if distance < 0 {
+			distance = -distance
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	return best + 1
 }
 
-// NewDiceSet creates a new dice set from the provided dice.
+// NewPercentileDie creates a percentile die, rolled as a tens d10 and a units d10 that are read
+// together as 1 to 100, with "00" and "0" reading 100.
+func NewPercentileDie() Die {
+	return Die{Sides: 100, Percentile: true}
+}
+
+// NewDiceSet creates a new dice set from the provided dice, which are rolled together as a single
+// pool. Build the dice with NewDie, NewFancyDie, NewExclusiveDie, NewExclusiveFancyDie and
+// NewInlineDie, and call Validate before rolling a set that has exclusive dice.
 func NewDiceSet(dice []Die) DiceSet {
 	return DiceSet{Dice: dice}
 }
 
+// NewExclusiveDie returns a regular die that shows a different face from the other exclusive dice
+// of the same size next to it in a dice set, like each die of "3D6".
+func NewExclusiveDie(sides int) (Die, error) {
+	if sides < 1 || sides > maxSides {
+		return Die{}, fmt.Errorf("a die must have between 1 and %d sides, got %d", maxSides, sides)
+	}
+	return Die{Sides: sides + 1000}, nil
+}
+
+// NewFancyDie returns the registered fancy die with the given type, e.g. "f4" or "F4".
+func NewFancyDie(fancyType string) (Die, error) {
+	sides, err := fancyDieSides(fancyType)
+	if err != nil {
+		return Die{}, err
+	}
+	return Die{Sides: -sides}, nil
+}
+
+// NewExclusiveFancyDie returns a fancy die that shows a different face from the other exclusive dice
+// of the same type next to it in a dice set, like each die of "13F52".
+func NewExclusiveFancyDie(fancyType string) (Die, error) {
+	sides, err := fancyDieSides(fancyType)
+	if err != nil {
+		return Die{}, err
+	}
+	return Die{Sides: -sides - 1000}, nil
+}
+
+// NewInlineDie returns a one-off die with the given faces, like "d{red,green,blue}".
+// Each face scores its Value.
+func NewInlineDie(faces []FancyDieValue) (Die, error) {
+	if len(faces) == 0 {
+		return Die{}, fmt.Errorf("an inline die needs at least one face")
+	}
+	return Die{Faces: append([]FancyDieValue{}, faces...)}, nil
+}
+
+// fancyDieSides returns the number of faces of a registered fancy die type such as "f4".
+func fancyDieSides(fancyType string) (int, error) {
+	fancyType = strings.ToLower(fancyType)
+	values, exists := fancyDiceValues[fancyType]
+	if !exists {
+		return 0, fmt.Errorf("unsupported fancy dice type: %s", fancyType)
+	}
+	return len(values), nil
+}
+
 // Roll rolls all dice in the set and returns the results.
+// If the total goes beyond the range of int the result has Overflow set, and if some dice cannot be
+// rolled, as when an invalid set skipped DiceSet.Validate, it has Err set; RollNotation reports
+// either as an error.
 func (ds DiceSet) Roll() RollResult {
-	dieRolls := make([]DieRoll, 0, len(ds.Dice)) // Pre-allocate with known capacity.
-	rolls := make([]int, 0, len(ds.Dice))        // Pre-allocate with known capacity.
+	result := RollResult{
+		DieRolls: make([]DieRoll, 0, len(ds.Dice)), // Pre-allocate with known capacity.
+		Groups:   ds.groups,
+		Label:    ds.label,
+	}
+
+	if ds.root == nil {
+		// Sets built directly from dice are a single pool.
+		result.Total = rollPool(ds.Dice, &result)
+	} else {
+		result.Total = ds.root.eval(&result)
+		if check := rollUnderCheck(ds.root); check != nil {
+			roll := result.DieRolls[0]
+			result.RollUnder = &RollUnder{
+				Roll:   roll.Result,
+				Target: check.target.target,
+				Degree: gradeRollUnder(roll.Result, roll.Die.Sides, check.target.target),
+			}
+		}
+	}
+
+	result.Symbols = tallySymbols(result.DieRolls)
+	result.deriveIndividualRolls()
+	return result
+}
+
+// deriveIndividualRolls sets IndividualRolls from the results of DieRolls, once all the dice are rolled.
+func (r *RollResult) deriveIndividualRolls() {
+	r.IndividualRolls = make([]int, len(r.DieRolls))
+	for i, roll := range r.DieRolls {
+		r.IndividualRolls[i] = roll.Result
+	}
+}
+
+// Breakdown is a structured account of how a roll reached its total: the dice that count, the
+// dice that were rolled but do not, and the flat modifiers.
+type Breakdown struct {
+	Kept     []DieRoll // Dice that count towards the total, in the order of the result
+	Dropped  []DieRoll // Dice that were rolled but do not count, such as those dropped by "{4d6}kh3"
+	Modifier int       // Sum of the flat numeric modifiers (e.g., +5 in "1d20+5")
+	Total    int       // The final total
+}
+
+// Breakdown splits the result's dice into those kept and those dropped, keeping their order,
+// so that each output format can show how the total was reached without filtering DieRolls itself.
+func (r RollResult) Breakdown() Breakdown {
+	breakdown := Breakdown{Modifier: r.Modifier, Total: r.Total}
+	for _, roll := range r.DieRolls {
+		if roll.Dropped {
+			breakdown.Dropped = append(breakdown.Dropped, roll)
+		} else {
+			breakdown.Kept = append(breakdown.Kept, roll)
+		}
+	}
+	return breakdown
+}
+
+// GroupTotals returns the subtotal of each dice group in the expression, in the order the groups appear.
+// Results of dice sets built directly from dice have no groups.
+func (r RollResult) GroupTotals() []GroupTotal {
+	if len(r.Groups) == 0 {
+		return nil
+	}
+
+	totals := make([]GroupTotal, len(r.Groups))
+	for i, notation := range r.Groups {
+		totals[i].Notation = notation
+	}
+	for _, roll := range r.DieRolls {
+		if !roll.Dropped && roll.Group >= 0 && roll.Group < len(totals) {
+			totals[roll.Group].Subtotal += roll.Score
+		}
+	}
+	return totals
+}
+
+// SortKey chooses what die rolls are compared by when sorting.
+// The keys only order results differently when RollResult.HasFancy is set.
+type SortKey int
+
+const (
+	// SortByValue compares dice by score, so fancy dice are ordered by the value of their face.
+	SortByValue SortKey = iota
+	// SortByRoll compares dice by the position of the face rolled, e.g. a card's place in the deck.
+	SortByRoll
+)
+
+// Sorted returns a copy of the result with its die rolls sorted by score, ascending or descending.
+// Sorting only changes the display order, so the total and modifier are carried over unchanged.
+func (r RollResult) Sorted(descending bool) RollResult {
+	return r.SortedBy(descending, SortByValue)
+}
+
+// CompareFaces orders two die rolls by the given key, returning a negative number if a comes first,
+// a positive number if b does and zero if they tie. Fancy faces are never compared by name, which
+// would put "10" before "2" and order glyphs by their bytes: rolls with the same score are ordered
+// by the position of the face on the die instead, so the cards of an f13 whose ranks 2 to 10 all
+// score 0 still come out in rank order, and rolls in the same position are ordered by score.
+func CompareFaces(a, b DieRoll, key SortKey) int {
+	first, second := a.Score-b.Score, a.Result-b.Result
+	if key == SortByRoll {
+		first, second = second, first
+	}
+	if first != 0 {
+		return first
+	}
+	return second
+}
+
+// SortedBy returns a copy of the result with its die rolls sorted by the given key, ascending or descending,
+// as ordered by CompareFaces. Rolls that compare equal keep their original order.
+func (r RollResult) SortedBy(descending bool, key SortKey) RollResult {
+	sorted := r
+	sorted.DieRolls = make([]DieRoll, len(r.DieRolls))
+	copy(sorted.DieRolls, r.DieRolls)
+
+	sort.SliceStable(sorted.DieRolls, func(i, j int) bool {
+		order := CompareFaces(sorted.DieRolls[i], sorted.DieRolls[j], key)
+		if descending {
+			return order > 0
+		}
+		return order < 0
+	})
+
+	return sorted
+}
+
+// CountsSuccesses reports whether the expression has a success target, such as "6d10>=7",
+// so that RollResult.Successes is meaningful.
+func (ds DiceSet) CountsSuccesses() bool {
+	return ds.root != nil && countsSuccesses(ds.root)
+}
+
+// Range returns the minimum and maximum totals the dice set can produce without rolling.
+// Fancy dice contribute the extremes of their scoring values, and exclusive dice the
+// smallest or largest distinct values they can show together.
+func (ds DiceSet) Range() (int, int) {
+	if ds.root == nil {
+		return poolBounds(ds.Dice)
+	}
+	return ds.root.bounds()
+}
+
+// poolBounds returns the minimum and maximum total score of a pool of dice.
+func poolBounds(dice []Die) (int, int) {
+	low, high := 0, 0
+	pool := DiceSet{Dice: dice}
+
+	for _, group := range pool.groupExclusiveDice() {
+		if group.IsExclusive {
+			// Exclusive dice show distinct faces, so take the smallest and largest scores together.
+			var scores []int
+			if group.IsFancy {
+				fancyType := fmt.Sprintf("f%d", -(group.Dice[0].Sides + 1000))
+				for _, value := range fancyDiceValues[fancyType] {
+					scores = append(scores, value.Value)
+				}
+			} else {
+				for face := 1; face <= group.Dice[0].Sides-1000; face++ {
+					scores = append(scores, face)
+				}
+			}
+			sort.Ints(scores)
+			count := min(len(group.Dice), len(scores))
+			for i := 0; i < count; i++ {
+				low += scores[i]
+				high += scores[len(scores)-1-i]
+			}
+			continue
+		}
+
+		for _, die := range group.Dice {
+			dieLow, dieHigh := dieBounds(die)
+			low += dieLow
+			high += dieHigh
+		}
+	}
+
+	return low, high
+}
+
+// dieBounds returns the minimum and maximum score of a single non-exclusive die.
+func dieBounds(die Die) (int, int) {
+	var faces []FancyDieValue
+	switch {
+	case len(die.Faces) > 0:
+		faces = die.Faces
+	case die.Sides < 0:
+		faces = fancyDiceValues[fmt.Sprintf("f%d", -die.Sides)]
+	case die.Sides > 0 && die.Explode != ExplodeNone:
+		return explodingBounds(die)
+	case die.Sides > 0:
+		return 1 + die.Offset, die.Sides + die.Offset
+	}
+
+	if len(faces) == 0 {
+		return 0, 0
+	}
+	low, high := faces[0].Value, faces[0].Value
+	for _, face := range faces[1:] {
+		low, high = min(low, face.Value), max(high, face.Value)
+	}
+	return low, high
+}
+
+// dieSpan marks where the rolls of one die of a pool start in a result's DieRolls.
+type dieSpan struct {
+	position int // Index of the die in the pool
+	from     int // Index in DieRolls of its first roll
+}
+
+// rollPool rolls a pool of dice, appends the rolls to the result and returns their total score.
+// The rolls are appended in the order the dice are written, even when a shared pool deals the
+// values of dice written apart together.
+func rollPool(dice []Die, result *RollResult) int {
 	total := 0
+	pool := DiceSet{Dice: dice}
+	start := len(result.DieRolls)
+	spans := make([]dieSpan, 0, len(dice))
 
 	// Group dice by exclusivity for proper handling.
-	exclusiveGroups := ds.groupExclusiveDice()
+	exclusiveGroups := pool.groupExclusiveDice()
 
 	for _, group := range exclusiveGroups {
 		if group.IsExclusive {
+			result.HasExclusive = true
+			result.HasFancy = result.HasFancy || group.IsFancy
+
 			// Roll exclusive group without replacement.
-			values := ds.rollExclusiveGroup(group)
+			values, err := pool.rollExclusiveGroup(group)
+			if err != nil && result.Err == nil {
+				result.Err = err
+			}
 			for i, value := range values {
 				die := group.Dice[i]
+				spans = append(spans, dieSpan{group.positions[i], len(result.DieRolls)})
 
 				var dieType string
 				var fancyValue string
@@ -231,80 +747,163 @@ func (ds DiceSet) Roll() RollResult {
 					fancyType := fmt.Sprintf("f%d", originalType)
 					dieType = fancyType
 
+					score := 0
 					if fancyValues, exists := fancyDiceValues[fancyType]; exists && value > 0 && value <= len(fancyValues) {
 						fancyValue = fancyValues[value-1].Name
-						total += fancyValues[value-1].Value // Add the scoring value to total
+						score = fancyValues[value-1].Value
+						total = addScore(result, total, score) // Add the scoring value to total
 					}
 
 					// Create display die with original sides.
-					displayDie := Die{Sides: -originalType}
+					displayDie := Die{Sides: -originalType, group: die.group}
 					dieRoll := DieRoll{
 						Die:        displayDie,
 						Result:     value,
+						Score:      score,
 						Type:       dieType,
 						FancyValue: fancyValue,
+						Group:      die.group,
 					}
-					dieRolls = append(dieRolls, dieRoll)
+					result.DieRolls = append(result.DieRolls, dieRoll)
 				} else {
 					// Exclusive regular dice.
 					originalSides := die.Sides - 1000
 					dieType = fmt.Sprintf("d%d", originalSides)
 
 					// Create display die with original sides.
-					displayDie := Die{Sides: originalSides}
+					displayDie := Die{Sides: originalSides, group: die.group}
 					dieRoll := DieRoll{
 						Die:        displayDie,
 						Result:     value,
+						Score:      value,
 						Type:       dieType,
 						FancyValue: "",
+						Group:      die.group,
 					}
-					dieRolls = append(dieRolls, dieRoll)
-					total += value
+					result.DieRolls = append(result.DieRolls, dieRoll)
+					total = addScore(result, total, value)
 				}
 
-				rolls = append(rolls, value)
 			}
 		} else {
 			// Roll individual dice normally.
-			for _, die := range group.Dice {
+			for i, die := range group.Dice {
+				spans = append(spans, dieSpan{group.positions[i], len(result.DieRolls)})
 				roll := die.Roll()
 
 				var dieType string
 				var fancyValue string
+				score := 0
+
+				if die.Explode != ExplodeNone && die.Sides > 0 {
+					// Exploding dice may add further rolls.
+					total = addScore(result, total, rollExploding(die, roll, result))
+					continue
+				}
 
-				if die.Sides < 0 {
+				if len(die.Faces) > 0 {
+					// This is an inline fancy die carrying its own faces.
+					result.HasFancy = true
+					dieType = die.inlineType()
+					fancyValue = die.Faces[roll-1].Name
+					score = die.Faces[roll-1].Value
+				} else if die.Sides < 0 {
 					// This is a fancy die.
+					result.HasFancy = true
 					fancyType := fmt.Sprintf("f%d", -die.Sides)
 					dieType = fancyType
 
 					if values, exists := fancyDiceValues[fancyType]; exists && roll > 0 && roll <= len(values) {
 						fancyValue = values[roll-1].Name // Convert 1-based roll to 0-based index
-						total += values[roll-1].Value    // Add the scoring value to total
+						score = values[roll-1].Value     // The scoring value is added to the total
+					} else if result.Err == nil {
+						result.Err = fmt.Errorf("cannot roll %s: the type is no longer registered", fancyType)
 					}
+				} else if die.Percentile {
+					// A percentile die reads its tens and units d10s, so 100 shows as "00" and "0".
+					dieType = "d%%"
+					score = roll
 				} else {
-					// Regular die.
-					dieType = fmt.Sprintf("d%d", die.Sides)
+					// Regular die, possibly zero-based.
+					dieType = die.notation()
 					fancyValue = ""
-					total += roll
+					score = roll
 				}
+				total = addScore(result, total, score)
 
 				dieRoll := DieRoll{
 					Die:        die,
 					Result:     roll,
+					Score:      score,
 					Type:       dieType,
 					FancyValue: fancyValue,
+					Group:      die.group,
 				}
-				dieRolls = append(dieRolls, dieRoll)
-				rolls = append(rolls, roll)
+				if die.Percentile {
+					dieRoll.Tens, dieRoll.Units = roll%100/10, roll%10
+				}
+				result.DieRolls = append(result.DieRolls, dieRoll)
 			}
 		}
 	}
 
-	return RollResult{
-		DieRolls:        dieRolls,
-		IndividualRolls: rolls, // For backward compatibility
-		Total:           total,
+	restoreDieOrder(result, start, spans)
+	return total
+}
+
+// restoreDieOrder puts the rolls appended to a result since start back in the order of their dice
+// in the pool, given where each die's rolls start. Only a shared pool takes dice out of order.
+func restoreDieOrder(result *RollResult, start int, spans []dieSpan) {
+	if sort.SliceIsSorted(spans, func(i, j int) bool { return spans[i].position < spans[j].position }) {
+		return
+	}
+	type dieRolls struct {
+		position int
+		rolls    []DieRoll
+	}
+	byDie := make([]dieRolls, len(spans))
+	for i, span := range spans {
+		end := len(result.DieRolls)
+		if i+1 < len(spans) {
+			end = spans[i+1].from
+		}
+		byDie[i] = dieRolls{span.position, result.DieRolls[span.from:end]}
+	}
+	sort.Slice(byDie, func(i, j int) bool { return byDie[i].position < byDie[j].position })
+
+	reordered := make([]DieRoll, 0, len(result.DieRolls)-start)
+	for _, die := range byDie {
+		reordered = append(reordered, die.rolls...)
+	}
+	copy(result.DieRolls[start:], reordered)
+}
+
+// addScore adds value to total, recording in the result if the sum overflows.
+func addScore(result *RollResult, total, value int) int {
+	sum := total + value
+	if (value > 0 && sum < total) || (value < 0 && sum > total) {
+		result.Overflow = true
 	}
+	return sum
+}
+
+// GroupDigits formats n with its digits grouped in thousands by commas, e.g. "30,000" or "-1,250",
+// so that large totals such as treasure rolls are easy to read.
+func GroupDigits(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + grouped.String()
 }
 
 // ParseDiceNotation parses dice notation and returns a DiceSet.
@@ -314,42 +913,98 @@ func (ds DiceSet) Roll() RollResult {
 // - "2d10 d6" - space-separated groups
 // - "1d20,7d4" - comma-separated groups
 // - "3d6+2d4" - plus-separated groups
+// - "1d20+5", "3d6-2" - flat modifiers
+// - "highest(2d20)", "lowest(3d6)" - keep only the single highest or lowest die
 // Returns an error if the notation is invalid.
 func ParseDiceNotation(notation string) (DiceSet, error) {
+	return ParseDiceNotationWithVariables(notation, nil)
+}
+
+// ParseDiceNotationWithVariables parses dice notation in which named variables stand for numbers,
+// e.g. "1d20+str" with str set to 3. Variables are resolved at parse time, and a name that is
+// neither dice nor a known variable is reported as an undefined variable. A label written after
+// the notation, as in "1d20+5 #attack", is carried into the results; see SplitLabel.
+func ParseDiceNotationWithVariables(notation string, variables map[string]int) (DiceSet, error) {
+	notation, label := SplitLabel(notation)
 	notation = strings.TrimSpace(notation)
 	if notation == "" {
 		return DiceSet{}, fmt.Errorf("empty dice notation")
 	}
 
-	// Split by separators (space, comma, plus).
-	parts := splitDiceExpression(notation)
-
-	var allDice []Die
-
-	for _, part := range parts {
-		dice, err := parseSingleDiceGroup(part)
-		if err != nil {
-			return DiceSet{}, err
-		}
-		allDice = append(allDice, dice...)
+	root, groups, err := parseExpression(notation, variables)
+	if err != nil {
+		return DiceSet{}, err
 	}
 
+	allDice := root.dice()
 	if len(allDice) == 0 {
 		return DiceSet{}, fmt.Errorf("no valid dice found in notation: %s", notation)
 	}
 
-	return NewDiceSet(allDice), nil
+	return DiceSet{Dice: allDice, root: root, groups: groups, label: label}, nil
 }
 
-// splitDiceExpression splits a dice expression by separators (space, comma, plus).
-func splitDiceExpression(notation string) []string {
-	// Replace all separators with spaces for consistent splitting.
-	notation = strings.ReplaceAll(notation, ",", " ")
-	notation = strings.ReplaceAll(notation, "+", " ")
+// Validate reports whether dice notation can be parsed and, if not, why, as ParseDiceNotation would.
+// It builds no DiceSet, so it is cheap enough to check an entry field on every keystroke.
+func Validate(notation string) (bool, error) {
+	notation, _ = SplitLabel(notation)
+	notation = strings.TrimSpace(notation)
+	if notation == "" {
+		return false, fmt.Errorf("empty dice notation")
+	}
 
-	// Split by whitespace and filter out empty parts.
-	parts := strings.Fields(notation)
-	return parts
+	root, _, err := parseExpression(notation, nil)
+	if err != nil {
+		return false, err
+	}
+	if !hasDice(root) {
+		return false, fmt.Errorf("no valid dice found in notation: %s", notation)
+	}
+	return true, nil
+}
+
+// hasDice reports whether an expression rolls any dice, without collecting them where it can
+// look inside a sum or pool directly.
+func hasDice(n node) bool {
+	switch n := n.(type) {
+	case *poolNode:
+		return len(n.pool) > 0
+	case *sumNode:
+		for _, term := range n.terms {
+			if hasDice(term) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(n.dice()) > 0
+}
+
+// RollNotation parses dice notation and rolls it in a single call.
+// Returns an error if the notation is invalid or the total is too large to compute.
+func RollNotation(notation string) (RollResult, error) {
+	diceSet, err := ParseDiceNotation(notation)
+	if err != nil {
+		return RollResult{}, err
+	}
+	result := diceSet.Roll()
+	if result.Err != nil {
+		return RollResult{}, result.Err
+	}
+	if result.Overflow {
+		return RollResult{}, fmt.Errorf("total of %s is too large to compute", notation)
+	}
+	return result, nil
+}
+
+// MustRollNotation is like RollNotation but panics if the notation is invalid.
+// It is intended for tests and for notation known to be valid.
+func MustRollNotation(notation string) RollResult {
+	result, err := RollNotation(notation)
+	if err != nil {
+		panic(fmt.Sprintf("dice: invalid notation %q: %v", notation, err))
+	}
+	return result
 }
 
 // parseSingleDiceGroup parses a single dice group like "3d6", "d20", "2f4", or "3D6" (exclusive).
@@ -359,6 +1014,32 @@ func parseSingleDiceGroup(group string) ([]Die, error) {
 		return nil, fmt.Errorf("empty dice group")
 	}
 
+	// Check for inline fancy dice notation: [count]d{face,face,...}
+	inlineRe := regexp.MustCompile(`^(\d*)d\{(.*)\}$`)
+	if matches := inlineRe.FindStringSubmatch(group); matches != nil {
+		return parseInlineFancyDice(matches[1], matches[2])
+	}
+
+	// Check for range dice notation: [count]d[min-max]
+	rangeRe := regexp.MustCompile(`^(\d*)d\[\s*(-?\d+)\s*-\s*(-?\d+)\s*\]$`)
+	if matches := rangeRe.FindStringSubmatch(group); matches != nil {
+		return parseRangeDice(matches[1], matches[2], matches[3])
+	}
+
+	// Check for percentile dice notation: [count]d%%
+	percentileRe := regexp.MustCompile(`^(\d*)d%%$`)
+	if matches := percentileRe.FindStringSubmatch(group); matches != nil {
+		count, err := parseDiceCount(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		dice := make([]Die, count)
+		for i := range dice {
+			dice[i] = NewPercentileDie()
+		}
+		return dice, nil
+	}
+
 	// Check for exclusive fancy dice notation first: [count]F[type]
 	exclusiveFancyRe := regexp.MustCompile(`^(\d*)F(\d+)$`)
 	if matches := exclusiveFancyRe.FindStringSubmatch(group); matches != nil {
@@ -377,57 +1058,107 @@ func parseSingleDiceGroup(group string) ([]Die, error) {
 		return parseFancyDice(matches[1], matches[2])
 	}
 
-	// Regular dice notation: [count]d[sides]
-	regularRe := regexp.MustCompile(`^(\d*)d(\d+)$`)
+	// Regular dice notation: [count]d[sides], optionally zero-based with "z" and exploding with "!",
+	// compounding with "!!" or penetrating with "!p", on the maximum or on the rolls meeting a comparison such as ">=9".
+	regularRe := regexp.MustCompile(`^(\d*)d(\d+)(z)?(?:(!!|!p|!)((?:[<>]=?|=)\d+)?)?$`)
 	matches := regularRe.FindStringSubmatch(group)
 
-	if len(matches) != 3 {
+	if len(matches) != 6 {
 		return nil, fmt.Errorf("invalid dice notation: %s", group)
 	}
 
-	// Parse count (default to 1 if empty).
-	countStr := matches[1]
-	count := 1
-	if countStr != "" {
-		var err error
-		count, err = strconv.Atoi(countStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid number of dice: %s", countStr)
-		}
+	// Parse count (default to 1 if empty) and sides.
+	count, err := parseDiceCount(matches[1])
+	if err != nil {
+		return nil, err
 	}
-
-	// Parse sides.
-	sides, err := strconv.Atoi(matches[2])
+	sides, err := parseDiceSides(matches[2])
 	if err != nil {
-		return nil, fmt.Errorf("invalid number of sides: %s", matches[2])
+		return nil, err
 	}
 
-	// Validate values.
-	if count <= 0 {
-		return nil, fmt.Errorf("dice count must be positive, got: %d", count)
+	offset := 0
+	if matches[3] == "z" {
+		offset = -1
 	}
-	if sides <= 0 {
-		return nil, fmt.Errorf("dice sides must be positive, got: %d", sides)
+	explode := explodeModes[matches[4]]
+	if explode != ExplodeNone && offset != 0 {
+		return nil, fmt.Errorf("a zero-based die cannot explode: %s", group)
+	}
+	if explode != ExplodeNone && sides == 1 {
+		return nil, fmt.Errorf("a d1 cannot explode: %s", group)
+	}
+	explodeOn, err := parseExplodeCondition(matches[5], sides)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, group)
 	}
 
 	// Create dice.
 	var dice []Die
 	for i := 0; i < count; i++ {
-		dice = append(dice, NewDie(sides))
+		dice = append(dice, Die{Sides: sides, Explode: explode, Offset: offset, explodeOn: explodeOn})
 	}
 
 	return dice, nil
 }
 
+// parseRangeDice parses dice reading every whole number from low to high, e.g. "2d[3-8]".
+func parseRangeDice(countStr, lowStr, highStr string) ([]Die, error) {
+	count, err := parseDiceCount(countStr)
+	if err != nil {
+		return nil, err
+	}
+	low, errLow := strconv.Atoi(lowStr)
+	high, errHigh := strconv.Atoi(highStr)
+	if errLow != nil || errHigh != nil {
+		return nil, fmt.Errorf("invalid die range: d[%s-%s]", lowStr, highStr)
+	}
+	die, err := NewRangeDie(low, high)
+	if err != nil {
+		return nil, err
+	}
+
+	dice := make([]Die, count)
+	for i := range dice {
+		dice[i] = die
+	}
+	return dice, nil
+}
+
+// parseDiceCount parses the number of dice in a group, which defaults to 1 if empty.
+// The notation's regular expressions ensure countStr is digits only, so Atoi fails only when
+// the count is too large for an int.
+func parseDiceCount(countStr string) (int, error) {
+	if countStr == "" {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count > maxDiceCount {
+		return 0, fmt.Errorf("too many dice: %s (at most %d in one group)", countStr, maxDiceCount)
+	}
+	if count <= 0 {
+		return 0, fmt.Errorf("dice count must be positive, got: %d", count)
+	}
+	return count, nil
+}
+
+// parseDiceSides parses the number of sides of a regular die, which must be at most maxSides.
+func parseDiceSides(sidesStr string) (int, error) {
+	sides, err := strconv.Atoi(sidesStr)
+	if err != nil || sides > maxSides {
+		return 0, fmt.Errorf("too many sides: %s (at most %d)", sidesStr, maxSides)
+	}
+	if sides <= 0 {
+		return 0, fmt.Errorf("dice sides must be positive, got: %d", sides)
+	}
+	return sides, nil
+}
+
 // parseFancyDice parses fancy dice notation and creates special "dice" with negative sides to mark them as fancy.
 func parseFancyDice(countStr, typeStr string) ([]Die, error) {
-	count := 1
-	if countStr != "" {
-		var err error
-		count, err = strconv.Atoi(countStr)
-		if err != nil || count <= 0 {
-			return nil, fmt.Errorf("invalid dice count: %s", countStr)
-		}
+	count, err := parseDiceCount(countStr)
+	if err != nil {
+		return nil, err
 	}
 
 	fancyType := "f" + typeStr
@@ -447,20 +1178,86 @@ func parseFancyDice(countStr, typeStr string) ([]Die, error) {
 	return dice, nil
 }
 
+// parseInlineFancyDice parses inline fancy dice notation (e.g., "d{2,3,5,7}" or "2d{red,green,blue}").
+// Faces are separated by commas; a comma, brace or backslash inside a face is escaped with a backslash.
+// Numeric faces score their number and other faces score their position, as in fancy dice files.
+func parseInlineFancyDice(countStr, facesStr string) ([]Die, error) {
+	count, err := parseDiceCount(countStr)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := splitInlineFaces(facesStr)
+	if err != nil {
+		return nil, err
+	}
+
+	faces := make([]FancyDieValue, 0, len(names))
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("empty face in inline die: d{%s}", facesStr)
+		}
+		value := len(faces) + 1
+		if number, err := strconv.Atoi(name); err == nil {
+			value = number
+		}
+		faces = append(faces, FancyDieValue{Name: name, Value: value})
+	}
+
+	var dice []Die
+	for i := 0; i < count; i++ {
+		dice = append(dice, Die{Faces: faces})
+	}
+
+	return dice, nil
+}
+
+// splitInlineFaces splits the body of an inline die on unescaped commas, removing escapes.
+func splitInlineFaces(facesStr string) ([]string, error) {
+	var faces []string
+	var current strings.Builder
+
+	runes := []rune(facesStr)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("dangling escape in inline die: d{%s}", facesStr)
+			}
+			i++
+			current.WriteRune(runes[i])
+		case ',':
+			faces = append(faces, strings.TrimSpace(current.String()))
+			current.Reset()
+		case '{', '}':
+			return nil, fmt.Errorf("unescaped '%c' in inline die: d{%s}", runes[i], facesStr)
+		default:
+			current.WriteRune(runes[i])
+		}
+	}
+
+	return append(faces, strings.TrimSpace(current.String())), nil
+}
+
+// inlineType returns the type identifier of an inline die, e.g. "d{red,green,blue}".
+func (d Die) inlineType() string {
+	names := make([]string, len(d.Faces))
+	for i, face := range d.Faces {
+		names[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`, "{", `\{`, "}", `\}`).Replace(face.Name)
+	}
+	return "d{" + strings.Join(names, ",") + "}"
+}
+
 // parseExclusiveRegularDice parses exclusive regular dice notation (e.g., "3D6").
 func parseExclusiveRegularDice(countStr, sidesStr string) ([]Die, error) {
-	count := 1
-	if countStr != "" {
-		var err error
-		count, err = strconv.Atoi(countStr)
-		if err != nil || count <= 0 {
-			return nil, fmt.Errorf("invalid dice count: %s", countStr)
-		}
+	count, err := parseDiceCount(countStr)
+	if err != nil {
+		return nil, err
 	}
 
-	sides, err := strconv.Atoi(sidesStr)
-	if err != nil || sides <= 0 {
-		return nil, fmt.Errorf("invalid dice sides: %s", sidesStr)
+	sides, err := parseDiceSides(sidesStr)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate that we don't request more dice than available faces.
@@ -479,13 +1276,9 @@ func parseExclusiveRegularDice(countStr, sidesStr string) ([]Die, error) {
 
 // parseExclusiveFancyDice parses exclusive fancy dice notation (e.g., "3F4").
 func parseExclusiveFancyDice(countStr, typeStr string) ([]Die, error) {
-	count := 1
-	if countStr != "" {
-		var err error
-		count, err = strconv.Atoi(countStr)
-		if err != nil || count <= 0 {
-			return nil, fmt.Errorf("invalid dice count: %s", countStr)
-		}
+	count, err := parseDiceCount(countStr)
+	if err != nil {
+		return nil, err
 	}
 
 	fancyType := "f" + typeStr
@@ -534,12 +1327,12 @@ func selectFromSlice(values []int, n int) []int {
 
 	// Base case: if we only need 1 value, pick one at random.
 	if n == 1 {
-		randomIndex := rand.IntN(len(values))
+		randomIndex := randomIntN(len(values))
 		return []int{values[randomIndex]}
 	}
 
 	// Pick a random index from the current slice.
-	randomIndex := rand.IntN(len(values))
+	randomIndex := randomIntN(len(values))
 
 	// Swap the selected value with the first position.
 	values[0], values[randomIndex] = values[randomIndex], values[0]
@@ -556,14 +1349,18 @@ type ExclusiveGroup struct {
 	Dice        []Die
 	IsExclusive bool
 	IsFancy     bool
+	positions   []int // Index in the set of each die, as a shared pool gathers dice written apart
 }
 
-// groupExclusiveDice groups dice by their exclusive nature.
+// groupExclusiveDice groups dice by their exclusive nature. Adjacent exclusive dice of the same
+// size form one group, so "3D6 2D6" rolls five different faces while "3D6 d4 2D6" rolls two
+// independent groups. Dice placed in a pool by shared(...) or separate(...) instead group with
+// every exclusive die of the same size and pool, wherever it is, and never with any other.
 func (ds DiceSet) groupExclusiveDice() []ExclusiveGroup {
 	var groups []ExclusiveGroup
-	currentGroup := ExclusiveGroup{}
+	pools := make(map[[2]int]int) // Index in groups of each pool's group, by pool and sides
 
-	for _, die := range ds.Dice {
+	for position, die := range ds.Dice {
 		// Check if this die is exclusive.
 		isExclusive := false
 		isFancy := false
@@ -578,37 +1375,63 @@ func (ds DiceSet) groupExclusiveDice() []ExclusiveGroup {
 			isFancy = true
 		}
 
-		// If this die matches the current group type, add it.
-		if len(currentGroup.Dice) == 0 ||
-			(currentGroup.IsExclusive == isExclusive && currentGroup.IsFancy == isFancy) {
-			currentGroup.Dice = append(currentGroup.Dice, die)
-			currentGroup.IsExclusive = isExclusive
-			currentGroup.IsFancy = isFancy
-		} else {
-			// Different type, finish current group and start new one.
-			if len(currentGroup.Dice) > 0 {
-				groups = append(groups, currentGroup)
-			}
-			currentGroup = ExclusiveGroup{
-				Dice:        []Die{die},
-				IsExclusive: isExclusive,
-				IsFancy:     isFancy,
+		// A die in an explicit pool joins that pool's group.
+		if isExclusive && die.sharePool != 0 {
+			key := [2]int{die.sharePool, die.Sides}
+			if i, found := pools[key]; found {
+				groups[i].Dice = append(groups[i].Dice, die)
+				groups[i].positions = append(groups[i].positions, position)
+				continue
 			}
+			pools[key] = len(groups)
+			groups = append(groups, ExclusiveGroup{Dice: []Die{die}, IsExclusive: true, IsFancy: isFancy, positions: []int{position}})
+			continue
+		}
+
+		// If this die matches the last group type, add it. Exclusive dice must also be the same size
+		// and not in an explicit pool.
+		last := len(groups) - 1
+		if last >= 0 && groups[last].IsExclusive == isExclusive && groups[last].IsFancy == isFancy &&
+			(!isExclusive || (groups[last].Dice[0].Sides == die.Sides && groups[last].Dice[0].sharePool == 0)) {
+			groups[last].Dice = append(groups[last].Dice, die)
+			groups[last].positions = append(groups[last].positions, position)
+			continue
 		}
-	}
 
-	// Add the last group if it has dice.
-	if len(currentGroup.Dice) > 0 {
-		groups = append(groups, currentGroup)
+		// Different type, so start a new group.
+		groups = append(groups, ExclusiveGroup{Dice: []Die{die}, IsExclusive: isExclusive, IsFancy: isFancy, positions: []int{position}})
 	}
 
 	return groups
 }
 
-// rollExclusiveGroup rolls a group of exclusive dice without replacement.
-func (ds DiceSet) rollExclusiveGroup(group ExclusiveGroup) []int {
+// checkExclusiveGroups reports an error if any exclusive group of a pool of dice has more dice
+// than its die has faces, as when "3D6 4D6" makes one group of seven d6s.
+func checkExclusiveGroups(dice []Die) error {
+	for _, group := range (DiceSet{Dice: dice}).groupExclusiveDice() {
+		switch {
+		case !group.IsExclusive:
+			continue
+		case group.IsFancy:
+			fancyType := fmt.Sprintf("f%d", -group.Dice[0].Sides-1000)
+			if values := fancyDiceValues[fancyType]; len(group.Dice) > len(values) {
+				return fmt.Errorf("cannot roll %d exclusive %s dice with only %d values", len(group.Dice), fancyType, len(values))
+			}
+		default:
+			if sides := group.Dice[0].Sides - 1000; len(group.Dice) > sides {
+				return fmt.Errorf("cannot roll %d exclusive dice with only %d sides", len(group.Dice), sides)
+			}
+		}
+	}
+	return nil
+}
+
+// rollExclusiveGroup rolls a group of exclusive dice without replacement. It fails if the group
+// has more dice than its type has faces, which the parser rules out but NewDiceSet does not, or
+// if a fancy type is no longer registered.
+func (ds DiceSet) rollExclusiveGroup(group ExclusiveGroup) ([]int, error) {
 	if !group.IsExclusive || len(group.Dice) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	if group.IsFancy {
@@ -617,48 +1440,172 @@ func (ds DiceSet) rollExclusiveGroup(group ExclusiveGroup) []int {
 		originalType := -(firstDie.Sides + 1000)
 		fancyType := fmt.Sprintf("f%d", originalType)
 
-		if values, exists := fancyDiceValues[fancyType]; exists {
-			// Use shuffle algorithm to select without replacement.
-			indices := selectWithoutReplacement(len(values), len(group.Dice))
-			results := make([]int, len(indices))
-			for i, index := range indices {
-				results[i] = index // Return 1-based indices
-			}
-			return results
+		values, exists := fancyDiceValues[fancyType]
+		if !exists || len(group.Dice) > len(values) {
+			// The parser checks this, so the registry must have changed since; see DiceSet.Validate.
+			return nil, fmt.Errorf("cannot roll %d exclusive %s dice: the type is no longer registered with enough values", len(group.Dice), fancyType)
 		}
 
-		// Fallback for unknown fancy dice.
-		results := make([]int, len(group.Dice))
-		for i := range results {
-			results[i] = originalType
+		// Use shuffle algorithm to select without replacement.
+		indices := selectWithoutReplacement(len(values), len(group.Dice))
+		results := make([]int, len(indices))
+		for i, index := range indices {
+			results[i] = index // Return 1-based indices
 		}
-		return results
+		return results, nil
 	} else {
 		// Exclusive regular dice.
 		firstDie := group.Dice[0]
 		originalSides := firstDie.Sides - 1000
+		if len(group.Dice) > originalSides {
+			return nil, fmt.Errorf("cannot roll %d exclusive dice with only %d sides", len(group.Dice), originalSides)
+		}
 
 		// Use shuffle algorithm to select without replacement.
-		return selectWithoutReplacement(originalSides, len(group.Dice))
+		return selectWithoutReplacement(originalSides, len(group.Dice)), nil
 	}
 }
 
-// String returns a string representation of the dice set.
+// Validate reports whether the dice set can be rolled. Fancy dice are checked against the
+// registry when notation is parsed, so for parsed sets this only fails if the registry has changed
+// since, for example after ResetFancyDice or loading a custom die with fewer faces. Sets built with
+// NewDiceSet should be validated before rolling too, as their adjacent exclusive dice of the same
+// size form one group that must not outnumber its faces. Rolling an invalid set reports why in
+// RollResult.Err, which RollNotation returns as an error.
+func (ds DiceSet) Validate() error {
+	for _, die := range ds.Dice {
+		var fancyType string
+		switch {
+		case die.Sides < -1000:
+			fancyType = fmt.Sprintf("f%d", -die.Sides-1000)
+		case die.Sides < 0:
+			fancyType = fmt.Sprintf("f%d", -die.Sides)
+		default:
+			continue
+		}
+		if _, exists := fancyDiceValues[fancyType]; !exists {
+			return fmt.Errorf("unsupported fancy dice type: %s", fancyType)
+		}
+	}
+
+	if ds.root != nil {
+		// The parser has already checked the exclusive groups of each pool in the expression.
+		return nil
+	}
+	return checkExclusiveGroups(ds.Dice)
+}
+
+// String returns a string representation of the dice set, e.g. "DiceSet{[3d6 1d20 2f4 3D6]}".
+// Dice are listed in a stable order: regular, then fancy, then exclusive, each by ascending size.
 func (ds DiceSet) String() string {
 	if len(ds.Dice) == 0 {
 		return "empty dice set"
 	}
 
-	// Count dice by sides for compact representation.
-	sidesCounts := make(map[int]int)
-	for _, die := range ds.Dice {
-		sidesCounts[die.Sides]++
+	parts := countDice(ds.Dice, true)
+	return fmt.Sprintf("DiceSet{%v}", parts)
+}
+
+// countDice describes dice compactly as counted groups such as "3d6". If sorted is true, dice of the
+// same kind are counted together and the groups are ordered as in String; otherwise only consecutive
+// identical dice are counted together and the original order is kept.
+func countDice(dice []Die, sorted bool) []string {
+	var kinds []Die
+	var counts []int
+	index := make(map[string]int)
+	for _, die := range dice {
+		notation := die.notation()
+		if sorted {
+			if i, seen := index[notation]; seen {
+				counts[i]++
+				continue
+			}
+			index[notation] = len(kinds)
+		} else if last := len(kinds) - 1; last >= 0 && kinds[last].notation() == notation {
+			counts[last]++
+			continue
+		}
+		kinds = append(kinds, die)
+		counts = append(counts, 1)
 	}
 
-	parts := make([]string, 0, len(sidesCounts)) // Pre-allocate with estimated capacity.
-	for sides, count := range sidesCounts {
-		parts = append(parts, fmt.Sprintf("%dd%d", count, sides))
+	order := make([]int, len(kinds))
+	for i := range order {
+		order[i] = i
+	}
+	if sorted {
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := kinds[order[i]], kinds[order[j]]
+			categoryA, sizeA := a.sortKey()
+			categoryB, sizeB := b.sortKey()
+			if categoryA != categoryB {
+				return categoryA < categoryB
+			}
+			if sizeA != sizeB {
+				return sizeA < sizeB
+			}
+			return a.notation() < b.notation()
+		})
 	}
 
-	return fmt.Sprintf("DiceSet{%v}", parts)
+	parts := make([]string, len(order))
+	for i, k := range order {
+		parts[i] = fmt.Sprintf("%d%s", counts[k], kinds[k].notation())
+	}
+	return parts
+}
+
+// notation returns the dice notation for a single die, e.g. "d6", "d6!", "d10z", "d[3-8]", "f4", "D6",
+// "F52", "d%%" or "d{a,b}", undoing the encoding of fancy and exclusive dice in Sides.
+func (d Die) notation() string {
+	switch {
+	case len(d.Faces) > 0:
+		return d.inlineType()
+	case d.Percentile:
+		return "d%%"
+	case d.Sides < -1000:
+		return fmt.Sprintf("F%d", -d.Sides-1000)
+	case d.Sides < 0:
+		return fmt.Sprintf("f%d", -d.Sides)
+	case d.Sides > 1000:
+		return fmt.Sprintf("D%d", d.Sides-1000)
+	}
+
+	if d.Offset != 0 && d.Offset != -1 {
+		// Range dice cannot explode, so they need no suffix.
+		return fmt.Sprintf("d[%d-%d]", d.Min(), d.Max())
+	}
+	suffix := ""
+	if d.Offset == -1 {
+		suffix = "z"
+	}
+	switch d.Explode {
+	case ExplodeStandard:
+		suffix += "!"
+	case ExplodeCompound:
+		suffix += "!!"
+	case ExplodePenetrating:
+		suffix += "!p"
+	}
+	if d.explodeOn.op != "" {
+		suffix += d.explodeOn.String()
+	}
+	return fmt.Sprintf("d%d%s", d.Sides, suffix)
+}
+
+// sortKey returns the category (regular, fancy, exclusive regular, exclusive fancy) and size
+// used to order dice in String.
+func (d Die) sortKey() (int, int) {
+	switch {
+	case len(d.Faces) > 0:
+		return 1, len(d.Faces)
+	case d.Sides < -1000:
+		return 3, -d.Sides - 1000
+	case d.Sides < 0:
+		return 1, -d.Sides
+	case d.Sides > 1000:
+		return 2, d.Sides - 1000
+	default:
+		return 0, d.Sides
+	}
 }
@@ -1,6 +1,12 @@
 package dice
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -101,6 +107,11 @@ func TestParseDiceNotation(t *testing.T) {
 		{"3d-6", true, 0, "negative sides"},
 		{"abc", true, 0, "non-numeric notation"},
 		{"3d6d4", true, 0, "malformed notation"},
+		{"1d1001", true, 0, "too many sides for the exclusive encoding"},
+		{"2D1001", true, 0, "too many exclusive sides"},
+		{"10001d6", true, 0, "too many dice in a group"},
+		{"99999999999999999999d6", true, 0, "count overflows int"},
+		{"1000d1000", false, 1000, "largest die"},
 	}
 
 	for _, tt := range tests {
@@ -126,6 +137,46 @@ func TestParseDiceNotation(t *testing.T) {
 	}
 }
 
+func TestSpacedNotation(t *testing.T) {
+	tests := []struct {
+		spaced  string
+		compact string
+	}{
+		{"3d6 + 2", "3d6+2"},
+		{"3 d 6", "3d6"},
+		{"3d 6 - 1", "3d6-1"},
+		{"d 20", "d20"},
+		{"2 F 4", "2F4"},
+		{"3 d6", "3+d6"},
+		{"3d6 !", "3d6!"},
+		{"4 d 6 !!", "4d6!!"},
+		{" highest ( 2 d 20 ) + 5 ", "highest(2d20)+5"},
+		{"{ 4d6 , 2d8 }kh3", "{4d6,2d8}kh3"},
+		{"6 d 10 >= 7", "6d10>=7"},
+		{"best( 2d6 + 1 , 1d12 )", "best(2d6+1,1d12)"},
+	}
+
+	for _, test := range tests {
+		want, err := Canonicalize(test.compact)
+		if err != nil {
+			t.Fatalf("Canonicalize(%q) unexpected error: %v", test.compact, err)
+		}
+		got, err := Canonicalize(test.spaced)
+		if err != nil {
+			t.Errorf("Canonicalize(%q) unexpected error: %v", test.spaced, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Canonicalize(%q) = %q, want %q as for %q", test.spaced, got, want, test.compact)
+		}
+	}
+
+	// A spaced suffix is a separate term rather than a selection.
+	if result := MustRollNotation("{2d1} d1"); result.Total != 3 {
+		t.Errorf("{2d1} d1: expected total 3, got %d", result.Total)
+	}
+}
+
 func TestParseDiceNotationSpecificExamples(t *testing.T) {
 	// Test specific examples from the requirements.
 	t.Run("d20 single die", func(t *testing.T) {
@@ -247,11 +298,378 @@ func TestDiceSetString(t *testing.T) {
 	// Test dice set with dice.
 	dice := []Die{NewDie(6), NewDie(6), NewDie(20)}
 	set := NewDiceSet(dice)
-	str := set.String()
+	if str := set.String(); str != "DiceSet{[2d6 1d20]}" {
+		t.Errorf("Expected 'DiceSet{[2d6 1d20]}', got %s", str)
+	}
+
+	// Fancy and exclusive dice are rendered in their own notation, in a stable order.
+	mixed, err := ParseDiceNotation("3D6 2f4 d20 d6! 2F52 d4 f2")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	expected := "DiceSet{[1d4 1d6! 1d20 1f2 2f4 3D6 2F52]}"
+	for i := 0; i < 10; i++ {
+		if str := mixed.String(); str != expected {
+			t.Fatalf("Expected '%s', got %s", expected, str)
+		}
+	}
+}
+
+func TestDiceSetStringRoundTrip(t *testing.T) {
+	for _, notation := range []string{"2f4", "3D6", "2F52", "4d6!!", "2d{red,gr\\,een}", "d20 f13 5D10 3d8!"} {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+
+		str := set.String()
+		inner := strings.TrimSuffix(strings.TrimPrefix(str, "DiceSet{["), "]}")
+		reparsed, err := ParseDiceNotation(inner)
+		if err != nil {
+			t.Errorf("%s: String() gave %s, which does not parse: %v", notation, str, err)
+			continue
+		}
+		if reparsed.String() != str {
+			t.Errorf("%s: round trip changed %s to %s", notation, str, reparsed.String())
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		notation string
+		expected string
+	}{
+		{"d6 d6 d6", "3d6"},
+		{"6d6+3d6", "9d6"},
+		{"2d10 d6", "1d6+2d10"},
+		{"1d20,7d4", "7d4+1d20"},
+		{"f4 d8 2f4 d4", "1d4+1d8+3f4"},
+		{"1d20+5-2", "1d20+3"},
+		{"3d6-2+1d6", "4d6-2"},
+		{"2d6+3-1d4", "2d6-1d4+3"},
+		{"3d6+2-2", "3d6"},
+		{"3D6 d4 2D6", "3D6+1d4+2D6"},
+		{"3D6 + 2D6", "5D6"},
+		{"highest(d20 d20)+5", "highest(2d20)+5"},
+		{"adv", "highest(2d20)"},
+		{"dis-1", "lowest(2d20)-1"},
+		{"sw d8", "1d8!!"},
+		{"{2d8 4d6}kh3", "{4d6+2d8}kh3"},
+		{"{4d6}d1", "{4d6}dl1"},
+		{"d{b,a} d6!", "1d6!+1d{b,a}"},
+		{"1d20 + 5 CRIT 2d6+3", "1d20+5 crit 2d6+3"},
+		{"shared(3D6 d4 2D6)", "shared(3D6+1d4+2D6)"},
+		{"separate(3D6, 2D6)", "separate(3D6, 2D6)"},
+	}
+
+	for _, test := range tests {
+		got, err := Canonicalize(test.notation)
+		if err != nil {
+			t.Errorf("Canonicalize(%q) unexpected error: %v", test.notation, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("Canonicalize(%q) = %q, expected %q", test.notation, got, test.expected)
+		}
+
+		// The canonical form is itself canonical.
+		if again, err := Canonicalize(got); err != nil || again != got {
+			t.Errorf("Canonicalize(%q) = %q, %v; expected it to be unchanged", got, again, err)
+		}
+	}
+
+	if _, err := Canonicalize("nonsense"); err == nil {
+		t.Error("Canonicalize(nonsense) expected error, got nil")
+	}
+}
+
+func TestListFancyDice(t *testing.T) {
+	list := ListFancyDice()
+	if len(list) != len(fancyDiceValues) {
+		t.Fatalf("Expected %d fancy dice, got %d", len(fancyDiceValues), len(list))
+	}
+
+	for i, fancy := range list {
+		if i > 0 && len(list[i-1].Faces) > len(fancy.Faces) {
+			t.Errorf("Fancy dice not ordered by face count: %s before %s", list[i-1].Type, fancy.Type)
+		}
+		if fancy.Type != fmt.Sprintf("f%d", len(fancy.Faces)) {
+			t.Errorf("Fancy die %s has %d faces", fancy.Type, len(fancy.Faces))
+		}
+	}
+
+	// The list is a copy, so changing it does not change the registry.
+	list[0].Faces[0].Name = "changed"
+	if fancyDiceValues[list[0].Type][0].Name == "changed" {
+		t.Error("ListFancyDice exposed the registry's faces")
+	}
+}
+
+func TestRollUnregisteredExclusiveDice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colours.dice")
+	if err := os.WriteFile(path, []byte("red\ngreen\nblue\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	defer ResetFancyDice()
+
+	var diceSets []DiceSet
+	for _, notation := range []string{"2F3", "2F3kh1", "2f3", "{2F3 1d6}dl1"} {
+		diceSet, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+		if err := diceSet.Validate(); err != nil {
+			t.Errorf("Validate(%q) unexpected error: %v", notation, err)
+		}
+		diceSets = append(diceSets, diceSet)
+	}
+
+	ResetFancyDice()
+	for _, diceSet := range diceSets {
+		if err := diceSet.Validate(); err == nil {
+			t.Errorf("Expected Validate of %v to fail once f3 is no longer registered", diceSet)
+		}
+		if result := diceSet.Roll(); result.Err == nil || !strings.Contains(result.Err.Error(), "f3") {
+			t.Errorf("Expected Roll of %v to report the unregistered f3, got %v", diceSet, result.Err)
+		}
+	}
+}
+
+func TestDieConstructors(t *testing.T) {
+	d6 := NewDie(6)
+	exclusive, err := NewExclusiveDie(3)
+	if err != nil {
+		t.Fatalf("NewExclusiveDie unexpected error: %v", err)
+	}
+	suit, err := NewFancyDie("F4")
+	if err != nil {
+		t.Fatalf("NewFancyDie unexpected error: %v", err)
+	}
+	card, err := NewExclusiveFancyDie("f52")
+	if err != nil {
+		t.Fatalf("NewExclusiveFancyDie unexpected error: %v", err)
+	}
+	colour, err := NewInlineDie([]FancyDieValue{{Name: "red", Value: 1}, {Name: "blue", Value: 2}})
+	if err != nil {
+		t.Fatalf("NewInlineDie unexpected error: %v", err)
+	}
+
+	diceSet := NewDiceSet([]Die{d6, exclusive, exclusive, exclusive, suit, card, card, colour})
+	if err := diceSet.Validate(); err != nil {
+		t.Fatalf("Validate unexpected error: %v", err)
+	}
+	if got, want := diceSet.String(), "DiceSet{[1d6 1d{red,blue} 1f4 3D3 2F52]}"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	result := diceSet.Roll()
+	types := make([]string, len(result.DieRolls))
+	for i, roll := range result.DieRolls {
+		types[i] = roll.Type
+	}
+	if want := []string{"d6", "d3", "d3", "d3", "f4", "f52", "f52", "d{red,blue}"}; !slices.Equal(types, want) {
+		t.Errorf("Roll() types = %v, want %v", types, want)
+	}
+	if faces := []int{result.DieRolls[1].Result, result.DieRolls[2].Result, result.DieRolls[3].Result}; slices.Contains(faces[:2], faces[2]) || faces[0] == faces[1] {
+		t.Errorf("Exclusive dice repeated a face: %v", faces)
+	}
+
+	tooMany := NewDiceSet([]Die{exclusive, exclusive, exclusive, exclusive})
+	if err := tooMany.Validate(); err == nil {
+		t.Error("Expected Validate to reject four exclusive d3s")
+	}
+	if result := tooMany.Roll(); result.Err == nil {
+		t.Error("Expected Roll to report four exclusive d3s rather than drop them")
+	}
+	if _, err := NewExclusiveDie(0); err == nil {
+		t.Error("Expected NewExclusiveDie(0) to fail")
+	}
+	if _, err := NewExclusiveDie(maxSides + 1); err == nil || err.Error() != "a die must have between 1 and 1000 sides, got 1001" {
+		t.Errorf("Expected NewExclusiveDie(1001) to fail with the limit of maxSides sides, got %v", err)
+	}
+	if _, err := NewFancyDie("f5"); err == nil {
+		t.Error("Expected NewFancyDie(\"f5\") to fail")
+	}
+	if _, err := NewInlineDie(nil); err == nil {
+		t.Error("Expected NewInlineDie(nil) to fail")
+	}
+}
+
+func TestPoolFunction(t *testing.T) {
+	result := MustRollNotation("pool(d1 d1 2d1)+1")
+	if result.Total != 5 || len(result.DieRolls) != 4 {
+		t.Errorf("pool(d1 d1 2d1)+1: expected four dice totalling 5, got %+v", result)
+	}
+	if _, err := ParseDiceNotation("pool(d6+1)"); err == nil {
+		t.Error("Expected pool() to reject a modifier")
+	}
+
+	// Adjacent exclusive dice of different sizes are separate groups.
+	result = MustRollNotation("3D3 2D8")
+	for _, roll := range result.DieRolls[:3] {
+		if roll.Type != "d3" || roll.Result > 3 {
+			t.Errorf("3D3 2D8: expected three d3s first, got %+v", result.DieRolls)
+		}
+	}
+}
+
+func TestExclusiveDiceWithRepeatedNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runes.dice")
+	if err := os.WriteFile(path, []byte("blank\nstar, 2\nblank\nmoon, 3\nblank\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	defer ResetFancyDice()
+
+	// The faces keep the order of the file, repeated names included.
+	faces, _ := FancyDieFaces("f5")
+	var names []string
+	for _, face := range faces {
+		names = append(names, face.Name)
+	}
+	if strings.Join(names, " ") != "blank star blank moon blank" {
+		t.Fatalf("Expected the faces in file order, got %v", names)
+	}
+
+	// Rolling every face exclusively gives each position once, so "blank" comes up three times.
+	for i := 0; i < 10; i++ {
+		result := MustRollNotation("5F5")
+		seen := make(map[int]bool)
+		blanks := 0
+		for _, roll := range result.DieRolls {
+			if seen[roll.Result] {
+				t.Fatalf("Face %d rolled twice: %+v", roll.Result, result.DieRolls)
+			}
+			seen[roll.Result] = true
+			if roll.FancyValue != faces[roll.Result-1].Name {
+				t.Errorf("Face %d shows %q, want %q", roll.Result, roll.FancyValue, faces[roll.Result-1].Name)
+			}
+			if roll.FancyValue == "blank" {
+				blanks++
+			}
+		}
+		if blanks != 3 {
+			t.Errorf("Expected three blank faces, got %d", blanks)
+		}
+	}
+}
+
+func TestResetFancyDice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.dice")
+	if err := os.WriteFile(path, []byte("gold\nsilver\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	if faces, _ := FancyDieFaces("f2"); faces[0].Name != "gold" {
+		t.Fatalf("Expected the custom f2 to replace the built-in one, got %v", faces)
+	}
+
+	ResetFancyDice()
+	if faces, _ := FancyDieFaces("f2"); faces[0].Name != "heads" {
+		t.Errorf("Expected ResetFancyDice to restore the built-in f2, got %v", faces)
+	}
+
+	// Loading again must not change the built-in defaults.
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	ResetFancyDice()
+	if faces, _ := FancyDieFaces("f2"); faces[0].Name != "heads" {
+		t.Errorf("Expected the built-in f2 to survive a second load, got %v", faces)
+	}
+}
+
+func TestFancyDiceFileSections(t *testing.T) {
+	content := `# A collection of dice
+[notes]
+C#, 1   # sharp notes keep their '#'
+D, 2
+E
+
+[coins] # trailing comments work on headers too
+platinum, 50
+gold, 10
+silver, 5
+copper, 1
+`
+	path := filepath.Join(t.TempDir(), "collection.dice")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	defer ResetFancyDice() // Restore the built-in dice for other tests.
+
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+
+	if faces := fancyDiceValues["f3"]; len(faces) != 3 || faces[0] != (FancyDieValue{"C#", 1}) {
+		t.Errorf("Expected notes to define f3, got %v", faces)
+	}
+	if faces := fancyDiceValues["f4"]; len(faces) != 4 || faces[0] != (FancyDieValue{"platinum", 50}) || faces[3] != (FancyDieValue{"copper", 1}) {
+		t.Errorf("Expected coins to define f4, got %v", faces)
+	}
+
+	content = "[notes]\nC#, 1 # comment\nD, 2\n\n[bells]\nding\ndong\nbong\nclang\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	if faces := fancyDiceValues["f2"]; len(faces) != 2 || faces[0] != (FancyDieValue{"C#", 1}) {
+		t.Errorf("Expected notes to define f2 with a trailing comment stripped, got %v", faces)
+	}
+	if faces := fancyDiceValues["f4"]; len(faces) != 4 || faces[3] != (FancyDieValue{"clang", 4}) {
+		t.Errorf("Expected bells to define f4 with positions restarting at 1, got %v", faces)
+	}
+
+	// Dice are named by their number of values, so two six-value sections cannot both be f6.
+	ResetFancyDice()
+	content = "[runes]\nfehu\nuruz\nthurisaz\nansuz\nraido\nkaunan\n[colours]\nred\norange\nyellow\ngreen\nblue\nviolet\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	err := LoadCustomFancyDice(path)
+	if err == nil || !strings.Contains(err.Error(), "section [runes] and section [colours] both have 6 values") {
+		t.Errorf("Expected an error for two six-value sections, got %v", err)
+	}
+	if faces := fancyDiceValues["f6"]; faces[0].Name == "fehu" || faces[0].Name == "red" {
+		t.Errorf("Expected a rejected file to leave f6 alone, got %v", faces)
+	}
+
+	// A header with no values is an error.
+	if err := os.WriteFile(path, []byte("[empty]\n[full]\na\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	if err := LoadCustomFancyDice(path); err == nil {
+		t.Error("Expected an error for an empty section, got nil")
+	}
+}
+
+func TestFancyDieFaces(t *testing.T) {
+	faces, ok := FancyDieFaces("f2")
+	if !ok || len(faces) != 2 || faces[0] != (FancyDieValue{"heads", 1}) {
+		t.Errorf("FancyDieFaces(f2) = %v, %v", faces, ok)
+	}
 
-	// The exact order may vary due to map iteration, so just check it contains expected parts.
-	if str == "" {
-		t.Error("Expected non-empty string representation")
+	faces[0].Name = "changed"
+	if fancyDiceValues["f2"][0].Name == "changed" {
+		t.Error("FancyDieFaces exposed the registry's faces")
+	}
+
+	for _, name := range []string{"f99", "d6", ""} {
+		if faces, ok := FancyDieFaces(name); ok || faces != nil {
+			t.Errorf("FancyDieFaces(%q) = %v, %v; expected nil, false", name, faces, ok)
+		}
 	}
 }
 
@@ -458,13 +876,13 @@ func TestExclusiveDiceUniqueness(t *testing.T) {
 				t.Fatalf("Expected 3 die rolls, got %d", len(result.DieRolls))
 			}
 
-			// Check uniqueness of fancy values.
-			seenFancy := make(map[string]bool)
+			// Check uniqueness of the faces by position, since names need not be unique.
+			seenFaces := make(map[int]bool)
 			for _, roll := range result.DieRolls {
-				if seenFancy[roll.FancyValue] {
-					t.Errorf("Run %d: Duplicate fancy value '%s' found in exclusive dice roll", i, roll.FancyValue)
+				if seenFaces[roll.Result] {
+					t.Errorf("Run %d: Duplicate face %d (%s) found in exclusive dice roll", i, roll.Result, roll.FancyValue)
 				}
-				seenFancy[roll.FancyValue] = true
+				seenFaces[roll.Result] = true
 
 				// Check that fancy value is populated.
 				if roll.FancyValue == "" {
@@ -534,3 +952,2224 @@ func TestExclusiveErrorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestSharedExclusiveDice(t *testing.T) {
+	// shared(...) keeps every exclusive d6 apart even with a d4 between them.
+	set, err := ParseDiceNotation("shared(3D6 d4 3D6)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(shared(3D6 d4 3D6)) unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 22 || high != 25 {
+		t.Errorf("shared(3D6 d4 3D6) range = %d..%d, expected 22..25", low, high)
+	}
+	for i := 0; i < 10; i++ {
+		seen := make(map[int]bool)
+		for _, roll := range set.Roll().DieRolls {
+			if roll.Type != "d6" {
+				continue
+			}
+			if seen[roll.Result] {
+				t.Errorf("Run %d: duplicate d6 value %d in shared pool", i, roll.Result)
+			}
+			seen[roll.Result] = true
+		}
+		if len(seen) != 6 {
+			t.Errorf("Run %d: expected 6 distinct d6 values, got %d", i, len(seen))
+		}
+	}
+
+	// separate(...) gives each argument its own pool, so each half is a full set of faces.
+	set, err = ParseDiceNotation("separate(6D6, 6D6)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(separate(6D6, 6D6)) unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		rolls := set.Roll().IndividualRolls
+		if len(rolls) != 12 {
+			t.Fatalf("Expected 12 rolls, got %d", len(rolls))
+		}
+		for _, half := range [][]int{rolls[:6], rolls[6:]} {
+			seen := make(map[int]bool)
+			for _, value := range half {
+				seen[value] = true
+			}
+			if len(seen) != 6 {
+				t.Errorf("Run %d: expected each pool to roll 1 to 6, got %v", i, rolls)
+			}
+		}
+	}
+
+	for _, notation := range []string{"3D6 4D6", "shared(3D6 d4 4D6)", "pool(3D6, 4D6)", "shared(1d6+2)"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+		}
+	}
+
+	// Pooling only changes which values are dealt, so the dice keep the order they are written in.
+	tests := []struct {
+		notation string
+		want     []string
+	}{
+		{"shared(2D6, 1d20, 2D6)", []string{"d6", "d6", "d20", "d6", "d6"}},
+		{"shared(1D6 d2! 2F4 1D6 1F4)", []string{"d6", "d2", "f4", "f4", "d6", "f4"}},
+	}
+	for _, tt := range tests {
+		set, err := ParseDiceNotation(tt.notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%s) unexpected error: %v", tt.notation, err)
+		}
+		for i := 0; i < 10; i++ {
+			var types []string
+			for _, roll := range set.Roll().DieRolls {
+				if roll.Type == "d2" && types[len(types)-1] == "d2" {
+					continue // The exploding d2 may roll again.
+				}
+				types = append(types, roll.Type)
+			}
+			if !slices.Equal(types, tt.want) {
+				t.Errorf("%s rolled dice of types %v, want %v as written", tt.notation, types, tt.want)
+			}
+		}
+	}
+}
+
+func TestModifiers(t *testing.T) {
+	tests := []struct {
+		notation     string
+		wantDice     int
+		wantModifier int
+	}{
+		{"1d20+5", 1, 5},
+		{"3d6-2", 3, -2},
+		{"2d6 + 1d8 + 3", 3, 3},
+		{"1d4+2-1", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notation, func(t *testing.T) {
+			set, err := ParseDiceNotation(tt.notation)
+			if err != nil {
+				t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", tt.notation, err)
+			}
+			if len(set.Dice) != tt.wantDice {
+				t.Errorf("ParseDiceNotation(%q) expected %d dice, got %d", tt.notation, tt.wantDice, len(set.Dice))
+			}
+
+			result := set.Roll()
+			if result.Modifier != tt.wantModifier {
+				t.Errorf("ParseDiceNotation(%q) expected modifier %d, got %d", tt.notation, tt.wantModifier, result.Modifier)
+			}
+
+			expectedTotal := tt.wantModifier
+			for _, roll := range result.IndividualRolls {
+				expectedTotal += roll
+			}
+			if result.Total != expectedTotal {
+				t.Errorf("ParseDiceNotation(%q) expected total %d, got %d", tt.notation, expectedTotal, result.Total)
+			}
+		})
+	}
+}
+
+func TestHighestAndLowest(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		set, err := ParseDiceNotation("highest(2d20)+5")
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(highest(2d20)+5) unexpected error: %v", err)
+		}
+
+		result := set.Roll()
+		if len(result.DieRolls) != 2 {
+			t.Fatalf("Expected 2 die rolls, got %d", len(result.DieRolls))
+		}
+
+		first, second := result.DieRolls[0], result.DieRolls[1]
+		if first.Dropped == second.Dropped {
+			t.Fatalf("Expected exactly one dropped die, got %+v", result.DieRolls)
+		}
+
+		kept, dropped := first, second
+		if first.Dropped {
+			kept, dropped = second, first
+		}
+		if kept.Result < dropped.Result {
+			t.Errorf("highest kept %d but dropped %d", kept.Result, dropped.Result)
+		}
+		if result.Total != kept.Result+5 {
+			t.Errorf("Expected total %d, got %d", kept.Result+5, result.Total)
+		}
+	}
+
+	set, err := ParseDiceNotation("lowest(3d6)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(lowest(3d6)) unexpected error: %v", err)
+	}
+	result := set.Roll()
+	lowest := result.IndividualRolls[0]
+	for _, roll := range result.IndividualRolls {
+		lowest = min(lowest, roll)
+	}
+	if result.Total != lowest {
+		t.Errorf("lowest(3d6) expected total %d, got %d", lowest, result.Total)
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []string{
+		"5",
+		"3d6+",
+		"3d6++2",
+		"highest(2d20",
+		"highest(2d20+1)",
+		"highest()",
+		"unknown(2d6)",
+		"3d6 / 0",
+		"1d100 % 0",
+		"3d6 * x",
+		"1d6/2+1",
+		"highest(2d20/2)",
+	}
+
+	for _, notation := range tests {
+		t.Run(notation, func(t *testing.T) {
+			if _, err := ParseDiceNotation(notation); err == nil {
+				t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+			}
+		})
+	}
+}
+
+func TestNaturalD20s(t *testing.T) {
+	result := RollResult{DieRolls: []DieRoll{
+		{Die: NewDie(20), Result: 20},
+		{Die: NewDie(20), Result: 1},
+		{Die: NewDie(20), Result: 1, Dropped: true},
+		{Die: NewDie(6), Result: 1},
+		{Die: NewDie(20), Result: 20, Exploded: true},
+	}}
+
+	if twenties, ones := result.NaturalD20s(); twenties != 2 || ones != 1 {
+		t.Errorf("NaturalD20s() = %d, %d; expected 2, 1", twenties, ones)
+	}
+}
+
+func TestCritNotation(t *testing.T) {
+	critRoll, err := ParseCritNotation("1d20+5 crit 2d6+3")
+	if err != nil {
+		t.Fatalf("ParseCritNotation unexpected error: %v", err)
+	}
+	if critRoll.AttackNotation != "1d20+5" || critRoll.DamageNotation != "2d6+3" {
+		t.Errorf("Unexpected notations %q and %q", critRoll.AttackNotation, critRoll.DamageNotation)
+	}
+
+	// Roll until at least one critical hit and one miss have been seen.
+	sawCritical, sawNormal := false, false
+	for i := 0; i < 2000 && !(sawCritical && sawNormal); i++ {
+		result := critRoll.Roll()
+		natural := result.Attack.DieRolls[0].Result
+		if result.Critical != (natural == 20) {
+			t.Fatalf("Critical = %v for natural %d", result.Critical, natural)
+		}
+
+		wantDamageDice := 2
+		if result.Critical {
+			wantDamageDice = 4
+			sawCritical = true
+		} else {
+			sawNormal = true
+		}
+		if len(result.Damage.DieRolls) != wantDamageDice {
+			t.Fatalf("Expected %d damage dice, got %d", wantDamageDice, len(result.Damage.DieRolls))
+		}
+
+		expectedDamage := 3 // The modifier is not doubled.
+		for _, roll := range result.Damage.IndividualRolls {
+			expectedDamage += roll
+		}
+		if result.Damage.Total != expectedDamage {
+			t.Errorf("Expected damage total %d, got %d", expectedDamage, result.Damage.Total)
+		}
+	}
+	if !sawCritical || !sawNormal {
+		t.Errorf("Expected to see both critical and normal hits (critical=%v, normal=%v)", sawCritical, sawNormal)
+	}
+}
+
+func TestCritKeepsHighestDamage(t *testing.T) {
+	critRoll, err := ParseCritNotation("1d20 crit 4d6kh3+2")
+	if err != nil {
+		t.Fatalf("ParseCritNotation unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2000; i++ {
+		result := critRoll.Roll()
+		if !result.Critical {
+			continue
+		}
+		if len(result.Damage.DieRolls) != 8 {
+			t.Fatalf("Expected 8 damage dice on a critical hit, got %d", len(result.Damage.DieRolls))
+		}
+		dropped, expectedDamage := 0, 2 // The modifier is not doubled.
+		for _, roll := range result.Damage.DieRolls {
+			if roll.Dropped {
+				dropped++
+			} else {
+				expectedDamage += roll.Score
+			}
+		}
+		if dropped != 2 {
+			t.Errorf("Expected the lowest die of each 4d6kh3 to be dropped, got %d dropped", dropped)
+		}
+		if result.Damage.Total != expectedDamage {
+			t.Errorf("Expected damage total %d, got %d", expectedDamage, result.Damage.Total)
+		}
+		return
+	}
+	t.Error("Expected a critical hit in 2000 rolls")
+}
+
+func TestCritNotationErrors(t *testing.T) {
+	tests := []string{
+		"1d20+5",
+		"1d20 crit 2d6 crit 1d6",
+		"1d12 crit 2d6",
+		"1d20 crit nonsense",
+	}
+
+	for _, notation := range tests {
+		t.Run(notation, func(t *testing.T) {
+			if _, err := ParseCritNotation(notation); err == nil {
+				t.Errorf("ParseCritNotation(%q) expected error, got nil", notation)
+			}
+		})
+	}
+}
+
+func TestInlineFancyDice(t *testing.T) {
+	tests := []struct {
+		notation  string
+		wantDice  int
+		wantType  string
+		wantFaces map[string]int // Face name to scoring value
+	}{
+		{"d{2,3,5,7}", 1, "d{2,3,5,7}", map[string]int{"2": 2, "3": 3, "5": 5, "7": 7}},
+		{"2d{red, green, blue}", 2, "d{red,green,blue}", map[string]int{"red": 1, "green": 2, "blue": 3}},
+		{`d{a\,b,c}`, 1, `d{a\,b,c}`, map[string]int{"a,b": 1, "c": 2}},
+		{"d{-1,0,1}+2", 1, "d{-1,0,1}", map[string]int{"-1": -1, "0": 0, "1": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notation, func(t *testing.T) {
+			set, err := ParseDiceNotation(tt.notation)
+			if err != nil {
+				t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", tt.notation, err)
+			}
+			if len(set.Dice) != tt.wantDice {
+				t.Fatalf("ParseDiceNotation(%q) expected %d dice, got %d", tt.notation, tt.wantDice, len(set.Dice))
+			}
+
+			for i := 0; i < 20; i++ {
+				result := set.Roll()
+				total := result.Modifier
+				for _, roll := range result.DieRolls {
+					if roll.Type != tt.wantType {
+						t.Errorf("Expected type %q, got %q", tt.wantType, roll.Type)
+					}
+					value, known := tt.wantFaces[roll.FancyValue]
+					if !known {
+						t.Fatalf("Unexpected face %q", roll.FancyValue)
+					}
+					total += value
+				}
+				if result.Total != total {
+					t.Errorf("Expected total %d, got %d", total, result.Total)
+				}
+			}
+		})
+	}
+}
+
+func TestInlineFancyDiceErrors(t *testing.T) {
+	tests := []string{
+		"d{}",
+		"d{a,,b}",
+		"d{a,b",
+		`d{a\`,
+		"0d{a,b}",
+	}
+
+	for _, notation := range tests {
+		t.Run(notation, func(t *testing.T) {
+			if _, err := ParseDiceNotation(notation); err == nil {
+				t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+			}
+		})
+	}
+}
+
+func TestDiceSetRange(t *testing.T) {
+	tests := []struct {
+		notation string
+		wantMin  int
+		wantMax  int
+	}{
+		{"3d6+2", 5, 20},
+		{"1d20-1d4", -3, 19},
+		{"3D6", 6, 15},
+		{"f2", 0, 1},
+		{"f13", 0, 4},
+		{"2F4", 3, 7},
+		{"highest(2d20)+5", 6, 25},
+		{"lowest(d4 d8)", 1, 4},
+		{"d{-1,0,1} d{-1,0,1}", -2, 2},
+		{"3d6!", 3, 1818},
+		{"3d6!p", 3, 1518},
+		{"3d6!!", 3, 1818},
+		{"3d10!>=6", 3, 3030},
+		{"d6!<3", 3, 206},
+		{"d6!!<3", 3, 206},
+		{"d6!p<3", 3, 106},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notation, func(t *testing.T) {
+			set, err := ParseDiceNotation(tt.notation)
+			if err != nil {
+				t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", tt.notation, err)
+			}
+
+			low, high := set.Range()
+			if low != tt.wantMin || high != tt.wantMax {
+				t.Errorf("Range(%q) = (%d, %d), want (%d, %d)", tt.notation, low, high, tt.wantMin, tt.wantMax)
+			}
+
+			// Every roll must land inside the range.
+			for i := 0; i < 50; i++ {
+				if total := set.Roll().Total; total < low || total > high {
+					t.Errorf("Roll of %q gave %d outside range [%d, %d]", tt.notation, total, low, high)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSeed(t *testing.T) {
+	defer func() { seeded = nil }() // Restore the unseeded generator for other tests.
+
+	set, err := ParseDiceNotation("10d20 f52 3D6")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+
+	SetSeed(42)
+	first := set.Roll()
+	SetSeed(42)
+	second := set.Roll()
+
+	if len(first.IndividualRolls) != len(second.IndividualRolls) {
+		t.Fatalf("Seeded rolls have different lengths")
+	}
+	for i := range first.IndividualRolls {
+		if first.IndividualRolls[i] != second.IndividualRolls[i] {
+			t.Errorf("Seeded rolls differ at %d: %v vs %v", i, first.IndividualRolls, second.IndividualRolls)
+			break
+		}
+	}
+}
+
+func TestSetAverage(t *testing.T) {
+	SetAverage(true)
+	defer SetAverage(false)
+
+	tests := []struct {
+		notation string
+		want     []int
+		faces    []string
+	}{
+		{"3d6+2", []int{4, 4, 4}, nil},
+		{"d20 d10z d[3-8] d%%", []int{11, 5, 6, 51}, nil},
+		{"f4", []int{2}, []string{"♥"}},
+		{"d{red,green,blue}", []int{2}, []string{"green"}},
+		// An average that would explode does not, rather than exploding up to the cap.
+		{"d2!", []int{2}, nil},
+		{"3d10!>=6", []int{6, 6, 6}, nil},
+		{"d2!!", []int{2}, nil},
+	}
+	for _, tt := range tests {
+		result := MustRollNotation(tt.notation)
+		if !slices.Equal(result.IndividualRolls, tt.want) {
+			t.Errorf("%s with averages = %v, want %v", tt.notation, result.IndividualRolls, tt.want)
+		}
+		for i, face := range tt.faces {
+			if result.DieRolls[i].FancyValue != face {
+				t.Errorf("%s with averages shows %q, want %q", tt.notation, result.DieRolls[i].FancyValue, face)
+			}
+		}
+	}
+
+	// Exclusive dice still differ.
+	if rolls := MustRollNotation("3D6").IndividualRolls; rolls[0] == rolls[1] || rolls[1] == rolls[2] || rolls[0] == rolls[2] {
+		t.Errorf("3D6 with averages = %v, want three different faces", rolls)
+	}
+}
+
+func TestExplodingDice(t *testing.T) {
+	set, err := ParseDiceNotation("3d4!")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(3d4!) unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := set.Roll()
+		chains, total := 0, 0
+		for j, roll := range result.DieRolls {
+			if !roll.Exploded {
+				chains++
+			} else if result.DieRolls[j-1].Result != 4 {
+				t.Fatalf("Die exploded after a %d: %+v", result.DieRolls[j-1].Result, result.DieRolls)
+			}
+			total += roll.Result
+		}
+		if chains != 3 {
+			t.Errorf("Expected 3 explosion chains, got %d", chains)
+		}
+		if result.Total != total {
+			t.Errorf("Expected total %d, got %d", total, result.Total)
+		}
+	}
+}
+
+func TestCompoundingDice(t *testing.T) {
+	set, err := ParseDiceNotation("2d4!!")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(2d4!!) unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := set.Roll()
+		if len(result.DieRolls) != 2 {
+			t.Fatalf("Expected 2 compounded dice, got %d", len(result.DieRolls))
+		}
+		for _, roll := range result.DieRolls {
+			sum := 0
+			for j, value := range roll.Rolls {
+				if j < len(roll.Rolls)-1 && value != 4 {
+					t.Errorf("Chain continued after a %d: %v", value, roll.Rolls)
+				}
+				sum += value
+			}
+			if roll.Result != sum {
+				t.Errorf("Compounded result %d does not match chain %v", roll.Result, roll.Rolls)
+			}
+		}
+	}
+}
+
+func TestPenetratingDice(t *testing.T) {
+	set, err := ParseDiceNotation("3d4!p")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(3d4!p) unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := set.Roll()
+		chains, total := 0, 0
+		for j, roll := range result.DieRolls {
+			if roll.Penetrated != roll.Exploded {
+				t.Fatalf("Only the explosions of a penetrating die should be penetrated: %+v", roll)
+			}
+			if !roll.Exploded {
+				chains++
+			} else {
+				// The die before exploded on its roll, which for a penetrated die is one more than its result.
+				previous := result.DieRolls[j-1]
+				rolled := previous.Result
+				if previous.Penetrated {
+					rolled++
+				}
+				if rolled != 4 || roll.Result < 0 || roll.Result > 3 {
+					t.Fatalf("Unexpected chain %+v", result.DieRolls)
+				}
+			}
+			total += roll.Result
+		}
+		if chains != 3 || result.Total != total {
+			t.Errorf("Expected 3 chains adding up to %d, got %d chains and total %d", total, chains, result.Total)
+		}
+	}
+
+	for _, tt := range []struct{ notation, canonical string }{
+		{"3d6 !p", "3d6!p"},
+		{"3d6!p>=5", "3d6!p>=5"},
+		{"3d6!p >=5", "3d6!p >=5"},
+	} {
+		got, err := Canonicalize(tt.notation)
+		if err != nil || got != tt.canonical {
+			t.Errorf("Canonicalize(%q) = %q, %v, want %q", tt.notation, got, err, tt.canonical)
+		}
+	}
+	for _, notation := range []string{"d1!p", "d6z!p", "d6!pp"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestExplodeOnCondition(t *testing.T) {
+	set, err := ParseDiceNotation("4d10!>=9")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(4d10!>=9) unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := set.Roll()
+		chains := 0
+		for j, roll := range result.DieRolls {
+			if !roll.Exploded {
+				chains++
+			} else if previous := result.DieRolls[j-1].Result; previous < 9 {
+				t.Fatalf("Die exploded after a %d: %+v", previous, result.DieRolls)
+			}
+			if j+1 < len(result.DieRolls) && roll.Result >= 9 && !result.DieRolls[j+1].Exploded {
+				t.Fatalf("Die did not explode after a %d: %+v", roll.Result, result.DieRolls)
+			}
+		}
+		if chains != 4 {
+			t.Errorf("Expected 4 explosion chains, got %d", chains)
+		}
+	}
+
+	// A condition touching the "!" belongs to the explosion, and a spaced one counts successes.
+	tests := []struct {
+		notation  string
+		canonical string
+	}{
+		{"3d10!>=9", "3d10!>=9"},
+		{"3d10 !>9", "3d10!>9"},
+		{"3d10 ! >9", "3d10! >9"},
+		{"2d6!!>4", "2d6!!>4"},
+		{"6d10!>=8>=7", "6d10!>=8>=7"},
+		{"3d10! >=9", "3d10! >=9"},
+		{"3d10!>=9 >=9", "3d10!>=9>=9"},
+	}
+	for _, tt := range tests {
+		got, err := Canonicalize(tt.notation)
+		if err != nil || got != tt.canonical {
+			t.Errorf("Canonicalize(%q) = %q, %v, want %q", tt.notation, got, err, tt.canonical)
+		}
+	}
+	if result := MustRollNotation("3d10! >=9"); result.DieRolls[0].Die.explodeOn.op != "" {
+		t.Errorf("3d10! >=9 should explode on the maximum only, got %v", result.DieRolls[0].Die.explodeOn)
+	}
+
+	for _, notation := range []string{"d6!>6", "d6!>=1", "d6!<7", "d6!>=", "d6>=5!"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestSavageWorlds(t *testing.T) {
+	set, err := ParseDiceNotation("sw d8")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(sw d8) unexpected error: %v", err)
+	}
+	if result := set.Roll(); len(result.DieRolls) != 1 || result.DieRolls[0].Die.Explode != ExplodeCompound {
+		t.Errorf("sw d8 expected a single acing die, got %+v", result.DieRolls)
+	}
+
+	set, err = ParseDiceNotation("swwild d8+2")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(swwild d8+2) unexpected error: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		result := set.Roll()
+		if len(result.DieRolls) != 2 {
+			t.Fatalf("Expected trait and wild die, got %d dice", len(result.DieRolls))
+		}
+		trait, wild := result.DieRolls[0], result.DieRolls[1]
+		if trait.Type != "d8" || wild.Type != "d6" {
+			t.Errorf("Expected d8 trait and d6 wild die, got %s and %s", trait.Type, wild.Type)
+		}
+		if result.Total != max(trait.Result, wild.Result)+2 {
+			t.Errorf("Expected the higher die plus 2, got %d from %d and %d", result.Total, trait.Result, wild.Result)
+		}
+	}
+
+	for _, notation := range []string{"sw", "sw 2d8", "sw f6", "swwild D8", "d1!"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+		}
+	}
+}
+
+func TestAdvantage(t *testing.T) {
+	tests := []struct {
+		notation string
+		highest  bool
+		modifier int
+	}{
+		{"adv", true, 0},
+		{"1d20adv", true, 0},
+		{"d20ADV+5", true, 5},
+		{"dis", false, 0},
+		{"1d20dis-1", false, -1},
+	}
+
+	for _, test := range tests {
+		set, err := ParseDiceNotation(test.notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", test.notation, err)
+		}
+
+		for i := 0; i < 20; i++ {
+			result := set.Roll()
+			if len(result.DieRolls) != 2 {
+				t.Fatalf("%s: expected two d20 rolls, got %d", test.notation, len(result.DieRolls))
+			}
+			first, second := result.DieRolls[0], result.DieRolls[1]
+			if first.Dropped == second.Dropped {
+				t.Errorf("%s: expected exactly one d20 to be dropped, got %+v", test.notation, result.DieRolls)
+			}
+
+			kept := min(first.Result, second.Result)
+			if test.highest {
+				kept = max(first.Result, second.Result)
+			}
+			if result.Total != kept+test.modifier {
+				t.Errorf("%s: expected total %d, got %d", test.notation, kept+test.modifier, result.Total)
+			}
+		}
+	}
+
+	for _, notation := range []string{"2d20adv", "d6adv", "advantage"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+		}
+	}
+}
+
+func TestBestAndWorst(t *testing.T) {
+	// Ties go to the earlier option, and only the chosen option's dice and modifiers count.
+	result := MustRollNotation("best(2d1+1, 1d1, 3d1)")
+	if result.Total != 3 || result.Modifier != 1 {
+		t.Errorf("best: expected total 3 with modifier 1, got %+v", result)
+	}
+	want := []Alternative{{"2d1+1", 3, true}, {"1d1", 1, false}, {"3d1", 3, false}}
+	if !slices.Equal(result.Alternatives, want) {
+		t.Errorf("best: expected alternatives %v, got %v", want, result.Alternatives)
+	}
+	for i, roll := range result.DieRolls {
+		if roll.Dropped != (i >= 2) {
+			t.Errorf("best: die %d dropped = %v", i, roll.Dropped)
+		}
+	}
+
+	result = MustRollNotation("worst(2d1+1, 1d1, 3d1)+2")
+	if result.Total != 3 || result.Modifier != 2 || !result.Alternatives[1].Chosen {
+		t.Errorf("worst: expected the 1d1 option plus 2, got %+v", result)
+	}
+
+	set, err := ParseDiceNotation("best(2d6+1, 1d12)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 3 || high != 13 {
+		t.Errorf("best(2d6+1, 1d12): expected range 3..13, got %d..%d", low, high)
+	}
+	for i := 0; i < 20; i++ {
+		result := set.Roll()
+		if result.Total != max(result.Alternatives[0].Total, result.Alternatives[1].Total) {
+			t.Errorf("best(2d6+1, 1d12): total %d is not the best of %v", result.Total, result.Alternatives)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	result := MustRollNotation("3d1+20 clamp(1,20)")
+	if result.Total != 20 || result.Clamped == nil || result.Clamped.Unclamped != 23 {
+		t.Errorf("3d1+20 clamp(1,20): expected 20 clamped from 23, got %+v", result)
+	} else if got := result.Clamped.String(); got != "would be 23, clamped to 20" {
+		t.Errorf("Clamp.String() = %q", got)
+	}
+
+	result = MustRollNotation("1d1-5 clamp(-2, 10)")
+	if result.Total != -2 || result.Clamped == nil {
+		t.Errorf("1d1-5 clamp(-2, 10): expected -2, got %+v", result)
+	}
+
+	result = MustRollNotation("2d1 clamp(1,20)")
+	if result.Total != 2 || result.Clamped != nil {
+		t.Errorf("2d1 clamp(1,20): expected an unchanged 2, got %+v", result)
+	}
+
+	set, err := ParseDiceNotation("1d20+5 clamp(1,20)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 6 || high != 20 {
+		t.Errorf("1d20+5 clamp(1,20): expected range 6..20, got %d..%d", low, high)
+	}
+
+	for _, notation := range []string{"1d20 clamp(20,1)", "clamp(1,20)", "1d20 clamp(1)", "1d20 clamp(1,d6)", "1d20 clamp(1,20) 1d4"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	defer SetRounding(RoundDown)
+
+	tests := []struct {
+		notation string
+		rounding Rounding
+		want     int
+		scale    string
+	}{
+		{"7d1/2", RoundDown, 3, "7 / 2 = 3 rounded down"},
+		{"7d1/2", RoundUp, 4, "7 / 2 = 4 rounded up"},
+		{"7d1/2", RoundNearest, 4, "7 / 2 = 4 rounded to nearest"},
+		{"1d1-8 / 3", RoundDown, -3, "-7 / 3 = -3 rounded down"},
+		{"1d1-8 / 3", RoundUp, -2, "-7 / 3 = -2 rounded up"},
+		{"1d1-8 / 2", RoundNearest, -4, "-7 / 2 = -4 rounded to nearest"},
+		{"4d1+2 / 2", RoundUp, 3, "6 / 2 = 3"},
+		{"5d1*3/2", RoundDown, 7, "5 * 3 / 2 = 7 rounded down"},
+		{"2d1 * 4", RoundDown, 8, "2 * 4 = 8"},
+		{"57d1%10", RoundDown, 7, "57 % 10 = 7"},
+		{"1d1-8 % 5", RoundDown, 3, "-7 % 5 = 3"},
+		{"57d1/10%3", RoundUp, 0, "57 / 10 % 3 = 0 rounded up"},
+	}
+	for _, tt := range tests {
+		SetRounding(tt.rounding)
+		result := MustRollNotation(tt.notation)
+		if result.Total != tt.want || result.Scaled == nil {
+			t.Errorf("%s rounding %s: expected %d, got %+v", tt.notation, tt.rounding, tt.want, result)
+		} else if got := result.Scaled.String(); got != tt.scale {
+			t.Errorf("%s rounding %s: Scale.String() = %q, want %q", tt.notation, tt.rounding, got, tt.scale)
+		}
+	}
+
+	SetRounding(RoundDown)
+	set, err := ParseDiceNotation("1d6/2 clamp(1,3)")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 1 || high != 3 {
+		t.Errorf("1d6/2 clamp(1,3): expected range 1..3, got %d..%d", low, high)
+	}
+	for notation, want := range map[string][2]int{"1d100%10": {0, 9}, "1d4+4 % 10": {5, 8}, "d6*2%5 *3": {0, 12}, "d%%%10": {0, 9}} {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+		if low, high := set.Range(); low != want[0] || high != want[1] {
+			t.Errorf("%s: expected range %d..%d, got %d..%d", notation, want[0], want[1], low, high)
+		}
+	}
+	if got, err := Canonicalize("d6+1/2"); err != nil || got != "1d6+1 / 2" {
+		t.Errorf("Canonicalize(\"d6+1/2\") = %q, %v", got, err)
+	}
+
+	for _, name := range []string{"floor", "CEIL", "nearest"} {
+		if _, err := ParseRounding(name); err != nil {
+			t.Errorf("ParseRounding(%q) unexpected error: %v", name, err)
+		}
+	}
+	if _, err := ParseRounding("up"); err == nil {
+		t.Errorf("ParseRounding(\"up\") expected an error")
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	defer SetCancellations(nil)
+
+	// Without any pairs, faces are not read as symbols.
+	if result := MustRollNotation("2d{success}"); result.Symbols != nil {
+		t.Errorf("Expected no symbols without cancellations, got %v", result.Symbols)
+	}
+
+	if err := SetCancellations([]string{"success/failure", "advantage/threat"}); err != nil {
+		t.Fatalf("SetCancellations() unexpected error: %v", err)
+	}
+	roll := func(faces ...string) DieRoll {
+		return DieRoll{FancyValue: strings.Join(faces, " ")}
+	}
+	dropped := roll("success", "success")
+	dropped.Dropped = true
+
+	tests := []struct {
+		rolls []DieRoll
+		want  string
+	}{
+		{[]DieRoll{roll("success", "success"), roll("failure"), roll("threat")}, "1 success, 1 threat"},
+		{[]DieRoll{roll("triumph", "success"), roll(blankFace), roll("advantage"), roll("failure", "threat")}, "1 triumph"},
+		{[]DieRoll{roll("success"), roll("failure")}, "all symbols cancelled"},
+		{[]DieRoll{dropped, roll("failure")}, "1 failure"},
+	}
+	for _, tt := range tests {
+		symbols := tallySymbols(tt.rolls)
+		if symbols == nil || symbols.String() != tt.want {
+			t.Errorf("tallySymbols() = %v, want %q", symbols, tt.want)
+		}
+	}
+
+	// Fancy dice without any paired symbols, such as cards, are not reported.
+	if symbols := tallySymbols([]DieRoll{roll("A♠"), {Result: 3}}); symbols != nil {
+		t.Errorf("Expected no symbols for ordinary faces, got %v", symbols)
+	}
+	if result := MustRollNotation("3d{success}"); result.Symbols == nil || result.Symbols.String() != "3 success" {
+		t.Errorf("3d{success} symbols = %v, want 3 success", result.Symbols)
+	}
+
+	for _, pairs := range [][]string{{"success"}, {"success/"}, {"success/success"}, {"a/b/c"}} {
+		if err := SetCancellations(pairs); err == nil {
+			t.Errorf("SetCancellations(%q) expected an error", pairs)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, notation := range []string{"3d6", "1d20+5 #attack", "{4d6}kh3", "2d6 keep>=4", "3d10!>=9"} {
+		if valid, err := Validate(notation); !valid || err != nil {
+			t.Errorf("Validate(%q) = %v, %v, want valid", notation, valid, err)
+		}
+	}
+
+	// Validate reports the same errors as ParseDiceNotation.
+	for _, notation := range []string{"", "5", "3d6+", "2d", "d6!>6", "x"} {
+		_, want := ParseDiceNotation(notation)
+		valid, err := Validate(notation)
+		if valid || err == nil || want == nil || err.Error() != want.Error() {
+			t.Errorf("Validate(%q) = %v, %v, want the error %v", notation, valid, err, want)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		notation string
+		want     []string
+	}{
+		{"3d6+2", nil},
+		{"1d1 d1", []string{"d1 always shows 1"}},
+		{"d[5-5] d{gold}", []string{"d[5-5] always shows 5", "d{gold} always shows gold"}},
+		{"3D3", []string{"3D3 deals every face, so only the order varies"}},
+		{"2D3 4F4", []string{"4F4 deals every face, so only the order varies"}},
+	}
+	for _, tt := range tests {
+		_, warnings, err := ParseDiceNotationWithWarnings(tt.notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotationWithWarnings(%q) unexpected error: %v", tt.notation, err)
+		}
+		if !slices.Equal(warnings, tt.want) {
+			t.Errorf("ParseDiceNotationWithWarnings(%q) warnings = %q, want %q", tt.notation, warnings, tt.want)
+		}
+	}
+
+	if _, _, err := ParseDiceNotationWithWarnings("3d"); err == nil {
+		t.Error("Expected an error for invalid notation")
+	}
+}
+
+func TestKeepIf(t *testing.T) {
+	diceSet, err := ParseDiceNotation("6d6 keep>=5 + 2")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	if low, high := diceSet.Range(); low != 2 || high != 38 {
+		t.Errorf("Range() = %d, %d, want 2, 38", low, high)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := diceSet.Roll()
+		kept, sum := 0, 2
+		for _, roll := range result.DieRolls {
+			if roll.Dropped != (roll.Score < 5) {
+				t.Fatalf("Die %d dropped = %v, want dice below 5 dropped", roll.Score, roll.Dropped)
+			}
+			if !roll.Dropped {
+				kept++
+				sum += roll.Score
+			}
+		}
+		if result.Total != sum || result.KeptIf == nil || result.KeptIf.Kept != kept || result.KeptIf.Rolled != 6 {
+			t.Fatalf("Total %d, KeptIf %+v, want total %d and %d of 6 kept", result.Total, result.KeptIf, sum, kept)
+		}
+	}
+
+	// When no dice meet the condition the total is 0.
+	result := MustRollNotation("3d1 keep>1")
+	if result.Total != 0 || result.KeptIf.String() != "kept 0 of 3 dice meeting >1" {
+		t.Errorf("3d1 keep>1 = %d, %v, want 0 and nothing kept", result.Total, result.KeptIf)
+	}
+
+	query, err := ParseChanceQuery("2d6 keep>=5 >= 5")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery() unexpected error: %v", err)
+	}
+	// A total of at least 5 needs at least one die showing 5 or 6: 1 - (4/6)^2.
+	if chance, err := query.Chance(1000); err != nil || !chance.Exact || math.Abs(chance.Probability-20.0/36) > 1e-9 {
+		t.Errorf("Chance() = %+v, %v, want exactly 20/36", chance, err)
+	}
+
+	if got, err := Canonicalize("6d6 KEEP >= 5"); err != nil || got != "6d6 keep>=5" {
+		t.Errorf("Canonicalize() = %q, %v, want %q", got, err, "6d6 keep>=5")
+	}
+	for _, notation := range []string{"5 keep>=5", "6d10>=7 keep>=5", "6d6 keep>="} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestConditionalBonus(t *testing.T) {
+	result := MustRollNotation("1d1+17 on>=1 add 2d1+1")
+	if result.Total != 21 || result.Bonus == nil || !result.Bonus.Triggered || result.Bonus.Added != 3 || len(result.DieRolls) != 3 {
+		t.Errorf("1d1+17 on>=1 add 2d1+1: expected the bonus of 3 to be added, got %+v", result)
+	} else if got := result.Bonus.String(); got != "bonus triggered: natural 1 meets >=1, adding 3" {
+		t.Errorf("Bonus.String() = %q", got)
+	}
+
+	// The condition tests the natural roll, so a modifier that lifts the total past it does not count.
+	result = MustRollNotation("1d1+17 on>=18 add 2d1+1")
+	if result.Total != 18 || result.Bonus == nil || result.Bonus.Triggered || len(result.DieRolls) != 1 || result.Modifier != 17 {
+		t.Errorf("1d1+17 on>=18 add 2d1+1: expected no bonus dice to be rolled, got %+v", result)
+	} else if got := result.Bonus.String(); got != "no bonus: natural 1 does not meet >=18" {
+		t.Errorf("Bonus.String() = %q", got)
+	}
+	set, err := ParseDiceNotation("1d20+5 on>=18 add 1d6")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		result := set.Roll()
+		natural := result.DieRolls[0].Result
+		if result.Bonus.Roll != natural || result.Bonus.Triggered != (natural >= 18) {
+			t.Fatalf("1d20+5 on>=18 add 1d6 with a natural %d: got %+v", natural, result.Bonus)
+		}
+	}
+	// The first kept die is the primary one.
+	for i := 0; i < 50; i++ {
+		result := MustRollNotation("adv+5 on>=18 add 1d6")
+		if kept := max(result.DieRolls[0].Result, result.DieRolls[1].Result); result.Bonus.Roll != kept {
+			t.Fatalf("adv+5 on>=18 add 1d6 kept %d but tested %d", kept, result.Bonus.Roll)
+		}
+	}
+
+	if low, high := set.Range(); low != 6 || high != 31 {
+		t.Errorf("1d20+5 on>=18 add 1d6: expected range 6..31, got %d..%d", low, high)
+	}
+	if set, err = ParseDiceNotation("1d4+20 on>=18 add 1d6"); err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 21 || high != 24 {
+		t.Errorf("1d4+20 on>=18 add 1d6: expected range 21..24 as a d4 never shows 18, got %d..%d", low, high)
+	}
+	if got, _ := Canonicalize("d20 + 5 on >= 18 add d6 d6"); got != "1d20+5 on>=18 add 2d6" {
+		t.Errorf("Canonicalize() = %q", got)
+	}
+
+	// A natural 20 adds a d20, so only 20 plus 1 reaches 21: 1/20 * 1/20.
+	query, err := ParseChanceQuery("1d20 on>=20 add 1d20 >= 21")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery unexpected error: %v", err)
+	}
+	if chance, err := query.Chance(100); err != nil || !chance.Exact || math.Abs(chance.Probability-0.05) > 1e-9 {
+		t.Errorf("Chance() = %+v, %v, want exactly 0.05", chance, err)
+	}
+	// Natural 18, 19 and 20 need at least 3, 2 and 1 on the d6: 1/20 * (4/6 + 5/6 + 6/6).
+	query, err = ParseChanceQuery("1d20+5 on>=18 add 1d6 >= 26")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery unexpected error: %v", err)
+	}
+	if chance, err := query.Chance(100); err != nil || !chance.Exact || math.Abs(chance.Probability-0.125) > 1e-9 {
+		t.Errorf("Chance() = %+v, %v, want exactly 0.125", chance, err)
+	}
+
+	for _, notation := range []string{"1d20 on>=18", "1d20 on>=18 add", "1d20 on>=18 1d6", "1d20 on>=x add 1d6", "1d20 on>=5 add 1d6 on>=3 add 1d4"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestSetSeparators(t *testing.T) {
+	if err := SetSeparators([]string{"and", "&"}); err != nil {
+		t.Fatalf("SetSeparators unexpected error: %v", err)
+	}
+	defer SetSeparators(nil)
+
+	for _, notation := range []string{"2d6 and 1d8", "2d6 AND 1d8", "2d6&1d8", "2d6 & 1d8"} {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Errorf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+			continue
+		}
+		if got := set.String(); got != "DiceSet{[2d6 1d8]}" {
+			t.Errorf("ParseDiceNotation(%q) = %s, want DiceSet{[2d6 1d8]}", notation, got)
+		}
+	}
+
+	// Separators must be whole words.
+	if _, err := ParseDiceNotation("2d6 andy 1d8"); err == nil {
+		t.Error("Expected 'andy' to be rejected")
+	}
+
+	for _, separator := range []string{"*", "/", "+", "(", "d6", "adv", "best", "crit", "&&", "x1"} {
+		if err := SetSeparators([]string{separator}); err == nil {
+			t.Errorf("Expected SetSeparators to reject %q", separator)
+		}
+	}
+
+	// Without extra separators the defaults are unchanged.
+	SetSeparators(nil)
+	if _, err := ParseDiceNotation("2d6 and 1d8"); err == nil {
+		t.Error("Expected 'and' to be rejected once the separators are reset")
+	}
+}
+
+func TestSuccessCounting(t *testing.T) {
+	tests := []struct {
+		notation  string
+		successes int
+	}{
+		{"6d1>=1", 6},
+		{"6d1>1", 0},
+		{"3d1=1 2d1<1", 3},
+		{"4d1<=1+2", 4},
+		{"{4d1 2}kh2>=1", 2},
+	}
+
+	for _, test := range tests {
+		set, err := ParseDiceNotation(test.notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", test.notation, err)
+		}
+		if !set.CountsSuccesses() {
+			t.Errorf("%s: expected CountsSuccesses to be true", test.notation)
+		}
+		result := set.Roll()
+		if result.Successes != test.successes {
+			t.Errorf("%s: expected %d successes, got %d", test.notation, test.successes, result.Successes)
+		}
+	}
+
+	// The total counts successes, plus any modifiers outside the counted dice.
+	result := MustRollNotation("4d1<=1+2")
+	if result.Total != 6 || result.Modifier != 2 || !result.DieRolls[0].Success {
+		t.Errorf("4d1<=1+2: expected total 6 with modifier 2, got %+v", result)
+	}
+
+	set, err := ParseDiceNotation("6d10>=7")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != 0 || high != 6 {
+		t.Errorf("6d10>=7: expected range 0..6, got %d..%d", low, high)
+	}
+	for i := 0; i < 20; i++ {
+		for _, roll := range set.Roll().DieRolls {
+			if roll.Success != (roll.Result >= 7) {
+				t.Errorf("6d10>=7: roll %d marked success=%v", roll.Result, roll.Success)
+			}
+		}
+	}
+
+	if set, _ := ParseDiceNotation("6d10"); set.CountsSuccesses() {
+		t.Error("6d10: expected CountsSuccesses to be false")
+	}
+	for _, notation := range []string{"6d10>=", "6d10>=x", "5>=3", "1d6+2 >= 3"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestRollOverflow(t *testing.T) {
+	tests := []string{
+		"9223372036854775807+1d1",
+		"1d1+9223372036854775807",
+		"1d1-9223372036854775807-3",
+		"{2d1 9223372036854775807}kh2",
+	}
+
+	for _, notation := range tests {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+		if result := set.Roll(); !result.Overflow {
+			t.Errorf("%s: expected Overflow to be set, got total %d", notation, result.Total)
+		}
+		if _, err := RollNotation(notation); err == nil {
+			t.Errorf("RollNotation(%q) expected an error", notation)
+		}
+	}
+
+	if result := MustRollNotation("1d1+9223372036854775806"); result.Overflow {
+		t.Errorf("Expected a total of exactly MaxInt64 not to overflow, got %+v", result)
+	}
+}
+
+func TestReseed(t *testing.T) {
+	defer func() { seeded = nil }() // Restore the unseeded generator for other tests.
+
+	if _, isSeeded := Seed(); isSeeded || GeneratorName() != "ChaCha8" {
+		t.Fatal("Expected the dice to start unseeded")
+	}
+
+	set, _ := ParseDiceNotation("10d20")
+	seed := Reseed()
+	if current, isSeeded := Seed(); !isSeeded || current != seed || GeneratorName() != "PCG" {
+		t.Errorf("Expected Seed() to report %d from PCG, got %d, %v", seed, current, isSeeded)
+	}
+	first := set.Roll()
+
+	SetSeed(seed)
+	second := set.Roll()
+	if !slices.Equal(first.IndividualRolls, second.IndividualRolls) {
+		t.Errorf("Expected the reseeded roll to be reproducible, got %v and %v", first.IndividualRolls, second.IndividualRolls)
+	}
+}
+
+func TestSample(t *testing.T) {
+	set, err := ParseDiceNotation("2d1+3")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	estimate, err := set.Sample(100)
+	if err != nil {
+		t.Fatalf("Sample unexpected error: %v", err)
+	}
+	if estimate.Mean != 5 || estimate.StdDev != 0 || estimate.Min != 5 || estimate.Max != 5 || estimate.Counts[5] != 100 {
+		t.Errorf("2d1+3: expected every sample to total 5, got %+v", estimate)
+	}
+
+	SetSeed(7)
+	defer func() { seeded = nil }() // Restore the unseeded generator for other tests.
+	set, _ = ParseDiceNotation("3d6")
+	estimate, err = set.Sample(20000)
+	if err != nil {
+		t.Fatalf("Sample unexpected error: %v", err)
+	}
+	if math.Abs(estimate.Mean-10.5) > 4*estimate.StdErr || math.Abs(estimate.StdDev-2.958) > 0.1 {
+		t.Errorf("3d6: expected mean 10.5 and std dev 2.96, got %.3f and %.3f", estimate.Mean, estimate.StdDev)
+	}
+	if totals := estimate.Totals(); totals[0] != 3 || totals[len(totals)-1] != 18 {
+		t.Errorf("3d6: expected totals from 3 to 18, got %v", totals)
+	}
+
+	if _, err := set.Sample(1); err == nil {
+		t.Error("Expected Sample(1) to fail")
+	}
+}
+
+func TestRollN(t *testing.T) {
+	set, err := ParseDiceNotation("2d1+3")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+
+	rolls := 0
+	next := set.RollN(1000)
+	for result, ok := next(); ok; result, ok = next() {
+		if result.Total != 5 {
+			t.Errorf("Roll %d: expected a total of 5, got %d", rolls, result.Total)
+		}
+		rolls++
+	}
+	if rolls != 1000 {
+		t.Errorf("Expected 1000 rolls, got %d", rolls)
+	}
+	if _, ok := next(); ok {
+		t.Error("Expected the iterator to stay finished")
+	}
+
+	if _, ok := set.RollN(0)(); ok {
+		t.Error("Expected RollN(0) to roll nothing")
+	}
+}
+
+func TestGradeRollUnder(t *testing.T) {
+	tests := []struct {
+		roll, sides, target int
+		want                Degree
+	}{
+		{9, 100, 45, CriticalSuccess},
+		{10, 100, 45, Success},
+		{45, 100, 45, Success},
+		{46, 100, 45, Failure},
+		{95, 100, 45, Failure},
+		{96, 100, 45, Fumble},
+		{96, 100, 60, Failure},
+		{99, 100, 60, Failure},
+		{100, 100, 60, Fumble},
+		{100, 100, 100, Fumble},
+		{1, 100, 4, Success},
+		{20, 20, 12, Fumble},
+		{19, 20, 12, Failure},
+	}
+
+	for _, test := range tests {
+		if got := gradeRollUnder(test.roll, test.sides, test.target); got != test.want {
+			t.Errorf("gradeRollUnder(%d, d%d, %d) = %v, want %v", test.roll, test.sides, test.target, got, test.want)
+		}
+	}
+}
+
+func TestRollUnder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		result := MustRollNotation("d100<=45")
+		roll := result.DieRolls[0].Result
+		want := RollUnder{Roll: roll, Target: 45, Degree: gradeRollUnder(roll, 100, 45)}
+		if result.RollUnder == nil || *result.RollUnder != want {
+			t.Fatalf("d100<=45: expected %+v, got %+v", want, result.RollUnder)
+		}
+	}
+	if got := (RollUnder{Roll: 7, Target: 45, Degree: CriticalSuccess}).String(); got != "Critical success: rolled 7 against 45" {
+		t.Errorf("Unexpected description %q", got)
+	}
+
+	// Only a single regular die under a target is graded.
+	for _, notation := range []string{"2d100<=45", "d100>=45", "d100<=45+1", "d100", "d100! <=45"} {
+		if result := MustRollNotation(notation); result.RollUnder != nil {
+			t.Errorf("%s: expected no roll-under outcome, got %+v", notation, result.RollUnder)
+		}
+	}
+}
+
+func TestKeepAcrossGroups(t *testing.T) {
+	tests := []struct {
+		notation string
+		dice     int
+		kept     int
+		highest  bool
+	}{
+		{"{4d6 2d8}kh3", 6, 3, true},
+		{"{4d6 2d8}k3", 6, 3, true},
+		{"{4d6, 2d8}kl2", 6, 2, false},
+		{"{4d6 2d8}dl1", 6, 5, true},
+		{"{3d6 d20}dh1", 4, 3, false},
+	}
+
+	for _, test := range tests {
+		set, err := ParseDiceNotation(test.notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", test.notation, err)
+		}
+
+		for i := 0; i < 20; i++ {
+			result := set.Roll()
+			if len(result.DieRolls) != test.dice {
+				t.Fatalf("%s: expected %d dice, got %d", test.notation, test.dice, len(result.DieRolls))
+			}
+
+			var kept, dropped []int
+			for _, roll := range result.DieRolls {
+				if roll.Dropped {
+					dropped = append(dropped, roll.Result)
+				} else {
+					kept = append(kept, roll.Result)
+				}
+			}
+			if len(kept) != test.kept {
+				t.Fatalf("%s: expected %d kept dice, got %d", test.notation, test.kept, len(kept))
+			}
+
+			total := 0
+			for _, value := range kept {
+				total += value
+				for _, other := range dropped {
+					if (test.highest && other > value) || (!test.highest && other < value) {
+						t.Errorf("%s: dropped %d is better than kept %d", test.notation, other, value)
+					}
+				}
+			}
+			if result.Total != total {
+				t.Errorf("%s: expected total %d, got %d", test.notation, total, result.Total)
+			}
+		}
+	}
+
+	// Ties go to the die rolled first, and modifiers inside the braces still count.
+	result := MustRollNotation("{3d1 2}kh2")
+	if result.Total != 4 || !result.DieRolls[2].Dropped || result.DieRolls[0].Dropped {
+		t.Errorf("{3d1 2}kh2: expected total 4 with the last die dropped, got %+v", result)
+	}
+
+	// Without a selection the braces only group.
+	if result := MustRollNotation("{2d1} 1d1"); result.Total != 3 {
+		t.Errorf("{2d1} 1d1: expected total 3, got %d", result.Total)
+	}
+
+	set, _ := ParseDiceNotation("{4d6 2d8}kh3")
+	if low, high := set.Range(); low != 3 || high != 22 {
+		t.Errorf("{4d6 2d8}kh3: expected range 3-22, got %d-%d", low, high)
+	}
+
+	for _, notation := range []string{"{4d6 2d8", "4d6}", "{4d6-2d8}kh3", "{}kh1"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+		}
+	}
+}
+
+func TestKeepMiddle(t *testing.T) {
+	set, err := ParseDiceNotation("{5d6}mid3")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		result := set.Roll()
+		var kept, dropped []int
+		for _, roll := range result.DieRolls {
+			if roll.Dropped {
+				dropped = append(dropped, roll.Result)
+			} else {
+				kept = append(kept, roll.Result)
+			}
+		}
+		if len(kept) != 3 || len(dropped) != 2 {
+			t.Fatalf("{5d6}mid3: expected 3 kept and 2 dropped, got %v and %v", kept, dropped)
+		}
+		// One dropped die is at least as low as every kept die and the other at least as high.
+		if low, high := min(dropped[0], dropped[1]), max(dropped[0], dropped[1]); low > slices.Min(kept) || high < slices.Max(kept) {
+			t.Errorf("{5d6}mid3: dropped %v are not the ends of %v", dropped, kept)
+		}
+		if result.Total != kept[0]+kept[1]+kept[2] {
+			t.Errorf("{5d6}mid3: total %d is not the sum of %v", result.Total, kept)
+		}
+	}
+	if low, high := set.Range(); low != 3 || high != 18 {
+		t.Errorf("{5d6}mid3: expected range 3-18, got %d-%d", low, high)
+	}
+
+	// An uneven trim drops the extra die from the low end: 1, 2, 3, 4 keeps 3 with mid1.
+	result := MustRollNotation("{d{1} d{2} d{3} d{4}}mid1")
+	if result.Total != 3 {
+		t.Errorf("{1 2 3 4}mid1: expected 3, got %+v", result)
+	}
+	if canonical, _ := Canonicalize("{5d6} mid3"); canonical != "" {
+		t.Errorf("Canonicalize({5d6} mid3) = %q, expected an error", canonical)
+	}
+	if canonical, err := Canonicalize("{5d6}MID3"); err != nil || canonical != "{5d6}mid3" {
+		t.Errorf("Canonicalize({5d6}MID3) = %q, %v", canonical, err)
+	}
+
+	// A single dice group takes the selection directly, with or without a space.
+	for _, notation := range []string{"5d6 mid3", "5d6mid3", "5D6 MID3"} {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Errorf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+			continue
+		}
+		if dropped := len(set.Roll().Breakdown().Dropped); dropped != 2 {
+			t.Errorf("%s: expected 2 dropped dice, got %d", notation, dropped)
+		}
+	}
+	if canonical, err := Canonicalize("5d6 mid3+2"); err != nil || canonical != "{5d6}mid3+2" {
+		t.Errorf("Canonicalize(5d6 mid3+2) = %q, %v", canonical, err)
+	}
+	// A variable of the same name is still a variable.
+	if set, err := ParseDiceNotationWithVariables("5d1 mid3", map[string]int{"mid3": 1}); err != nil || set.Roll().Total != 6 {
+		t.Errorf("ParseDiceNotationWithVariables(5d1 mid3) with mid3 set = %v", err)
+	}
+
+	for _, notation := range []string{"{5d6}mid6", "5d6 mid6", "2d6mid3", "mid3", "1d20+5 mid1"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected error, got nil", notation)
+		}
+	}
+}
+
+func TestKeepFancyDiceByScore(t *testing.T) {
+	// Four exclusive suits always deal every suit once, so keeping two keeps the highest scores.
+	for i := 0; i < 20; i++ {
+		breakdown := MustRollNotation("{4F4}kh2").Breakdown()
+		if len(breakdown.Kept) != 2 || breakdown.Total != 7 {
+			t.Fatalf("{4F4}kh2: expected ♠ and ♥ for 7, got %+v", breakdown)
+		}
+		for _, roll := range breakdown.Kept {
+			if roll.FancyValue != "♠" && roll.FancyValue != "♥" {
+				t.Errorf("{4F4}kh2: kept %s, want ♠ and ♥", roll.FancyValue)
+			}
+		}
+	}
+
+	// A single group of fancy dice takes the selection directly, keeping the highest-scoring cards.
+	for _, notation := range []string{"5F52kh3", "5F52 kh3", "5f52dl2"} {
+		set, err := ParseDiceNotation(notation)
+		if err != nil {
+			t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+		for i := 0; i < 20; i++ {
+			breakdown := set.Roll().Breakdown()
+			if len(breakdown.Kept) != 3 || len(breakdown.Dropped) != 2 {
+				t.Fatalf("%s: expected 3 kept and 2 dropped cards, got %+v", notation, breakdown)
+			}
+			for _, kept := range breakdown.Kept {
+				for _, dropped := range breakdown.Dropped {
+					if kept.Score < dropped.Score {
+						t.Errorf("%s: kept %s scoring %d but dropped %s scoring %d", notation, kept.FancyValue, kept.Score, dropped.FancyValue, dropped.Score)
+					}
+				}
+			}
+		}
+	}
+	if canonical, err := Canonicalize("5F52kh3"); err != nil || canonical != "{5F52}kh3" {
+		t.Errorf("Canonicalize(5F52kh3) = %q, %v", canonical, err)
+	}
+	if set, err := ParseDiceNotation("4d6dl1"); err != nil || len(set.Roll().Breakdown().Dropped) != 1 {
+		t.Errorf("ParseDiceNotation(4d6dl1) = %v, want the lowest die dropped", err)
+	}
+
+	// A selection with no dice before it is still an error, with a hint.
+	if _, err := ParseDiceNotation("kh3"); err == nil || !strings.Contains(err.Error(), "5d6 kh3") {
+		t.Errorf("ParseDiceNotation(kh3) error = %v, want a hint to write 5d6 kh3", err)
+	}
+}
+
+func TestBestPerType(t *testing.T) {
+	diceSet, err := ParseDiceNotation("bestpertype(4d6 3d8 D6)+1")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	if low, high := diceSet.Range(); low != 3 || high != 15 {
+		t.Errorf("Range() = %d, %d, want 3, 15", low, high)
+	}
+
+	for i := 0; i < 100; i++ {
+		result := diceSet.Roll()
+		kept := make(map[string]DieRoll)
+		for _, roll := range result.Breakdown().Kept {
+			if _, seen := kept[roll.Type]; seen {
+				t.Fatalf("Kept two dice of type %s: %+v", roll.Type, result.DieRolls)
+			}
+			kept[roll.Type] = roll
+		}
+		if len(kept) != 2 || result.Total != kept["d6"].Score+kept["d8"].Score+1 {
+			t.Fatalf("Expected one d6 and one d8 kept, got %+v with total %d", kept, result.Total)
+		}
+		for _, roll := range result.DieRolls {
+			if roll.Score > kept[roll.Type].Score {
+				t.Errorf("Dropped %s %d above the kept %d", roll.Type, roll.Score, kept[roll.Type].Score)
+			}
+		}
+	}
+
+	if got, err := Canonicalize("bestpertype(2d6 d8)"); err != nil || got != "bestpertype(2d6+1d8)" {
+		t.Errorf("Canonicalize() = %q, %v, want %q", got, err, "bestpertype(2d6+1d8)")
+	}
+	for _, notation := range []string{"bestpertype(3d6!)", "bestpertype(2d6+1)"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	vars := map[string]int{"str": 3, "penalty": -2}
+
+	set, err := ParseDiceNotationWithVariables("1d1+str penalty", vars)
+	if err != nil {
+		t.Fatalf("ParseDiceNotationWithVariables unexpected error: %v", err)
+	}
+	if result := set.Roll(); result.Total != 2 || result.Modifier != 1 {
+		t.Errorf("Expected total 2 with modifier +1, got %d with %+d", result.Total, result.Modifier)
+	}
+
+	if _, err := ParseDiceNotationWithVariables("1d20+dex", vars); !errors.Is(err, ErrUndefinedVariable) || !strings.Contains(err.Error(), "undefined variable: dex") {
+		t.Errorf("Expected an undefined variable error for dex, got %v", err)
+	}
+
+	// Without variables the usual error is reported.
+	if _, err := ParseDiceNotation("1d20+str"); err == nil || strings.Contains(err.Error(), "variable") {
+		t.Errorf("Expected an invalid notation error without variables, got %v", err)
+	}
+
+	critRoll, err := ParseCritNotationWithVariables("1d20+str crit 1d1+str", vars)
+	if err != nil {
+		t.Fatalf("ParseCritNotationWithVariables unexpected error: %v", err)
+	}
+	if result := critRoll.Roll(); result.Damage.Total != 4 && result.Damage.Total != 5 {
+		t.Errorf("Expected damage 4, or 5 on a critical, got %d", result.Damage.Total)
+	}
+}
+
+func TestRollNotation(t *testing.T) {
+	result, err := RollNotation("2d6+1")
+	if err != nil {
+		t.Fatalf("RollNotation(2d6+1) unexpected error: %v", err)
+	}
+	if len(result.DieRolls) != 2 || result.Total < 3 || result.Total > 13 {
+		t.Errorf("RollNotation(2d6+1) unexpected result: %+v", result)
+	}
+
+	if _, err := RollNotation("nonsense"); err == nil {
+		t.Error("RollNotation(nonsense) expected error, got nil")
+	}
+
+	if result := MustRollNotation("1d1+1"); result.Total != 2 {
+		t.Errorf("MustRollNotation(1d1+1) expected total 2, got %d", result.Total)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustRollNotation(nonsense) expected panic")
+		}
+	}()
+	MustRollNotation("nonsense")
+}
+
+// scoredTotal recomputes a result's total from its kept dice and modifier.
+func scoredTotal(result RollResult) int {
+	total := result.Modifier
+	for _, roll := range result.DieRolls {
+		if !roll.Dropped {
+			total += roll.Score
+		}
+	}
+	return total
+}
+
+func TestNegativeScores(t *testing.T) {
+	// Faces listed in descending order, so face position and score disagree.
+	set, err := ParseDiceNotation("4d{3,0,-5}-2")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+
+	if low, high := set.Range(); low != -22 || high != 10 {
+		t.Errorf("Expected range -22 to 10, got %d to %d", low, high)
+	}
+
+	sawNegative := false
+	for i := 0; i < 50; i++ {
+		result := set.Roll()
+		if got := scoredTotal(result); got != result.Total {
+			t.Fatalf("Total %d does not match scored dice %d", result.Total, got)
+		}
+		if result.Total < 0 {
+			sawNegative = true
+		}
+
+		sorted := result.Sorted(false)
+		for j := 1; j < len(sorted.DieRolls); j++ {
+			if sorted.DieRolls[j-1].Score > sorted.DieRolls[j].Score {
+				t.Fatalf("Negative scores not sorted ascending: %+v", sorted.DieRolls)
+			}
+		}
+	}
+	if !sawNegative {
+		t.Error("Expected at least one negative total in 50 rolls")
+	}
+
+	// Keeping the highest must prefer a zero over a negative score.
+	result := MustRollNotation("{d{-5} d{0}}kh1")
+	if result.Total != 0 || !result.DieRolls[0].Dropped {
+		t.Errorf("Expected the -5 die dropped and total 0, got %+v", result)
+	}
+
+	// Custom dice files may have negative scores too.
+	path := filepath.Join(t.TempDir(), "curse.dice")
+	if err := os.WriteFile(path, []byte("cursed, -3\nblessed, 2\nplain, 0\n"), 0o644); err != nil {
+		t.Fatalf("Cannot write dice file: %v", err)
+	}
+	if err := LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	defer ResetFancyDice()
+
+	set, err = ParseDiceNotation("2f3")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation(2f3) unexpected error: %v", err)
+	}
+	if low, high := set.Range(); low != -6 || high != 4 {
+		t.Errorf("Expected 2f3 range -6 to 4, got %d to %d", low, high)
+	}
+}
+
+func TestDieRollScore(t *testing.T) {
+	result := MustRollNotation("f13 13F13 d{x,-2,7} 3d6 3D6 2d6!! 4d6!")
+	for _, roll := range result.DieRolls {
+		var want int
+		switch {
+		case roll.Type == "f13":
+			want = fancyDiceValues["f13"][roll.Result-1].Value
+		case len(roll.Die.Faces) > 0:
+			want = roll.Die.Faces[roll.Result-1].Value
+		default:
+			want = roll.Result
+		}
+		if roll.Score != want {
+			t.Errorf("%s rolled %d: expected score %d, got %d", roll.Type, roll.Result, want, roll.Score)
+		}
+	}
+
+	// The ace scores 4 even though it is the first face.
+	for _, roll := range result.DieRolls {
+		if roll.FancyValue == "A" && roll.Score != 4 {
+			t.Errorf("Expected the ace to score 4, got %d", roll.Score)
+		}
+	}
+}
+
+func TestRollResultDiceKinds(t *testing.T) {
+	tests := []struct {
+		notation     string
+		hasFancy     bool
+		hasExclusive bool
+	}{
+		{"3d6+2", false, false},
+		{"2f4", true, false},
+		{"d{red,green}", true, false},
+		{"3D6", false, true},
+		{"2F52 1d6", true, true},
+		{"best(1d6, 1f4)", true, false},
+	}
+
+	for _, tt := range tests {
+		result := MustRollNotation(tt.notation)
+		if result.HasFancy != tt.hasFancy || result.HasExclusive != tt.hasExclusive {
+			t.Errorf("%s: HasFancy = %v, HasExclusive = %v, want %v, %v",
+				tt.notation, result.HasFancy, result.HasExclusive, tt.hasFancy, tt.hasExclusive)
+		}
+	}
+}
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		notation   string
+		expression string
+		label      string
+	}{
+		{"1d20+5 #attack", "1d20+5", "attack"},
+		{"2d6+3\t#  sneak attack ", "2d6+3", "sneak attack"},
+		{"3d6", "3d6", ""},
+		{"d{C#,D}", "d{C#,D}", ""},
+	}
+
+	for _, tt := range tests {
+		expression, label := SplitLabel(tt.notation)
+		if expression != tt.expression || label != tt.label {
+			t.Errorf("SplitLabel(%q) = %q, %q, want %q, %q", tt.notation, expression, label, tt.expression, tt.label)
+		}
+	}
+
+	result := MustRollNotation("2d1+1 #sneak attack")
+	if result.Total != 3 || result.Label != "sneak attack" {
+		t.Errorf("Expected a total of 3 labelled \"sneak attack\", got %+v", result)
+	}
+	if sorted := result.SortedBy(true, SortByRoll); sorted.Label != result.Label {
+		t.Errorf("Sorting lost the label: %q", sorted.Label)
+	}
+}
+
+func TestSortedBy(t *testing.T) {
+	// On an f4 the spade is the first face but scores highest.
+	result := RollResult{DieRolls: []DieRoll{
+		{Die: Die{Sides: -4}, Type: "f4", Result: 4, Score: 1, FancyValue: "♣"},
+		{Die: Die{Sides: -4}, Type: "f4", Result: 1, Score: 4, FancyValue: "♠"},
+		{Die: Die{Sides: -4}, Type: "f4", Result: 3, Score: 2, FancyValue: "♦"},
+	}}
+
+	names := func(r RollResult) string {
+		var faces []string
+		for _, roll := range r.DieRolls {
+			faces = append(faces, roll.FancyValue)
+		}
+		return strings.Join(faces, " ")
+	}
+
+	if got := names(result.SortedBy(false, SortByValue)); got != "♣ ♦ ♠" {
+		t.Errorf("Sorting by value ascending gave %s", got)
+	}
+	if got := names(result.SortedBy(true, SortByRoll)); got != "♣ ♦ ♠" {
+		t.Errorf("Sorting by roll descending gave %s", got)
+	}
+	if got := names(result.SortedBy(false, SortByRoll)); got != "♠ ♦ ♣" {
+		t.Errorf("Sorting by roll ascending gave %s", got)
+	}
+	if got := names(result.Sorted(true)); got != "♠ ♦ ♣" {
+		t.Errorf("Sorted descending gave %s, expected to sort by value", got)
+	}
+}
+
+func TestCompareFaces(t *testing.T) {
+	// rolls builds a roll of each named face of a fancy die, in the order given.
+	rolls := func(typeName string, names ...string) RollResult {
+		faces, ok := FancyDieFaces(typeName)
+		if !ok {
+			t.Fatalf("FancyDieFaces(%q) found no die", typeName)
+		}
+		var result RollResult
+		for _, name := range names {
+			position := slices.IndexFunc(faces, func(face FancyDieValue) bool { return face.Name == name })
+			if position < 0 {
+				t.Fatalf("%s has no face %q", typeName, name)
+			}
+			result.DieRolls = append(result.DieRolls, DieRoll{Type: typeName, Result: position + 1, Score: faces[position].Value, FancyValue: name})
+		}
+		return result
+	}
+	names := func(r RollResult) string {
+		var faces []string
+		for _, roll := range r.DieRolls {
+			faces = append(faces, roll.FancyValue)
+		}
+		return strings.Join(faces, " ")
+	}
+
+	tests := []struct {
+		name       string
+		result     RollResult
+		descending bool
+		key        SortKey
+		want       string
+	}{
+		{"f13 ranks 2 to 10 tie on value but keep rank order", rolls("f13", "10", "K", "2", "A", "9", "J", "3"), false, SortByValue, "2 3 9 10 J K A"},
+		{"f13 descending by value", rolls("f13", "10", "K", "2", "A", "9", "J", "3"), true, SortByValue, "A K J 10 9 3 2"},
+		{"f13 by roll is deck order", rolls("f13", "10", "K", "2", "A", "9"), false, SortByRoll, "A 2 9 10 K"},
+		{"f52 by value", rolls("f52", "10♣", "A♠", "2♣", "J♦", "9♣"), false, SortByValue, "2♣ 9♣ 10♣ J♦ A♠"},
+		{"zodiac descending", rolls("f12", "♈", "♓", "♌", "♉"), true, SortByValue, "♓ ♌ ♉ ♈"},
+		{"zodiac by roll", rolls("f12", "♒", "♈", "♋"), false, SortByRoll, "♈ ♋ ♒"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(tt.result.SortedBy(tt.descending, tt.key)); got != tt.want {
+				t.Errorf("SortedBy() gave %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Rolls of the same face tie.
+	ace := rolls("f13", "A").DieRolls[0]
+	if got := CompareFaces(ace, ace, SortByValue); got != 0 {
+		t.Errorf("CompareFaces() of the same face = %d, want 0", got)
+	}
+}
+
+func TestSortedPreservesTotal(t *testing.T) {
+	tests := []struct {
+		notation   string
+		descending bool
+	}{
+		{"d20 f4 3D6", false},
+		{"d20 f4 3D6", true},
+		{"2f13 4F52 d6", false},
+		{"1d20+5 2f4 3D8-2", true},
+		{"highest(2d20) f12 d{-3,0,3}+1", false},
+		{"3d6! 2d4!! f2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s descending=%v", tt.notation, tt.descending), func(t *testing.T) {
+			set, err := ParseDiceNotation(tt.notation)
+			if err != nil {
+				t.Fatalf("ParseDiceNotation(%q) unexpected error: %v", tt.notation, err)
+			}
+
+			for i := 0; i < 20; i++ {
+				result := set.Roll()
+				if got := scoredTotal(result); got != result.Total {
+					t.Fatalf("Unsorted total %d does not match scored dice %d", result.Total, got)
+				}
+
+				sorted := result.Sorted(tt.descending)
+				if sorted.Total != result.Total || sorted.Modifier != result.Modifier {
+					t.Errorf("Sorting changed total %d to %d or modifier %d to %d",
+						result.Total, sorted.Total, result.Modifier, sorted.Modifier)
+				}
+				if got := scoredTotal(sorted); got != sorted.Total {
+					t.Errorf("Sorted total %d does not match scored dice %d", sorted.Total, got)
+				}
+
+				for j := 1; j < len(sorted.DieRolls); j++ {
+					previous, current := sorted.DieRolls[j-1].Score, sorted.DieRolls[j].Score
+					if (tt.descending && previous < current) || (!tt.descending && previous > current) {
+						t.Errorf("Die rolls not sorted: %+v", sorted.DieRolls)
+						break
+					}
+				}
+
+				// The original result must not be reordered.
+				if &sorted.DieRolls[0] == &result.DieRolls[0] {
+					t.Error("Sorted shares its die rolls with the original result")
+				}
+			}
+		})
+	}
+}
+
+func TestGroupTotals(t *testing.T) {
+	set, err := ParseDiceNotation("2d6 1d8 f4+3")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation unexpected error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		result := set.Roll()
+		totals := result.GroupTotals()
+
+		wantNotations := []string{"2d6", "1d8", "f4"}
+		if len(totals) != len(wantNotations) {
+			t.Fatalf("Expected %d group totals, got %+v", len(wantNotations), totals)
+		}
+
+		sum := result.Modifier
+		for j, group := range totals {
+			if group.Notation != wantNotations[j] {
+				t.Errorf("Group %d: expected notation %q, got %q", j, wantNotations[j], group.Notation)
+			}
+			sum += group.Subtotal
+		}
+		if sum != result.Total {
+			t.Errorf("Subtotals plus modifier %d do not match total %d", sum, result.Total)
+		}
+
+		if want := result.DieRolls[0].Result + result.DieRolls[1].Result; totals[0].Subtotal != want {
+			t.Errorf("Expected 2d6 subtotal %d, got %d", want, totals[0].Subtotal)
+		}
+	}
+
+	// Dropped dice do not count towards their group.
+	result := MustRollNotation("highest(3d6) 3D4")
+	for _, roll := range result.DieRolls[:3] {
+		if !roll.Dropped && result.GroupTotals()[0].Subtotal != roll.Result {
+			t.Errorf("Expected subtotal of the kept die %d, got %d", roll.Result, result.GroupTotals()[0].Subtotal)
+		}
+	}
+
+	if totals := NewDiceSet([]Die{NewDie(6)}).Roll().GroupTotals(); totals != nil {
+		t.Errorf("Expected no group totals for a set built from dice, got %+v", totals)
+	}
+}
+
+func TestChance(t *testing.T) {
+	tests := []struct {
+		query string
+		want  float64
+	}{
+		{"3d6+2 >= 15", 56.0 / 216},
+		{"1d20 > 20", 0},
+		{"2d6 = 7", 1.0 / 6},
+		{"1d6-1d6 < 0", 15.0 / 36},
+		{"1d20+5 clamp(1,20) == 20", 6.0 / 20},
+		{"6d10>=7 >= 1", 1 - 0.6*0.6*0.6*0.6*0.6*0.6},
+		{"f4 <= 2", 0.5},
+	}
+
+	for _, tt := range tests {
+		query, err := ParseChanceQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseChanceQuery(%q) unexpected error: %v", tt.query, err)
+			continue
+		}
+		chance, err := query.Chance(1000)
+		if err != nil {
+			t.Errorf("Chance(%q) unexpected error: %v", tt.query, err)
+			continue
+		}
+		if !chance.Exact || math.Abs(chance.Probability-tt.want) > 1e-9 {
+			t.Errorf("Chance(%q) = %+v, want exactly %v", tt.query, chance, tt.want)
+		}
+	}
+
+	// Exploding dice are estimated by rolling.
+	query, err := ParseChanceQuery("1d6! >= 1")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery unexpected error: %v", err)
+	}
+	chance, err := query.Chance(100)
+	if err != nil {
+		t.Fatalf("Chance unexpected error: %v", err)
+	}
+	if chance.Exact || chance.Samples != 100 || chance.Probability != 1 {
+		t.Errorf("Chance(1d6! >= 1) = %+v, want a certain estimate from 100 rolls", chance)
+	}
+
+	for _, query := range []string{"3d6", "3d6 >= d6", ">= 15", "3d6 >="} {
+		if _, err := ParseChanceQuery(query); err == nil {
+			t.Errorf("ParseChanceQuery(%q) expected an error", query)
+		}
+	}
+}
+
+func TestDiceLimits(t *testing.T) {
+	for _, notation := range []string{"10000d6", "1d1000", "1000D1000", "10000f4", "10000d{a,b}"} {
+		if _, err := ParseDiceNotation(notation); err != nil {
+			t.Errorf("ParseDiceNotation(%q) unexpected error: %v", notation, err)
+		}
+	}
+
+	tests := []struct {
+		notation string
+		want     string
+	}{
+		{"10001d6", "too many dice"},
+		{"2147483648d6", "too many dice"},
+		{"99999999999999999999d6", "too many dice"},
+		{"10001f4", "too many dice"},
+		{"10001d{a,b}", "too many dice"},
+		{"1d1001", "too many sides"},
+		{"1d9223372036854775808", "too many sides"},
+		{"2D1001", "too many sides"},
+		{"3d1001!", "too many sides"},
+	}
+	for _, tt := range tests {
+		_, err := ParseDiceNotation(tt.notation)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseDiceNotation(%q) error = %v, want %q", tt.notation, err, tt.want)
+		}
+	}
+}
+
+func TestZeroBasedDice(t *testing.T) {
+	diceSet, err := ParseDiceNotation("3d10z")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	if low, high := diceSet.Range(); low != 0 || high != 27 {
+		t.Errorf("Range() = %d, %d, want 0, 27", low, high)
+	}
+	if got, _ := Canonicalize("d10z d10z"); got != "2d10z" {
+		t.Errorf("Canonicalize() = %q, want %q", got, "2d10z")
+	}
+
+	seen := make(map[int]bool)
+	for i := 0; i < 500; i++ {
+		for _, roll := range diceSet.Roll().DieRolls {
+			if roll.Type != "d10z" || roll.Result < 0 || roll.Result > 9 || roll.Score != roll.Result {
+				t.Fatalf("Unexpected zero-based roll %+v", roll)
+			}
+			seen[roll.Result] = true
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("Expected every face from 0 to 9, saw %v", seen)
+	}
+
+	if result := MustRollNotation("2d1z+3"); result.Total != 3 {
+		t.Errorf("2d1z+3: expected a total of 3, got %d", result.Total)
+	}
+
+	die, err := NewZeroBasedDie(6)
+	if err != nil || die.notation() != "d6z" {
+		t.Errorf("NewZeroBasedDie(6) = %v, %v", die, err)
+	}
+
+	// A zero-based d20 is not a d20 for natural 20s, and zero-based dice cannot explode.
+	for _, notation := range []string{"d10z!", "3d6z!!", "sw d8z"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+	if _, err := ParseCritNotation("1d20z crit 1d6"); err == nil {
+		t.Error("Expected a crit attack on a d20z to be rejected")
+	}
+}
+
+func TestRangeDice(t *testing.T) {
+	diceSet, err := ParseDiceNotation("2d[3-8]+1")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	if low, high := diceSet.Range(); low != 7 || high != 17 {
+		t.Errorf("Range() = %d, %d, want 7, 17", low, high)
+	}
+
+	seen := make(map[int]bool)
+	for i := 0; i < 500; i++ {
+		for _, roll := range diceSet.Roll().DieRolls {
+			if roll.Type != "d[3-8]" || roll.Result < 3 || roll.Result > 8 {
+				t.Fatalf("Unexpected range roll %+v", roll)
+			}
+			seen[roll.Result] = true
+		}
+	}
+	if len(seen) != 6 {
+		t.Errorf("Expected every face from 3 to 8, saw %v", seen)
+	}
+
+	tests := []struct {
+		notation string
+		want     string
+	}{
+		{"d[3-8] d[3 - 8]", "2d[3-8]"},
+		{"d[-2-2]", "1d[-2-2]"},
+		{"d[1-6]", "1d6"},
+		{"d[0-9]", "1d10z"},
+		{"d[5-5]+1", "1d[5-5]+1"},
+	}
+	for _, tt := range tests {
+		if got, err := Canonicalize(tt.notation); err != nil || got != tt.want {
+			t.Errorf("Canonicalize(%q) = %q, %v, want %q", tt.notation, got, err, tt.want)
+		}
+	}
+
+	die, err := NewRangeDie(-3, 3)
+	if err != nil || die.Min() != -3 || die.Max() != 3 {
+		t.Errorf("NewRangeDie(-3, 3) = %+v, %v", die, err)
+	}
+
+	for _, notation := range []string{"d[8-3]", "d[1-1001]", "d[3-8", "d[3-8]!", "d[a-b]", "d[99999999999999999999-1]", "d[-9223372036854775808--9223372036854775807]"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	result := MustRollNotation("{1d1 2d1 1d1}kh2+3")
+	if !slices.Equal(result.IndividualRolls, []int{1, 1, 1, 1}) {
+		t.Errorf("IndividualRolls = %v, want every die as rolled", result.IndividualRolls)
+	}
+
+	breakdown := result.Breakdown()
+	if len(breakdown.Kept) != 2 || len(breakdown.Dropped) != 2 {
+		t.Fatalf("Breakdown() kept %d and dropped %d dice, want 2 and 2", len(breakdown.Kept), len(breakdown.Dropped))
+	}
+	if breakdown.Modifier != 3 || breakdown.Total != 5 {
+		t.Errorf("Breakdown() modifier %d, total %d, want 3, 5", breakdown.Modifier, breakdown.Total)
+	}
+	for _, roll := range breakdown.Dropped {
+		if !roll.Dropped {
+			t.Errorf("Breakdown() listed a kept die as dropped: %+v", roll)
+		}
+	}
+
+	// Critical hits roll their extra damage dice after the roll, and IndividualRolls includes them.
+	crit, err := ParseCritNotation("1d20 crit 2d1")
+	if err != nil {
+		t.Fatalf("ParseCritNotation() unexpected error: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if result := crit.Roll(); result.Critical {
+			if len(result.Damage.IndividualRolls) != 4 {
+				t.Errorf("IndividualRolls = %v, want all four damage dice", result.Damage.IndividualRolls)
+			}
+			break
+		}
+	}
+}
+
+func TestGroupDigits(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{30000, "30,000"},
+		{1234567, "1,234,567"},
+		{-1250, "-1,250"},
+		{-100, "-100"},
+		{math.MinInt64, "-9,223,372,036,854,775,808"},
+	}
+	for _, tt := range tests {
+		if got := GroupDigits(tt.n); got != tt.want {
+			t.Errorf("GroupDigits(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueDice(t *testing.T) {
+	diceSet, err := ParseDiceNotation("unique(4d6)+1")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		result := diceSet.Roll()
+		seen := make(map[int]bool)
+		total := result.Modifier
+		for _, roll := range result.DieRolls {
+			if seen[roll.Result] || roll.Duplicate {
+				t.Fatalf("Expected no duplicates, got %+v", result.DieRolls)
+			}
+			seen[roll.Result] = true
+			total += roll.Score
+		}
+		if total != result.Total {
+			t.Fatalf("Total = %d, want the sum of the dice %d", result.Total, total)
+		}
+	}
+
+	// With more dice than faces, unique() gives up on the die that cannot differ.
+	result := MustRollNotation("unique(3d2)")
+	if last := result.DieRolls[2]; !last.Duplicate || last.Rerolls != maxUniqueRerolls {
+		t.Errorf("Expected the third d2 to give up after %d rerolls, got %+v", maxUniqueRerolls, last)
+	}
+	if first := result.DieRolls[0]; first.Duplicate || first.Rerolls != 0 {
+		t.Errorf("Expected the first die never to be rerolled, got %+v", first)
+	}
+
+	if got, err := Canonicalize("unique(2d6 d8)"); err != nil || got != "unique(2d6+1d8)" {
+		t.Errorf("Canonicalize() = %q, %v, want %q", got, err, "unique(2d6+1d8)")
+	}
+	for _, notation := range []string{"unique(3d6!)", "unique(2d6+1)", "unique(highest(2d20))"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func TestPercentileDice(t *testing.T) {
+	diceSet, err := ParseDiceNotation("2d%% + 5")
+	if err != nil {
+		t.Fatalf("ParseDiceNotation() unexpected error: %v", err)
+	}
+	if got := diceSet.String(); got != "DiceSet{[2d%%]}" {
+		t.Errorf("String() = %q, want %q", got, "DiceSet{[2d%%]}")
+	}
+	if low, high := diceSet.Range(); low != 7 || high != 205 {
+		t.Errorf("Range() = %d, %d, want 7, 205", low, high)
+	}
+
+	for i := 0; i < 200; i++ {
+		result := diceSet.Roll()
+		for _, roll := range result.DieRolls {
+			if roll.Type != "d%%" || roll.Result < 1 || roll.Result > 100 {
+				t.Fatalf("Unexpected percentile roll %+v", roll)
+			}
+			want := roll.Tens*10 + roll.Units
+			if want == 0 {
+				want = 100 // "00" and "0" read 100.
+			}
+			if roll.Result != want {
+				t.Errorf("tens %d and units %d read %d, want %d", roll.Tens, roll.Units, roll.Result, want)
+			}
+		}
+	}
+
+	for _, notation := range []string{"d%", "d%%%", "3%%"} {
+		if _, err := ParseDiceNotation(notation); err == nil {
+			t.Errorf("ParseDiceNotation(%q) expected an error", notation)
+		}
+	}
+}
+
+func FuzzParseDiceNotation(f *testing.F) {
+	for _, seed := range []string{
+		"3d6", "d20", "2d10 d6", "1d20,7d4", "3d6+2d4-3", "3D6", "13F52", "2f4", "d{2,3,5,7}",
+		"2d{a\\,b,c}", "highest(2d20)+5", "{4d6 2d8}kh3", "6d10>=7", "d100<=45", "3d6!", "3d6!!",
+		"sw d8", "swwild d8", "adv+5", "best(2d6+1, 1d12)", "1d20+5 clamp(1,20)", "pool(d6 d8)",
+		"d%%", "d10z", "2d[3-8]", "d[-2-2]", "1d20+5 on>=18 add 1d6",
+		"99999999999999999999d6", "1d99999999999999999999", "3 d 6", "d", "",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, notation string) {
+		diceSet, err := ParseDiceNotation(notation)
+		if err != nil {
+			return
+		}
+		diceSet.Roll()
+		diceSet.Range()
+		if _, err := Canonicalize(notation); err != nil {
+			t.Errorf("Canonicalize(%q) failed on notation that parses: %v", notation, err)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package dice
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExplodeMode describes how a die rolls again when it shows its maximum, or another roll that
+// explodes, as in "3d10!>=9".
+type ExplodeMode int
+
+const (
+	// ExplodeNone is an ordinary die.
+	ExplodeNone ExplodeMode = iota
+	// ExplodeStandard adds each further roll as a separate die ("d6!").
+	ExplodeStandard
+	// ExplodeCompound sums each further roll into the original die ("d6!!").
+	ExplodeCompound
+	// ExplodePenetrating adds each further roll less one as a separate die ("d6!p"), as in HackMaster.
+	ExplodePenetrating
+)
+
+// maxExplosions caps the number of extra rolls a single die can add, so a chain always ends.
+var maxExplosions = 100
+
+// SetExplosionCap sets the maximum number of extra rolls a single exploding die can add.
+// Values less than one are ignored.
+func SetExplosionCap(limit int) {
+	if limit > 0 {
+		maxExplosions = limit
+	}
+}
+
+// explodeModes maps the notation suffix to its explosion mode.
+var explodeModes = map[string]ExplodeMode{
+	"":   ExplodeNone,
+	"!":  ExplodeStandard,
+	"!!": ExplodeCompound,
+	"!p": ExplodePenetrating,
+}
+
+// explodeConditionRegex splits an explosion condition such as ">=9" into its comparison and number.
+var explodeConditionRegex = regexp.MustCompile(`^([<>]=?|=)(\d+)$`)
+
+// parseExplodeCondition parses the comparison after "!" or "!!" that says which rolls of a die
+// with the given sides explode, as in "3d10!>=9". An empty condition explodes on the maximum only.
+// A condition that no roll meets, or that every roll meets, is rejected.
+func parseExplodeCondition(text string, sides int) (comparison, error) {
+	if text == "" {
+		return comparison{}, nil
+	}
+	matches := explodeConditionRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return comparison{}, fmt.Errorf("invalid explosion condition '%s'", text)
+	}
+	target, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return comparison{}, fmt.Errorf("invalid number: %s", matches[2])
+	}
+	condition := comparison{op: matches[1], target: target}
+	switch {
+	case !condition.possible(1, sides):
+		return comparison{}, fmt.Errorf("the die can never explode on %s", condition)
+	case condition.certain(1, sides):
+		return comparison{}, fmt.Errorf("the die would explode on every roll with %s", condition)
+	}
+	return condition, nil
+}
+
+// explodes reports whether a roll of an exploding die rolls again.
+func (d Die) explodes(roll int) bool {
+	if d.explodeOn.op == "" {
+		return roll == d.Sides
+	}
+	return d.explodeOn.matches(roll)
+}
+
+// rollExploding records an exploding die whose first roll is given, rolling again while it
+// explodes, and returns the total it contributes. A die showing its average never explodes, as
+// every further roll would show the same average and explode again up to the cap.
+func rollExploding(die Die, roll int, result *RollResult) int {
+	dieType := fmt.Sprintf("d%d", die.Sides)
+
+	rolls := []int{roll}
+	for len(rolls) <= maxExplosions && !averaging() && die.explodes(rolls[len(rolls)-1]) {
+		rolls = append(rolls, die.Roll())
+	}
+
+	total := 0
+	if die.Explode == ExplodeCompound {
+		// Compounding dice report a single result holding the whole chain.
+		for _, value := range rolls {
+			total = addScore(result, total, value)
+		}
+		result.DieRolls = append(result.DieRolls, DieRoll{Die: die, Result: total, Score: total, Type: dieType, Rolls: rolls, Group: die.group})
+		return total
+	}
+
+	// Standard and penetrating explosions list each extra roll as its own die.
+	for i, value := range rolls {
+		penetrated := die.Explode == ExplodePenetrating && i > 0
+		if penetrated {
+			// Each further roll of a penetrating die counts one less, though it explodes on its roll.
+			value--
+		}
+		total = addScore(result, total, value)
+		result.DieRolls = append(result.DieRolls, DieRoll{Die: die, Result: value, Score: value, Type: dieType, Exploded: i > 0, Penetrated: penetrated, Group: die.group})
+	}
+	return total
+}
+
+// explodingBounds returns the minimum and maximum score of an exploding die. A chain of rolls ends
+// on a roll that does not explode or at the explosion cap, and each further roll of a penetrating
+// die counts one less.
+func explodingBounds(die Die) (int, int) {
+	penalty := 0
+	if die.Explode == ExplodePenetrating {
+		penalty = 1
+	}
+	// The lowest and highest rolls that stop the chain and that explode, or 0 if there are none.
+	lowStop, highStop, lowBoom, highBoom := 0, 0, 0, 0
+	for roll := 1; roll <= die.Sides; roll++ {
+		if !die.explodes(roll) {
+			if lowStop == 0 {
+				lowStop = roll
+			}
+			highStop = roll
+		} else {
+			if lowBoom == 0 {
+				lowBoom = roll
+			}
+			highBoom = roll
+		}
+	}
+	capped := func(roll int) int {
+		return roll + maxExplosions*(roll-penalty)
+	}
+	switch {
+	case highBoom == 0:
+		return lowStop, highStop
+	case highStop == 0:
+		// Every roll explodes, so every chain runs to the cap.
+		return capped(lowBoom), capped(highBoom)
+	}
+	// No further roll counts less than nothing, so the lowest chain stops at once and the highest
+	// runs to the cap, ending on either one more explosion or the highest roll that stops.
+	high := max(highStop, capped(highBoom), highBoom+(maxExplosions-1)*(highBoom-penalty)+highStop-penalty)
+	return lowStop, high
+}
+
+// savageWorldsPreset describes a Savage Worlds trait roll.
+type savageWorldsPreset struct {
+	name     string
+	wildCard bool // Whether a d6 wild die is rolled alongside the trait die
+}
+
+// savageWorldsPresets are the keywords introducing Savage Worlds trait rolls.
+//
+// "sw d8" rolls a trait die that aces: whenever it shows its maximum it is rolled
+// again and added, up to the explosion cap. "swwild d8" also rolls a d6 wild die
+// that aces in the same way, and keeps the higher of the trait and wild dice.
+// Modifiers can follow as usual, e.g. "swwild d8+2".
+var savageWorldsPresets = map[string]savageWorldsPreset{
+	"sw":     {name: "sw", wildCard: false},
+	"swwild": {name: "swwild", wildCard: true},
+}
+
+// parseSavageWorlds parses the trait die following a Savage Worlds keyword.
+func (p *expressionParser) parseSavageWorlds(preset savageWorldsPreset) (node, error) {
+	tok := p.next()
+	if tok.kind != tokenWord || strings.ContainsAny(tok.text, "!{") {
+		return nil, fmt.Errorf("%s must be followed by a single die, e.g. %s d8", preset.name, preset.name)
+	}
+
+	dice, err := parseSingleDiceGroup(tok.text)
+	if err != nil {
+		return nil, err
+	}
+	if len(dice) != 1 || dice[0].Sides < 2 || dice[0].Sides > 1000 || dice[0].Offset != 0 {
+		return nil, fmt.Errorf("%s must be followed by a single regular die, e.g. %s d8", preset.name, preset.name)
+	}
+
+	group := p.addGroup(preset.name + " " + tok.text)
+	trait := Die{Sides: dice[0].Sides, Explode: ExplodeCompound, group: group}
+	if !preset.wildCard {
+		return &poolNode{pool: []Die{trait}}, nil
+	}
+
+	wild := Die{Sides: 6, Explode: ExplodeCompound, group: group}
+	return &selectNode{highest: true, arg: &poolNode{pool: []Die{trait, wild}}}, nil
+}
@@ -0,0 +1,664 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// node is an element of a parsed dice expression.
+type node interface {
+	// eval rolls any dice in the node, records them in the result and returns the node's value.
+	eval(result *RollResult) int
+	// dice returns the dice rolled by the node, in the order they appear.
+	dice() []Die
+	// bounds returns the minimum and maximum values the node can produce.
+	bounds() (int, int)
+	// canonical returns the node in canonical notation, as described by Canonicalize.
+	canonical() string
+}
+
+// poolNode is a run of dice groups that are rolled together.
+// Keeping adjacent groups in one pool preserves the exclusive grouping of the dice.
+type poolNode struct {
+	pool []Die
+}
+
+func (n *poolNode) eval(result *RollResult) int {
+	return rollPool(n.pool, result)
+}
+
+func (n *poolNode) dice() []Die {
+	return n.pool
+}
+
+func (n *poolNode) bounds() (int, int) {
+	return poolBounds(n.pool)
+}
+
+// constNode is a flat numeric value such as the 5 in "1d20+5".
+type constNode struct {
+	value int
+}
+
+func (n *constNode) eval(result *RollResult) int {
+	return n.value
+}
+
+func (n *constNode) dice() []Die {
+	return nil
+}
+
+func (n *constNode) bounds() (int, int) {
+	return n.value, n.value
+}
+
+// sumNode adds or subtracts a sequence of terms.
+type sumNode struct {
+	terms []node
+	signs []int // +1 or -1 for each term
+}
+
+func (n *sumNode) eval(result *RollResult) int {
+	total := 0
+	for i, term := range n.terms {
+		value := n.signs[i] * term.eval(result)
+		if _, isConst := term.(*constNode); isConst {
+			// Record flat modifiers separately so they can be displayed.
+			result.Modifier = addScore(result, result.Modifier, value)
+		}
+		total = addScore(result, total, value)
+	}
+	return total
+}
+
+func (n *sumNode) dice() []Die {
+	var all []Die
+	for _, term := range n.terms {
+		all = append(all, term.dice()...)
+	}
+	return all
+}
+
+func (n *sumNode) bounds() (int, int) {
+	low, high := 0, 0
+	for i, term := range n.terms {
+		termLow, termHigh := term.bounds()
+		if n.signs[i] > 0 {
+			low += termLow
+			high += termHigh
+		} else {
+			// Subtracting a term swaps which of its bounds gives the extreme.
+			low -= termHigh
+			high -= termLow
+		}
+	}
+	return low, high
+}
+
+// selectNode keeps only the single highest or lowest die rolled by its argument.
+type selectNode struct {
+	highest bool
+	arg     node
+}
+
+func (n *selectNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	n.arg.eval(result)
+
+	best := -1
+	for i := start; i < len(result.DieRolls); i++ {
+		if result.DieRolls[i].Dropped {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		score, bestScore := result.DieRolls[i].Score, result.DieRolls[best].Score
+		if (n.highest && score > bestScore) || (!n.highest && score < bestScore) {
+			best = i
+		}
+	}
+
+	// Mark every other die as dropped.
+	for i := start; i < len(result.DieRolls); i++ {
+		if i != best {
+			result.DieRolls[i].Dropped = true
+		}
+	}
+
+	if best < 0 {
+		return 0
+	}
+	return result.DieRolls[best].Score
+}
+
+func (n *selectNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *selectNode) bounds() (int, int) {
+	// The kept die is at least the best of the dice minimums and at most the best of their maximums.
+	var low, high int
+	for i, die := range n.arg.dice() {
+		dieLow, dieHigh := poolBounds([]Die{die})
+		if i == 0 {
+			low, high = dieLow, dieHigh
+		} else if n.highest {
+			low, high = max(low, dieLow), max(high, dieHigh)
+		} else {
+			low, high = min(low, dieLow), min(high, dieHigh)
+		}
+	}
+	return low, high
+}
+
+// tokenKind identifies the kind of a lexical token in dice notation.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPlus
+	tokenMinus
+	tokenComma
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenCompare
+	tokenStar
+	tokenSlash
+	tokenPercent
+)
+
+// token is a single lexical element of dice notation.
+type token struct {
+	kind   tokenKind
+	text   string
+	spaced bool // True if whitespace came before the token
+}
+
+// tokenize splits dice notation into tokens. Whitespace follows these rules:
+//   - Around operators, commas, parentheses and comparisons it is ignored, so "3d6 + 2" is "3d6+2".
+//   - Between two terms it adds them, so "2d10 d6" is "2d10+d6".
+//   - A die letter standing alone joins the numbers on either side, so "3 d 6" and "3d 6" are "3d6";
+//     "3 d6" is still 3 plus a d6. A spaced "!", "!!" or "!p" joins the dice before it, so "3d6 !" is "3d6!".
+//   - A comparison touching "!", "!!" or "!p" says which rolls explode, so "3d10!>=9" explodes on 9 or 10,
+//     while "3d10! >=9" explodes on 10 and counts the dice of 9 or more as successes.
+//   - A keep or drop suffix must touch its closing brace, since "{2d6} d4" adds a d4.
+func tokenize(notation string) ([]token, error) {
+	var tokens []token
+	runes := []rune(notation)
+
+	spaced := false
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			spaced = true
+			i++
+			continue
+		}
+
+		count := len(tokens)
+		switch {
+		case r == '+':
+			tokens = append(tokens, token{kind: tokenPlus, text: "+"})
+			i++
+		case r == '-':
+			tokens = append(tokens, token{kind: tokenMinus, text: "-"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ","})
+			i++
+		case r == '*':
+			tokens = append(tokens, token{kind: tokenStar, text: "*"})
+			i++
+		case r == '/':
+			tokens = append(tokens, token{kind: tokenSlash, text: "/"})
+			i++
+		case r == '%':
+			tokens = append(tokens, token{kind: tokenPercent, text: "%"})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, text: ")"})
+			i++
+		case r == '>' || r == '<' || r == '=':
+			op := string(r)
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				if r != '=' {
+					op += "="
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenCompare, text: op})
+		case r == '{':
+			tokens = append(tokens, token{kind: tokenLeftBrace, text: "{"})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{kind: tokenRightBrace, text: "}"})
+			i++
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			if i < len(runes) && runes[i] == '{' && runes[i-1] == 'd' {
+				// An inline die such as "d{red,green,blue}" runs to the closing brace.
+				end, err := findClosingBrace(runes, i)
+				if err != nil {
+					return nil, err
+				}
+				i = end + 1
+			} else if i < len(runes) && runes[i] == '[' && runes[i-1] == 'd' {
+				// A range die such as "d[3-8]" runs to the closing bracket.
+				end := slices.Index(runes[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("missing ']' in die range")
+				}
+				i += end + 1
+			} else if i+1 < len(runes) && runes[i] == '%' && runes[i+1] == '%' && runes[i-1] == 'd' {
+				// A percentile die such as "d%%" takes its two percent signs.
+				i += 2
+			} else if i < len(runes) && strings.ContainsRune("<>=", runes[i]) && hasExplodeMark(string(runes[start:i])) {
+				// A comparison touching "!" says which rolls explode, as in "3d10!>=9".
+				i = scanExplodeCondition(runes, i)
+			}
+			if extraSeparators[strings.ToLower(string(runes[start:i]))] {
+				// A separator word such as "and" acts like whitespace.
+				spaced = true
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i])})
+		case extraSeparators[string(r)]:
+			// A separator symbol such as "&" acts like whitespace.
+			spaced = true
+			i++
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' in dice notation", r)
+		}
+		tokens[count].spaced = spaced
+		spaced = false
+	}
+
+	return append(joinSpacedDice(tokens), token{kind: tokenEOF, text: ""}), nil
+}
+
+var (
+	// dieLetterRegex matches a die letter standing alone, e.g. the "d" of "3 d 6".
+	dieLetterRegex = regexp.MustCompile(`^[dDfF]$`)
+	// openDiceRegex matches a dice group still missing its sides, e.g. "3d" or "d".
+	openDiceRegex = regexp.MustCompile(`^\d*[dDfF]$`)
+	// closedDiceRegex matches a regular dice group that a spaced "!", "!!" or "!p" may follow.
+	closedDiceRegex = regexp.MustCompile(`^\d*[dD]\d+$`)
+	// explodeMarkRegex matches an exploding marker standing alone, with any explosion condition.
+	explodeMarkRegex = regexp.MustCompile(`^![!p]?(?:(?:[<>]=?|=)\d+)?$`)
+)
+
+// joinSpacedDice merges word tokens that together spell one dice group but were typed with
+// spaces between them, as described by tokenize.
+func joinSpacedDice(tokens []token) []token {
+	joined := make([]token, 0, len(tokens))
+	for _, tok := range tokens {
+		if last := len(joined) - 1; last >= 0 && tok.spaced && tok.kind == tokenWord && joined[last].kind == tokenWord {
+			previous := joined[last].text
+			if (isNumber(previous) && dieLetterRegex.MatchString(tok.text)) ||
+				(openDiceRegex.MatchString(previous) && isNumber(tok.text)) ||
+				(closedDiceRegex.MatchString(previous) && explodeMarkRegex.MatchString(tok.text)) {
+				joined[last].text += tok.text
+				continue
+			}
+		}
+		joined = append(joined, tok)
+	}
+	return joined
+}
+
+// hasExplodeMark reports whether a word ends with an exploding marker, "!", "!!" or "!p", that an
+// explosion condition may follow.
+func hasExplodeMark(word string) bool {
+	return strings.HasSuffix(word, "!") || strings.HasSuffix(word, "!p")
+}
+
+// scanExplodeCondition returns the index just past the comparison and number starting at start,
+// such as the ">=9" of "3d10!>=9".
+func scanExplodeCondition(runes []rune, start int) int {
+	i := start + 1
+	if i < len(runes) && runes[i] == '=' && runes[start] != '=' {
+		i++
+	}
+	for i < len(runes) && unicode.IsDigit(runes[i]) && runes[i] < unicode.MaxASCII {
+		i++
+	}
+	return i
+}
+
+// findClosingBrace returns the index of the unescaped '}' matching the '{' at open.
+func findClosingBrace(runes []rune, open int) (int, error) {
+	for i := open + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++ // Skip the escaped character.
+		case '}':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("missing '}' in inline die")
+}
+
+// isWordRune reports whether r can appear in a dice group, number or function name.
+func isWordRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '!')
+}
+
+// expressionParser is a recursive-descent parser over dice notation tokens.
+type expressionParser struct {
+	tokens []token
+	pos    int
+	groups []string       // Notation of each dice group parsed so far
+	pools  int            // Number of exclusive pools made so far by shared(...) and separate(...)
+	vars   map[string]int // Values of named variables, or nil if variables are not in use
+}
+
+// variableNameRegex matches names that can be used as variables.
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ErrUndefinedVariable is wrapped by the error for a name that is neither dice nor a known variable,
+// so callers can tell a mistyped variable from text that is not an expression at all.
+var ErrUndefinedVariable = errors.New("undefined variable")
+
+// parseExpression parses a complete dice expression into an evaluation tree,
+// also returning the notation of each dice group in the order they appear.
+func parseExpression(notation string, vars map[string]int) (node, []string, error) {
+	tokens, err := tokenize(notation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &expressionParser{tokens: tokens, vars: vars}
+	root, err := p.parseSum(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.isCondition() {
+		if root, err = p.parseConditional(root); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if p.isScale() {
+		if root, err = p.parseScale(root); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if p.isClamp() {
+		if root, err = p.parseClamp(root); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, nil, fmt.Errorf("unexpected '%s' in dice notation", tok.text)
+	}
+
+	return root, p.groups, nil
+}
+
+// addGroup records the notation of a dice group and returns its index.
+func (p *expressionParser) addGroup(notation string) int {
+	p.groups = append(p.groups, notation)
+	return len(p.groups) - 1
+}
+
+// peek returns the current token without consuming it.
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token.
+func (p *expressionParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseSum parses terms joined by "+", "-" or whitespace. At the top level a comma
+// also separates terms; inside a function call it separates arguments instead.
+func (p *expressionParser) parseSum(topLevel bool) (node, error) {
+	first, err := p.parseTerm()
+	if err == nil {
+		first, err = p.parseSuccessTarget(first)
+	}
+	if err == nil {
+		first, err = p.parseKeepIf(first)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := &sumNode{terms: []node{first}, signs: []int{1}}
+
+	for {
+		sign := 1
+		switch p.peek().kind {
+		case tokenPlus:
+			p.next()
+		case tokenMinus:
+			p.next()
+			sign = -1
+		case tokenComma:
+			if !topLevel {
+				return checkPools(sum.simplify())
+			}
+			p.next()
+		case tokenWord, tokenLeftBrace:
+			// Adjacent terms separated only by whitespace are added, but a condition or a clamp
+			// applies to the whole expression.
+			if topLevel && (p.isCondition() || p.isClamp()) {
+				return checkPools(sum.simplify())
+			}
+		default:
+			return checkPools(sum.simplify())
+		}
+
+		term, err := p.parseTerm()
+		if err == nil {
+			term, err = p.parseSuccessTarget(term)
+		}
+		if err == nil {
+			term, err = p.parseKeepIf(term)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum.terms = append(sum.terms, term)
+		sum.signs = append(sum.signs, sign)
+	}
+}
+
+// simplify merges adjacent added dice groups into a single pool and unwraps single-term sums.
+func (n *sumNode) simplify() node {
+	merged := &sumNode{}
+	for i, term := range n.terms {
+		last := len(merged.terms) - 1
+		if pool, isPool := term.(*poolNode); isPool && n.signs[i] > 0 && last >= 0 && merged.signs[last] > 0 {
+			if previous, wasPool := merged.terms[last].(*poolNode); wasPool {
+				combined := append(append([]Die{}, previous.pool...), pool.pool...)
+				merged.terms[last] = &poolNode{pool: combined}
+				continue
+			}
+		}
+		merged.terms = append(merged.terms, term)
+		merged.signs = append(merged.signs, n.signs[i])
+	}
+
+	if len(merged.terms) == 1 && merged.signs[0] > 0 {
+		return merged.terms[0]
+	}
+	return merged
+}
+
+// checkPools checks the exclusive groups of each pool in a simplified sum, since merging adjacent
+// dice groups can put more exclusive dice in a group than its die has faces, as in "3D6 4D6".
+func checkPools(n node) (node, error) {
+	terms := []node{n}
+	if sum, isSum := n.(*sumNode); isSum {
+		terms = sum.terms
+	}
+	for _, term := range terms {
+		if pool, isPool := term.(*poolNode); isPool {
+			if err := checkExclusiveGroups(pool.pool); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// parseTerm parses a number, a dice group, a braced group or a function call.
+func (p *expressionParser) parseTerm() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenWord:
+		if p.peek().kind == tokenLeftParen {
+			return p.parseCall(tok.text)
+		}
+		if match := advantageRegex.FindStringSubmatch(tok.text); match != nil {
+			return p.parseAdvantage(tok.text, match[1]), nil
+		}
+		if preset, isPreset := savageWorldsPresets[strings.ToLower(tok.text)]; isPreset {
+			return p.parseSavageWorlds(preset)
+		}
+		if isNumber(tok.text) {
+			value, err := strconv.Atoi(tok.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %s", tok.text)
+			}
+			return &constNode{value: value}, nil
+		}
+		if value, isVariable := p.vars[tok.text]; isVariable {
+			return &constNode{value: value}, nil
+		}
+		text, selection := tok.text, ""
+		dice, err := parseSingleDiceGroup(text)
+		if match := attachedSelectionRegex.FindStringSubmatch(text); err != nil && match != nil {
+			if selected, selectErr := parseSingleDiceGroup(match[1]); selectErr == nil {
+				text, selection, dice, err = match[1], match[2], selected, nil
+			}
+		}
+		if err != nil {
+			if poolSelectionRegex.MatchString(tok.text) {
+				return nil, fmt.Errorf("a keep, drop or middle selection must follow dice, e.g. 5d6 %s or {4d6 2d8}%s", tok.text, tok.text)
+			}
+			if p.vars != nil && variableNameRegex.MatchString(tok.text) {
+				return nil, fmt.Errorf("%w: %s", ErrUndefinedVariable, tok.text)
+			}
+			return nil, err
+		}
+		group := p.addGroup(text)
+		for i := range dice {
+			dice[i].group = group
+		}
+		if selection != "" {
+			return newKeepNode(&poolNode{pool: dice}, poolSelectionRegex.FindStringSubmatch(selection))
+		}
+		return p.parsePoolSelection(&poolNode{pool: dice})
+	case tokenLeftBrace:
+		return p.parseKeepGroup()
+	case tokenEOF:
+		return nil, fmt.Errorf("incomplete dice notation: expected dice or a number at the end")
+	default:
+		return nil, fmt.Errorf("unexpected '%s' in dice notation", tok.text)
+	}
+}
+
+// parseCall parses a function call such as "highest(2d20)", "pool(d6 d6 d8)", "unique(5d20)" or
+// "best(2d6+1, 1d12)".
+func (p *expressionParser) parseCall(name string) (node, error) {
+	p.next() // Consume the opening parenthesis.
+
+	var args []node
+	for {
+		arg, err := p.parseSum(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		tok := p.next()
+		if tok.kind == tokenRightParen {
+			break
+		}
+		if tok.kind == tokenStar || tok.kind == tokenSlash || tok.kind == tokenPercent {
+			return nil, fmt.Errorf("'*', '/' and '%%' must follow the whole expression, e.g. 1d6/2")
+		}
+		if tok.kind != tokenComma {
+			return nil, fmt.Errorf("expected ',' or ')' in %s(...)", name)
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "highest", "lowest":
+		var pool []Die
+		for _, arg := range args {
+			dice, isPool := arg.(*poolNode)
+			if !isPool {
+				return nil, fmt.Errorf("%s() takes dice only, e.g. %s(2d20)", name, name)
+			}
+			pool = append(pool, dice.pool...)
+		}
+		if err := checkExclusiveGroups(pool); err != nil {
+			return nil, err
+		}
+		return &selectNode{highest: strings.EqualFold(name, "highest"), arg: &poolNode{pool: pool}}, nil
+	case "pool":
+		var pool []Die
+		for _, arg := range args {
+			dice, isPool := arg.(*poolNode)
+			if !isPool {
+				return nil, fmt.Errorf("pool() takes dice only, e.g. pool(d6 d6 d8 d10)")
+			}
+			pool = append(pool, dice.pool...)
+		}
+		if err := checkExclusiveGroups(pool); err != nil {
+			return nil, err
+		}
+		return &poolNode{pool: pool}, nil
+	case "unique":
+		return newUniqueNode(args)
+	case "bestpertype":
+		return newPerTypeNode(args)
+	case "shared", "separate":
+		return p.newSharedNode(strings.ToLower(name), args, strings.EqualFold(name, "separate"))
+	case "best", "worst":
+		return &bestNode{highest: strings.EqualFold(name, "best"), args: args}, nil
+	case "clamp":
+		return nil, fmt.Errorf("clamp must follow the whole expression, e.g. 1d20+5 clamp(1,20)")
+	default:
+		return nil, fmt.Errorf("unknown function: %s", name)
+	}
+}
+
+// isNumber reports whether text consists only of decimal digits.
+func isNumber(text string) bool {
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return text != ""
+}
@@ -0,0 +1,198 @@
+package dice
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keepSuffixRegex matches the selection applied to a braced group, e.g. "kh3", "kl1", "k2", "dh1", "dl1" or "mid3".
+var keepSuffixRegex = regexp.MustCompile(`^(?i)(kh|kl|k|dh|dl|d|mid)(\d+)$`)
+
+// poolSelectionRegex matches the selection that may follow a single dice group, e.g. "kh3" in
+// "5F52 kh3", where it is written after a space, or in "5F52kh3", where it is not. A bare "d"
+// is left out, as "4d6 d1" and "4d6d1" read as dice.
+var poolSelectionRegex = regexp.MustCompile(`^(?i)(kh|kl|k|dh|dl|mid)(\d+)$`)
+
+// attachedSelectionRegex splits a dice group from a selection written straight after it, e.g.
+// "5F52kh3" into "5F52" and "kh3".
+var attachedSelectionRegex = regexp.MustCompile(`^(?i)(.+?)((?:kh|kl|k|dh|dl|mid)\d+)$`)
+
+// keepNode keeps the highest or lowest dice rolled anywhere in its argument, after all of them
+// have been rolled, e.g. "{4d6 2d8}kh3" or "5F52kh3". Dice of different sizes are compared by
+// score alone, so fancy dice such as the cards of "5F52kh3" are kept by their scoring values, and
+// ties go to the die rolled first.
+type keepNode struct {
+	arg     node
+	highest bool // Keep the highest dice rather than the lowest
+	count   int  // Number of dice to keep, or to drop if drop is set
+	drop    bool // Drop count dice from the opposite end instead of keeping count dice
+	middle  bool // Keep the middle count dice, dropping from both ends, e.g. "{5d6}mid3"
+}
+
+// kept returns the positions, among dice sorted best first, of the first and after the last
+// of the dice kept out of total.
+func (n *keepNode) kept(total int) (int, int) {
+	keep := n.count
+	if n.drop {
+		keep = total - n.count
+	}
+	keep = max(0, min(keep, total))
+	if n.middle {
+		// When the dice cannot be trimmed evenly, the extra die is dropped from the low end.
+		from := (total - keep + 1) / 2
+		return from, from + keep
+	}
+	return 0, keep
+}
+
+func (n *keepNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	total := n.arg.eval(result)
+
+	// Collect the dice still in play, best first.
+	var candidates []int
+	for i := start; i < len(result.DieRolls); i++ {
+		if !result.DieRolls[i].Dropped {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := result.DieRolls[candidates[i]].Score, result.DieRolls[candidates[j]].Score
+		if n.highest {
+			return a > b
+		}
+		return a < b
+	})
+
+	// Drop the rest, removing their scores from the total.
+	from, to := n.kept(len(candidates))
+	for position, i := range candidates {
+		if position < from || position >= to {
+			result.DieRolls[i].Dropped = true
+			total = addScore(result, total, -result.DieRolls[i].Score)
+		}
+	}
+	return total
+}
+
+func (n *keepNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *keepNode) bounds() (int, int) {
+	dice := n.arg.dice()
+	lows := make([]int, len(dice))
+	highs := make([]int, len(dice))
+	diceLow, diceHigh := 0, 0
+	for i, die := range dice {
+		lows[i], highs[i] = poolBounds([]Die{die})
+		diceLow += lows[i]
+		diceHigh += highs[i]
+	}
+
+	// Flat modifiers inside the braces are unaffected by the selection.
+	argLow, argHigh := n.arg.bounds()
+	low, high := argLow-diceLow, argHigh-diceHigh
+
+	// The extremes occur when every die shows its minimum or every die shows its maximum.
+	from, to := n.kept(len(dice))
+	low += sumKept(lows, from, to, n.highest)
+	high += sumKept(highs, from, to, n.highest)
+	return low, high
+}
+
+// sumKept returns the sum of the values at positions from up to to, when sorted highest or lowest first.
+func sumKept(values []int, from, to int, highest bool) int {
+	sorted := append([]int{}, values...)
+	sort.Ints(sorted)
+	if highest {
+		slices.Reverse(sorted)
+	}
+
+	sum := 0
+	for _, value := range sorted[from:to] {
+		sum += value
+	}
+	return sum
+}
+
+// parseKeepGroup parses a braced group and its optional selection, e.g. "{4d6 2d8}kh3".
+// The opening brace has already been consumed.
+func (p *expressionParser) parseKeepGroup() (node, error) {
+	arg, err := p.parseSum(true)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != tokenRightBrace {
+		return nil, fmt.Errorf("missing '}' after dice group")
+	}
+	if subtractsDice(arg) {
+		return nil, fmt.Errorf("dice inside {...} cannot be subtracted")
+	}
+
+	// A group without a selection attached directly after the brace just groups its contents.
+	tok := p.peek()
+	if tok.kind != tokenWord || tok.spaced || !keepSuffixRegex.MatchString(tok.text) {
+		return arg, nil
+	}
+	p.next()
+	return newKeepNode(arg, keepSuffixRegex.FindStringSubmatch(tok.text))
+}
+
+// parsePoolSelection parses a selection after a single dice group, e.g. "5d6 mid3", if there is one.
+// A selection written straight after the dice, as in "5d6mid3", is split off by parseTerm.
+func (p *expressionParser) parsePoolSelection(pool node) (node, error) {
+	tok := p.peek()
+	if tok.kind != tokenWord || !poolSelectionRegex.MatchString(tok.text) {
+		return pool, nil
+	}
+	if _, isVariable := p.vars[tok.text]; isVariable {
+		return pool, nil
+	}
+	p.next()
+	return newKeepNode(pool, poolSelectionRegex.FindStringSubmatch(tok.text))
+}
+
+// newKeepNode builds the selection matched by keepSuffixRegex or poolSelectionRegex.
+func newKeepNode(arg node, match []string) (node, error) {
+	count, err := strconv.Atoi(match[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid keep count: %s", match[2])
+	}
+
+	mode := strings.ToLower(match[1])
+	switch mode {
+	case "k", "kh":
+		return &keepNode{arg: arg, highest: true, count: count}, nil
+	case "kl":
+		return &keepNode{arg: arg, highest: false, count: count}, nil
+	case "d", "dl":
+		// Dropping the lowest dice keeps the highest.
+		return &keepNode{arg: arg, highest: true, count: count, drop: true}, nil
+	case "mid":
+		if dice := len(arg.dice()); count > dice {
+			return nil, fmt.Errorf("cannot keep the middle %d of %d dice", count, dice)
+		}
+		return &keepNode{arg: arg, count: count, middle: true}, nil
+	default:
+		return &keepNode{arg: arg, highest: false, count: count, drop: true}, nil
+	}
+}
+
+// subtractsDice reports whether any dice in the node are subtracted rather than added.
+func subtractsDice(n node) bool {
+	sum, isSum := n.(*sumNode)
+	if !isSum {
+		return false
+	}
+	for i, term := range sum.terms {
+		if len(term.dice()) > 0 && (sum.signs[i] < 0 || subtractsDice(term)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeptIf records how many dice a conditional keep such as "6d6 keep>=5" kept.
+type KeptIf struct {
+	Condition string // The condition, e.g. ">=5"
+	Kept      int    // How many dice met the condition and were kept
+	Rolled    int    // How many dice were rolled
+}
+
+// String describes the outcome, e.g. "kept 2 of 6 dice meeting >=5".
+func (k KeptIf) String() string {
+	return fmt.Sprintf("kept %d of %d dice meeting %s", k.Kept, k.Rolled, k.Condition)
+}
+
+// keepIfNode keeps only the dice of its argument that meet a condition and sums them, e.g.
+// "6d6 keep>=5". Unlike a success target, which counts the dice that meet it, the kept dice are
+// added up; the others are shown as dropped, and if none are kept the total is 0.
+type keepIfNode struct {
+	arg       node
+	condition comparison
+}
+
+func (n *keepIfNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	modifier := result.Modifier
+	n.arg.eval(result)
+	// Flat modifiers inside the kept term do not count.
+	result.Modifier = modifier
+
+	total := 0
+	outcome := KeptIf{Condition: n.condition.String()}
+	for i := start; i < len(result.DieRolls); i++ {
+		if result.DieRolls[i].Dropped {
+			continue
+		}
+		outcome.Rolled++
+		if n.condition.matches(result.DieRolls[i].Score) {
+			outcome.Kept++
+			total = addScore(result, total, result.DieRolls[i].Score)
+		} else {
+			result.DieRolls[i].Dropped = true
+		}
+	}
+
+	// Several conditional keeps in one expression add up their counts.
+	if result.KeptIf != nil {
+		outcome.Kept += result.KeptIf.Kept
+		outcome.Rolled += result.KeptIf.Rolled
+	}
+	result.KeptIf = &outcome
+	return total
+}
+
+func (n *keepIfNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *keepIfNode) bounds() (int, int) {
+	low, high := 0, 0
+	for _, die := range n.arg.dice() {
+		dieLow, dieHigh := poolBounds([]Die{die})
+		keptLow, keptHigh, possible := n.condition.matchingRange(dieLow, dieHigh)
+		switch {
+		case !possible:
+			// The die is always dropped and adds nothing.
+		case n.condition.certain(dieLow, dieHigh):
+			low += keptLow
+			high += keptHigh
+		default:
+			// The die is either dropped, adding 0, or kept.
+			low += min(0, keptLow)
+			high += max(0, keptHigh)
+		}
+	}
+	return low, high
+}
+
+func (n *keepIfNode) canonical() string {
+	return n.arg.canonical() + " keep" + n.condition.String()
+}
+
+// matchingRange returns the lowest and highest values between low and high that meet the
+// comparison, or false if none do.
+func (c comparison) matchingRange(low, high int) (int, int, bool) {
+	switch c.op {
+	case ">=":
+		low = max(low, c.target)
+	case ">":
+		low = max(low, c.target+1)
+	case "<=":
+		high = min(high, c.target)
+	case "<":
+		high = min(high, c.target-1)
+	default:
+		low, high = max(low, c.target), min(high, c.target)
+	}
+	return low, high, low <= high
+}
+
+// parseKeepIf wraps term in a keepIfNode if "keep" and a comparison follow it, as in "6d6 keep>=5".
+func (p *expressionParser) parseKeepIf(term node) (node, error) {
+	tok := p.peek()
+	if tok.kind != tokenWord || !strings.EqualFold(tok.text, "keep") || p.tokens[p.pos+1].kind != tokenCompare {
+		return term, nil
+	}
+	if len(term.dice()) == 0 {
+		return nil, fmt.Errorf("a conditional keep must follow dice, e.g. 6d6 keep>=5")
+	}
+	if _, isSuccess := term.(*successNode); isSuccess {
+		return nil, fmt.Errorf("dice cannot both count successes and keep by a condition")
+	}
+	p.next() // Consume "keep".
+	condition, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	return &keepIfNode{arg: term, condition: condition}, nil
+}
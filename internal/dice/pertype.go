@@ -0,0 +1,90 @@
+package dice
+
+import "fmt"
+
+// perTypeNode keeps only the single highest-scoring die of each type in a mixed pool, e.g.
+// "bestpertype(4d6 3d8)" keeps the best d6 and the best d8. Ties go to the die rolled first,
+// and every other die is shown as dropped.
+type perTypeNode struct {
+	arg *poolNode
+}
+
+func (n *perTypeNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	total := n.arg.eval(result)
+
+	best := make(map[string]int) // Position of the best die so far of each type
+	for i := start; i < len(result.DieRolls); i++ {
+		if result.DieRolls[i].Dropped {
+			continue
+		}
+		key := typeKey(result.DieRolls[i].Die)
+		kept, seen := best[key]
+		switch {
+		case !seen:
+			best[key] = i
+		case result.DieRolls[i].Score > result.DieRolls[kept].Score:
+			best[key] = i
+			result.DieRolls[kept].Dropped = true
+			total = addScore(result, total, -result.DieRolls[kept].Score)
+		default:
+			result.DieRolls[i].Dropped = true
+			total = addScore(result, total, -result.DieRolls[i].Score)
+		}
+	}
+	return total
+}
+
+func (n *perTypeNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *perTypeNode) bounds() (int, int) {
+	// Dice of the same type have the same bounds, so each type contributes one die's worth.
+	low, high := 0, 0
+	counted := make(map[string]bool)
+	for _, die := range n.arg.pool {
+		if key := typeKey(die); !counted[key] {
+			counted[key] = true
+			dieLow, dieHigh := poolBounds([]Die{die})
+			low += dieLow
+			high += dieHigh
+		}
+	}
+	return low, high
+}
+
+func (n *perTypeNode) canonical() string {
+	return "bestpertype(" + n.arg.canonical() + ")"
+}
+
+// typeKey names the type of a die for bestpertype(...), treating an exclusive die as the same
+// type as its ordinary counterpart, as DieRoll.Type does.
+func typeKey(d Die) string {
+	switch {
+	case d.Sides < -1000:
+		return fmt.Sprintf("f%d", -d.Sides-1000)
+	case d.Sides > 1000:
+		return fmt.Sprintf("d%d", d.Sides-1000)
+	}
+	return d.notation()
+}
+
+// newPerTypeNode builds a bestpertype(...) node from the arguments of the call, which must be
+// dice that roll once each, so that each die is one candidate for its type.
+func newPerTypeNode(args []node) (node, error) {
+	var pool []Die
+	for _, arg := range args {
+		dice, isPool := arg.(*poolNode)
+		if !isPool {
+			return nil, fmt.Errorf("bestpertype() takes dice only, e.g. bestpertype(4d6 3d8)")
+		}
+		for _, die := range dice.pool {
+			if die.Explode != ExplodeNone {
+				return nil, fmt.Errorf("bestpertype() cannot select among exploding dice")
+			}
+		}
+		pool = append(pool, dice.pool...)
+	}
+	return &perTypeNode{arg: &poolNode{pool: pool}}, nil
+}
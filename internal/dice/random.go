@@ -0,0 +1,84 @@
+package dice
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+)
+
+var (
+	randomMutex sync.Mutex
+	seeded      *rand.Rand // Seeded generator, or nil to use the thread-safe global generator
+	currentSeed uint64     // The seed given to the seeded generator
+	averaged    bool       // Every die shows its rounded average instead of a random face
+)
+
+// SetSeed makes all subsequent rolls reproducible by drawing them from a generator seeded with seed.
+func SetSeed(seed uint64) {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	seeded = rand.New(rand.NewPCG(seed, seed))
+	currentSeed = seed
+}
+
+// Reseed seeds the generator with a fresh seed from crypto/rand, as SetSeed does, and returns it,
+// so that the rolls that follow can be reproduced later.
+func Reseed() uint64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic("dice: cannot read a random seed: " + err.Error())
+	}
+	seed := binary.LittleEndian.Uint64(buf[:])
+	SetSeed(seed)
+	return seed
+}
+
+// Seed returns the seed most recently given to SetSeed or Reseed. It reports false if the dice
+// are unseeded and drawn from Go's global math/rand/v2 generator, which the runtime seeds
+// randomly and whose seed cannot be read back.
+func Seed() (uint64, bool) {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	return currentSeed, seeded != nil
+}
+
+// GeneratorName names the generator rolls are currently drawn from.
+func GeneratorName() string {
+	if _, isSeeded := Seed(); isSeeded {
+		return "PCG"
+	}
+	return "ChaCha8"
+}
+
+// SetAverage makes every die show its rounded average instead of a random value while on is set,
+// so output is the same on every run without a seed: a d6 shows 4 and a d20 shows 11 (halves
+// round up), and a fancy die shows the face whose score is nearest the mean of its scores.
+// Exclusive dice still differ, taking the middle of the faces that remain, and exploding dice do
+// not explode.
+func SetAverage(on bool) {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	averaged = on
+}
+
+// averaging reports whether SetAverage is on.
+func averaging() bool {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	return averaged
+}
+
+// randomIntN returns a random integer in [0, n) from the seeded generator if one is set,
+// or the middle, n/2, if dice show their averages.
+func randomIntN(n int) int {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	if averaged {
+		return n / 2
+	}
+	if seeded == nil {
+		return rand.IntN(n)
+	}
+	return seeded.IntN(n)
+}
@@ -0,0 +1,76 @@
+package dice
+
+import "fmt"
+
+// Degree grades the outcome of a roll-under check such as "d100<=45".
+type Degree int
+
+const (
+	// Fumble is a failure on one of the highest faces, e.g. 100, or 96-100 against a target below 50 on a d100.
+	Fumble Degree = iota
+	// Failure is a roll above the target.
+	Failure
+	// Success is a roll at or below the target.
+	Success
+	// CriticalSuccess is a roll at or below a fifth of the target.
+	CriticalSuccess
+)
+
+func (d Degree) String() string {
+	switch d {
+	case Fumble:
+		return "Fumble"
+	case Failure:
+		return "Failure"
+	case Success:
+		return "Success"
+	default:
+		return "Critical success"
+	}
+}
+
+// RollUnder is the graded outcome of rolling a single die at or under a target, as in
+// Basic Roleplaying and Call of Cthulhu.
+type RollUnder struct {
+	Roll   int    // The number rolled
+	Target int    // The target to roll at or under
+	Degree Degree // How well the roll did
+}
+
+func (r RollUnder) String() string {
+	return fmt.Sprintf("%s: rolled %d against %d", r.Degree, r.Roll, r.Target)
+}
+
+// gradeRollUnder grades a roll of a die with the given number of sides against a target.
+// A roll of the highest face always fumbles; against a target below half the sides, the top
+// twentieth of the faces fumble too, so 96-100 on a d100.
+func gradeRollUnder(roll, sides, target int) Degree {
+	fumbleFrom := sides
+	if target < sides/2 {
+		fumbleFrom = sides - sides/20 + 1
+	}
+
+	switch {
+	case roll == sides || (roll > target && roll >= fumbleFrom):
+		return Fumble
+	case roll > target:
+		return Failure
+	case roll <= target/5:
+		return CriticalSuccess
+	default:
+		return Success
+	}
+}
+
+// rollUnderCheck returns the success node of an expression that is a single regular die rolled
+// at or under a target, such as "d100<=45", or nil for any other expression.
+func rollUnderCheck(root node) *successNode {
+	check, isSuccess := root.(*successNode)
+	if !isSuccess || check.target.op != "<=" {
+		return nil
+	}
+	if dice := check.arg.dice(); len(dice) != 1 || dice[0].Sides <= 0 || dice[0].Sides > 1000 || dice[0].Explode != ExplodeNone || dice[0].Offset != 0 || len(dice[0].Faces) > 0 {
+		return nil
+	}
+	return check
+}
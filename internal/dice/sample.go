@@ -0,0 +1,91 @@
+package dice
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Estimate is an empirical estimate of the distribution of a dice expression's total,
+// found by rolling it many times.
+type Estimate struct {
+	Samples int         // Number of times the expression was rolled
+	Mean    float64     // Mean of the sampled totals
+	StdDev  float64     // Sample standard deviation of the totals
+	StdErr  float64     // Standard error of the mean, StdDev / sqrt(Samples)
+	Min     int         // Smallest total sampled
+	Max     int         // Largest total sampled
+	Counts  map[int]int // Number of samples giving each total
+}
+
+// RollN returns an iterator that rolls the dice set n times, one roll per call. Each call returns
+// the next result and true, or false once n rolls have been made, so callers can process huge
+// batches one result at a time without holding them all in memory:
+//
+//	next := diceSet.RollN(1000000)
+//	for result, ok := next(); ok; result, ok = next() {
+//		...
+//	}
+func (ds DiceSet) RollN(n int) func() (RollResult, bool) {
+	rolled := 0
+	return func() (RollResult, bool) {
+		if rolled >= n {
+			return RollResult{}, false
+		}
+		rolled++
+		return ds.Roll(), true
+	}
+}
+
+// Sample rolls the dice set the given number of times and estimates the distribution of its
+// total. It suits expressions whose exact distribution is hard to compute, such as exploding
+// dice or keep/drop combinations; call SetSeed first for a reproducible estimate.
+func (ds DiceSet) Sample(samples int) (Estimate, error) {
+	if samples < 2 {
+		return Estimate{}, fmt.Errorf("at least 2 samples are needed, got %d", samples)
+	}
+
+	estimate := Estimate{Samples: samples, Counts: make(map[int]int)}
+	sum := 0.0
+	next := ds.RollN(samples)
+	for i := 0; ; i++ {
+		result, ok := next()
+		if !ok {
+			break
+		}
+		if result.Err != nil {
+			return Estimate{}, result.Err
+		}
+		if result.Overflow {
+			return Estimate{}, fmt.Errorf("the total is too large to compute")
+		}
+		if i == 0 || result.Total < estimate.Min {
+			estimate.Min = result.Total
+		}
+		if i == 0 || result.Total > estimate.Max {
+			estimate.Max = result.Total
+		}
+		estimate.Counts[result.Total]++
+		sum += float64(result.Total)
+	}
+	estimate.Mean = sum / float64(samples)
+
+	squares := 0.0
+	for total, count := range estimate.Counts {
+		deviation := float64(total) - estimate.Mean
+		squares += deviation * deviation * float64(count)
+	}
+	estimate.StdDev = math.Sqrt(squares / float64(samples-1))
+	estimate.StdErr = estimate.StdDev / math.Sqrt(float64(samples))
+	return estimate, nil
+}
+
+// Totals returns the distinct totals sampled, in ascending order.
+func (e Estimate) Totals() []int {
+	totals := make([]int, 0, len(e.Counts))
+	for total := range e.Counts {
+		totals = append(totals, total)
+	}
+	sort.Ints(totals)
+	return totals
+}
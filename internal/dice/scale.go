@@ -0,0 +1,239 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rounding is how a total divided by "/", as in "1d6/2", is rounded to a whole number.
+type Rounding int
+
+const (
+	// RoundDown rounds towards negative infinity, the usual rule for halved damage.
+	RoundDown Rounding = iota
+	// RoundUp rounds towards positive infinity.
+	RoundUp
+	// RoundNearest rounds to the nearest whole number, with halves rounded away from zero.
+	RoundNearest
+)
+
+// roundingNames are the names of the rounding rules, as given to ParseRounding.
+var roundingNames = map[Rounding]string{RoundDown: "floor", RoundUp: "ceil", RoundNearest: "nearest"}
+
+// String returns the name of the rounding rule: "floor", "ceil" or "nearest".
+func (r Rounding) String() string {
+	return roundingNames[r]
+}
+
+// ParseRounding parses the name of a rounding rule: "floor", "ceil" or "nearest".
+func ParseRounding(name string) (Rounding, error) {
+	for rounding, known := range roundingNames {
+		if strings.EqualFold(name, known) {
+			return rounding, nil
+		}
+	}
+	return RoundDown, fmt.Errorf("rounding must be \"floor\", \"ceil\" or \"nearest\", got %q", name)
+}
+
+// rounding is the rule used to round divided totals. It rounds down by default.
+var rounding = RoundDown
+
+// SetRounding sets how a total divided by "/" is rounded to a whole number.
+func SetRounding(r Rounding) {
+	rounding = r
+}
+
+// Scale records a total that was multiplied, divided or reduced to a remainder, e.g. "1d6/2" or "1d100%10".
+type Scale struct {
+	Unscaled int      // The total before scaling
+	Scaled   int      // The total after scaling and rounding
+	Steps    []string // Each multiplication, division or remainder in order, e.g. "/ 2"
+	Rounded  bool     // True if dividing left a remainder, so the total was rounded
+	Rounding Rounding // How the total was rounded
+}
+
+// String describes the scaling, e.g. "7 / 2 = 3 rounded down", "4 * 3 = 12" or "57 % 10 = 7".
+func (s Scale) String() string {
+	text := fmt.Sprintf("%d %s = %d", s.Unscaled, strings.Join(s.Steps, " "), s.Scaled)
+	if s.Rounded {
+		switch s.Rounding {
+		case RoundUp:
+			text += " rounded up"
+		case RoundNearest:
+			text += " rounded to nearest"
+		default:
+			text += " rounded down"
+		}
+	}
+	return text
+}
+
+// scaleOp is a single multiplication, division or remainder of a total.
+type scaleOp struct {
+	operator rune // '*', '/' or '%'
+	factor   int
+}
+
+// scaleNode multiplies, divides or takes the remainder of the total of a whole expression, e.g.
+// "1d6/2", "2d6+3 * 2" or "1d100%10". The operators apply in order from left to right, after every
+// other term. Dividing is exact until a remainder is taken or the end is reached, so "1d6*3/2"
+// rounds only once; a remainder is never negative, so "%10" always gives 0 to 9.
+type scaleNode struct {
+	arg node
+	ops []scaleOp
+}
+
+// apply scales a total, reporting whether it had to be rounded and false if it overflowed.
+func (n *scaleNode) apply(total int, r Rounding) (int, bool, bool) {
+	numerator, denominator := total, 1
+	rounded := false
+	for _, op := range n.ops {
+		switch op.operator {
+		case '*':
+			if !multiplies(&numerator, op.factor) {
+				return 0, false, false
+			}
+		case '/':
+			if !multiplies(&denominator, op.factor) {
+				return 0, false, false
+			}
+		case '%':
+			whole, inexact := roundQuotient(numerator, denominator, r)
+			rounded = rounded || inexact
+			numerator, denominator = floorMod(whole, op.factor), 1
+		}
+	}
+	whole, inexact := roundQuotient(numerator, denominator, r)
+	return whole, rounded || inexact, true
+}
+
+// multiplies multiplies *product by factor, reporting false if the result overflowed.
+func multiplies(product *int, factor int) bool {
+	if *product != 0 && (*product*factor)/factor != *product {
+		return false
+	}
+	*product *= factor
+	return true
+}
+
+// floorMod returns the remainder of dividing by a positive divisor, from 0 to divisor-1 even for negative numbers.
+func floorMod(number, divisor int) int {
+	return (number%divisor + divisor) % divisor
+}
+
+// roundQuotient divides by a positive denominator and rounds the quotient to a whole number,
+// reporting whether it had to be rounded.
+func roundQuotient(numerator, denominator int, r Rounding) (int, bool) {
+	quotient, remainder := numerator/denominator, numerator%denominator
+	if remainder == 0 {
+		return quotient, false
+	}
+	// Go division truncates towards zero, so only one direction needs correcting.
+	positive := numerator > 0
+	switch r {
+	case RoundDown:
+		if !positive {
+			quotient--
+		}
+	case RoundUp:
+		if positive {
+			quotient++
+		}
+	case RoundNearest:
+		if distance := max(remainder, -remainder); distance >= denominator-distance {
+			if positive {
+				quotient++
+			} else {
+				quotient--
+			}
+		}
+	}
+	return quotient, true
+}
+
+func (n *scaleNode) eval(result *RollResult) int {
+	total := n.arg.eval(result)
+	scaled, rounded, ok := n.apply(total, rounding)
+	if !ok {
+		result.Overflow = true
+	}
+	result.Scaled = &Scale{Unscaled: total, Scaled: scaled, Steps: n.steps(), Rounded: rounded, Rounding: rounding}
+	return scaled
+}
+
+func (n *scaleNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *scaleNode) bounds() (int, int) {
+	// Every factor is positive, so multiplying, dividing and rounding keep the order of the bounds.
+	// A remainder keeps it too unless the range wraps past a multiple, when it can be anything.
+	low, high := n.arg.bounds()
+	start := 0
+	for i, op := range n.ops {
+		if op.operator != '%' {
+			continue
+		}
+		segment := &scaleNode{ops: n.ops[start:i]}
+		low, _, _ = segment.apply(low, rounding)
+		high, _, _ = segment.apply(high, rounding)
+		if high-low+1 >= op.factor || floorMod(low, op.factor) > floorMod(high, op.factor) {
+			low, high = 0, op.factor-1
+		} else {
+			low, high = floorMod(low, op.factor), floorMod(high, op.factor)
+		}
+		start = i + 1
+	}
+	segment := &scaleNode{ops: n.ops[start:]}
+	low, _, _ = segment.apply(low, rounding)
+	high, _, _ = segment.apply(high, rounding)
+	return low, high
+}
+
+func (n *scaleNode) canonical() string {
+	return n.arg.canonical() + " " + strings.Join(n.steps(), " ")
+}
+
+// steps writes each multiplication, division or remainder, e.g. "* 3", "/ 2" or "% 10".
+func (n *scaleNode) steps() []string {
+	steps := make([]string, len(n.ops))
+	for i, op := range n.ops {
+		steps[i] = fmt.Sprintf("%c %d", op.operator, op.factor)
+	}
+	return steps
+}
+
+// isScale reports whether the parser is at a "*", "/" or "%" that scales the whole expression.
+func (p *expressionParser) isScale() bool {
+	kind := p.peek().kind
+	return kind == tokenStar || kind == tokenSlash || kind == tokenPercent
+}
+
+// parseScale parses one or more "*N", "/N" or "%N" after a complete expression.
+func (p *expressionParser) parseScale(arg node) (node, error) {
+	scale := &scaleNode{arg: arg}
+	for p.isScale() {
+		op := p.next()
+		tok := p.next()
+		if tok.kind != tokenWord || !isNumber(tok.text) {
+			return nil, fmt.Errorf("'%s' must be followed by a whole number, e.g. 1d6%s2", op.text, op.text)
+		}
+		factor, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", tok.text)
+		}
+		switch {
+		case factor == 0 && op.kind == tokenStar:
+			return nil, fmt.Errorf("cannot multiply by zero")
+		case factor == 0:
+			return nil, fmt.Errorf("cannot divide by zero in '%s0'", op.text)
+		}
+		scale.ops = append(scale.ops, scaleOp{operator: []rune(op.text)[0], factor: factor})
+	}
+
+	if kind := p.peek().kind; kind == tokenPlus || kind == tokenMinus || kind == tokenWord && !p.isClamp() {
+		return nil, fmt.Errorf("'*', '/' and '%%' apply to the whole total, so they must come after every other term, e.g. 2d6+3/2")
+	}
+	return scale, nil
+}
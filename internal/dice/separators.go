@@ -0,0 +1,69 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// extraSeparators holds the separators recognized between dice groups in addition to whitespace,
+// commas and plus signs, e.g. "and" or "&". Words are stored in lower case.
+var extraSeparators = map[string]bool{}
+
+// reservedOperators are characters kept free for arithmetic, so they cannot become separators.
+const reservedOperators = `*/%^<>=?:;|~\`
+
+// reservedWords are words with a meaning of their own in dice notation.
+var reservedWords = []string{"crit", "on", "add", "keep"}
+
+// SetSeparators replaces the extra separators that may appear between dice groups, so that
+// "2d6 and 1d8" means the same as "2d6 1d8". Each separator is either a word of letters, matched
+// case-insensitively as a whole word, or a single symbol such as "&". Words that are themselves
+// dice notation or function names, and symbols that already mean something or are reserved for
+// arithmetic, are rejected. An empty list restores the default separators.
+func SetSeparators(separators []string) error {
+	replacement := make(map[string]bool, len(separators))
+	for _, separator := range separators {
+		if err := checkSeparator(separator); err != nil {
+			return err
+		}
+		replacement[strings.ToLower(separator)] = true
+	}
+	extraSeparators = replacement
+	return nil
+}
+
+// checkSeparator reports why a separator cannot be used, or nil if it can.
+func checkSeparator(separator string) error {
+	if utf8.RuneCountInString(separator) == 1 {
+		r, _ := utf8.DecodeRuneInString(separator)
+		switch {
+		case unicode.IsSpace(r) || isWordRune(r) || strings.ContainsRune("+-,(){}", r):
+			return fmt.Errorf("'%s' already has a meaning in dice notation and cannot be a separator", separator)
+		case strings.ContainsRune(reservedOperators, r):
+			return fmt.Errorf("'%s' is reserved for arithmetic and cannot be a separator", separator)
+		}
+		return nil
+	}
+
+	for _, r := range separator {
+		if r >= unicode.MaxASCII || !unicode.IsLetter(r) {
+			return fmt.Errorf("invalid separator '%s': use a single symbol or a word of letters", separator)
+		}
+	}
+	for _, word := range reservedWords {
+		if strings.EqualFold(separator, word) {
+			return fmt.Errorf("'%s' is reserved and cannot be a separator", separator)
+		}
+	}
+
+	// Reject words that would otherwise parse as dice, such as "adv", or name a function.
+	if _, _, err := parseExpression(separator, nil); err == nil {
+		return fmt.Errorf("'%s' is dice notation and cannot be a separator", separator)
+	}
+	if _, _, err := parseExpression(separator+"(d6)", nil); err == nil {
+		return fmt.Errorf("'%s' is a function name and cannot be a separator", separator)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sharedNode rolls exclusive dice in explicitly chosen pools. "shared(3D6 d4 2D6)" puts every
+// exclusive die of the same size into one pool, so no two of the five d6s match even though the d4
+// comes between them. "separate(3D6, 2D6)" gives each argument a pool of its own, so the 2D6 may
+// repeat faces of the 3D6 even though they are adjacent. Each die carries its pool, which
+// groupExclusiveDice uses in place of the rule that adjacent exclusive dice of the same size share.
+type sharedNode struct {
+	pool     []Die
+	separate bool
+}
+
+func (n *sharedNode) eval(result *RollResult) int {
+	return rollPool(n.pool, result)
+}
+
+func (n *sharedNode) dice() []Die {
+	return n.pool
+}
+
+func (n *sharedNode) bounds() (int, int) {
+	return poolBounds(n.pool)
+}
+
+func (n *sharedNode) canonical() string {
+	if !n.separate {
+		return "shared(" + strings.Join(countDice(n.pool, false), "+") + ")"
+	}
+	// Each run of dice with the same pool is one argument.
+	var args []string
+	start := 0
+	for i := 1; i <= len(n.pool); i++ {
+		if i == len(n.pool) || n.pool[i].sharePool != n.pool[start].sharePool {
+			args = append(args, strings.Join(countDice(n.pool[start:i], false), "+"))
+			start = i
+		}
+	}
+	return "separate(" + strings.Join(args, ", ") + ")"
+}
+
+// newSharedNode builds a shared(...) or separate(...) node from the arguments of the call, which
+// must be dice. Dice that are not exclusive roll as usual.
+func (p *expressionParser) newSharedNode(name string, args []node, separate bool) (node, error) {
+	var pool []Die
+	p.pools++
+	for _, arg := range args {
+		dice, isPool := arg.(*poolNode)
+		if !isPool {
+			return nil, fmt.Errorf("%s() takes dice only, e.g. %s(3D6, 2D6)", name, name)
+		}
+		for _, die := range dice.pool {
+			die.sharePool = p.pools
+			pool = append(pool, die)
+		}
+		if separate {
+			p.pools++
+		}
+	}
+	if err := checkExclusiveGroups(pool); err != nil {
+		return nil, err
+	}
+	return &sharedNode{pool: pool, separate: separate}, nil
+}
@@ -0,0 +1,161 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// comparison is a condition on a single value, such as the ">=7" in "6d10>=7".
+type comparison struct {
+	op     string // One of ">=", "<=", ">", "<" or "="
+	target int
+}
+
+// matches reports whether value meets the comparison.
+func (c comparison) matches(value int) bool {
+	switch c.op {
+	case ">=":
+		return value >= c.target
+	case "<=":
+		return value <= c.target
+	case ">":
+		return value > c.target
+	case "<":
+		return value < c.target
+	default:
+		return value == c.target
+	}
+}
+
+// possible reports whether some value between low and high meets the comparison.
+func (c comparison) possible(low, high int) bool {
+	switch c.op {
+	case ">=", ">":
+		return c.matches(high)
+	case "<=", "<":
+		return c.matches(low)
+	default:
+		return low <= c.target && c.target <= high
+	}
+}
+
+// certain reports whether every value between low and high meets the comparison.
+func (c comparison) certain(low, high int) bool {
+	return c.matches(low) && c.matches(high) && (c.op != "=" || low == high)
+}
+
+func (c comparison) String() string {
+	return c.op + strconv.Itoa(c.target)
+}
+
+// parseComparison parses a comparison operator followed by a whole number.
+func (p *expressionParser) parseComparison() (comparison, error) {
+	op := p.next().text
+	tok := p.next()
+	if tok.kind != tokenWord || !isNumber(tok.text) {
+		return comparison{}, fmt.Errorf("expected a number after '%s'", op)
+	}
+	target, err := strconv.Atoi(tok.text)
+	if err != nil {
+		return comparison{}, fmt.Errorf("invalid number: %s", tok.text)
+	}
+	return comparison{op: op, target: target}, nil
+}
+
+// successNode counts the dice of its argument that meet a target, e.g. "6d10>=7".
+// Its value is the number of successes rather than the sum of the dice.
+type successNode struct {
+	arg    node
+	target comparison
+}
+
+func (n *successNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	modifier := result.Modifier
+	n.arg.eval(result)
+	// Flat modifiers inside the counted term do not count.
+	result.Modifier = modifier
+
+	successes := 0
+	for i := start; i < len(result.DieRolls); i++ {
+		if !result.DieRolls[i].Dropped && n.target.matches(result.DieRolls[i].Score) {
+			result.DieRolls[i].Success = true
+			successes++
+		}
+	}
+	result.Successes += successes
+	return successes
+}
+
+func (n *successNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *successNode) bounds() (int, int) {
+	low, high := 0, 0
+	for _, die := range n.arg.dice() {
+		dieLow, dieHigh := poolBounds([]Die{die})
+		if n.target.certain(dieLow, dieHigh) {
+			low++
+		}
+		if n.target.possible(dieLow, dieHigh) {
+			high++
+		}
+	}
+	return low, high
+}
+
+func (n *successNode) canonical() string {
+	arg := n.arg.canonical()
+	if hasExplodeMark(arg) {
+		// A space keeps the target from being read as an explosion condition, as in "3d10! >=9".
+		arg += " "
+	}
+	return arg + n.target.String()
+}
+
+// parseSuccessTarget wraps term in a successNode if a comparison follows it, as in "6d10>=7".
+func (p *expressionParser) parseSuccessTarget(term node) (node, error) {
+	if p.peek().kind != tokenCompare {
+		return term, nil
+	}
+	if len(term.dice()) == 0 {
+		return nil, fmt.Errorf("a success target must follow dice, e.g. 6d10>=7")
+	}
+	target, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	return &successNode{arg: term, target: target}, nil
+}
+
+// countsSuccesses reports whether any part of the expression counts successes.
+func countsSuccesses(n node) bool {
+	switch n := n.(type) {
+	case *successNode:
+		return true
+	case *sumNode:
+		for _, term := range n.terms {
+			if countsSuccesses(term) {
+				return true
+			}
+		}
+	case *selectNode:
+		return countsSuccesses(n.arg)
+	case *keepNode:
+		return countsSuccesses(n.arg)
+	case *clampNode:
+		return countsSuccesses(n.arg)
+	case *scaleNode:
+		return countsSuccesses(n.arg)
+	case *conditionalNode:
+		return countsSuccesses(n.arg) || countsSuccesses(n.bonus)
+	case *bestNode:
+		for _, arg := range n.args {
+			if countsSuccesses(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
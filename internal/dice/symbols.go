@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// blankFace names a face that carries no symbols, as on the dice of narrative systems such as Genesys.
+const blankFace = "blank"
+
+// SymbolPair is two symbols that cancel each other one for one, such as success and failure.
+type SymbolPair struct {
+	Symbol   string
+	Opposite string
+}
+
+// cancellations are the symbol pairs netted after each roll. None are set by default.
+var cancellations []SymbolPair
+
+// SetCancellations replaces the pairs of opposing symbols that cancel after a roll of symbol dice,
+// each written as "success/failure". Once any are set, the words of the faces rolled by fancy and
+// inline dice are counted as symbols, so a face named "success advantage" shows one of each, and
+// every pair is netted, leaving RollResult.Symbols. An empty list turns cancellation off.
+func SetCancellations(pairs []string) error {
+	replacement := make([]SymbolPair, 0, len(pairs))
+	for _, pair := range pairs {
+		symbol, opposite, found := strings.Cut(pair, "/")
+		if !found || symbol == "" || opposite == "" || strings.Contains(opposite, "/") {
+			return fmt.Errorf("invalid symbol pair '%s': expected two symbols such as success/failure", pair)
+		}
+		if symbol == opposite {
+			return fmt.Errorf("invalid symbol pair '%s': a symbol cannot cancel itself", pair)
+		}
+		replacement = append(replacement, SymbolPair{Symbol: symbol, Opposite: opposite})
+	}
+	cancellations = replacement
+	return nil
+}
+
+// SymbolCount is how many of a symbol are left after cancellation.
+type SymbolCount struct {
+	Symbol string
+	Count  int
+}
+
+// Symbols is the net result of a roll of symbol dice once opposing symbols have cancelled.
+type Symbols struct {
+	Net []SymbolCount // The symbols left, in the order they were first rolled
+}
+
+// String describes the symbols left, e.g. "2 success, 1 threat".
+func (s Symbols) String() string {
+	if len(s.Net) == 0 {
+		return "all symbols cancelled"
+	}
+	parts := make([]string, len(s.Net))
+	for i, count := range s.Net {
+		parts[i] = fmt.Sprintf("%d %s", count.Count, count.Symbol)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tallySymbols counts the symbols on the faces of the kept dice and cancels each configured pair.
+// It returns nil if no cancellations are set or none of the faces shows a paired symbol, so
+// ordinary fancy dice such as cards are not reported as symbols.
+func tallySymbols(dieRolls []DieRoll) *Symbols {
+	if len(cancellations) == 0 {
+		return nil
+	}
+
+	paired := make(map[string]bool, 2*len(cancellations))
+	for _, pair := range cancellations {
+		paired[pair.Symbol], paired[pair.Opposite] = true, true
+	}
+
+	var order []string
+	counts := make(map[string]int)
+	hasPaired := false
+	for _, roll := range dieRolls {
+		if roll.Dropped || roll.FancyValue == "" || roll.FancyValue == blankFace {
+			continue
+		}
+		for _, symbol := range strings.Fields(roll.FancyValue) {
+			if _, seen := counts[symbol]; !seen {
+				order = append(order, symbol)
+			}
+			counts[symbol]++
+			hasPaired = hasPaired || paired[symbol]
+		}
+	}
+	if !hasPaired {
+		return nil
+	}
+
+	for _, pair := range cancellations {
+		cancelled := min(counts[pair.Symbol], counts[pair.Opposite])
+		counts[pair.Symbol] -= cancelled
+		counts[pair.Opposite] -= cancelled
+	}
+
+	symbols := &Symbols{}
+	for _, symbol := range order {
+		if counts[symbol] > 0 {
+			symbols.Net = append(symbols.Net, SymbolCount{Symbol: symbol, Count: counts[symbol]})
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,68 @@
+package dice
+
+import "fmt"
+
+// maxUniqueRerolls is how many times unique(...) rerolls any one die before giving up and keeping
+// the duplicate. It stops pools with more dice than faces, such as "unique(7d6)", looping forever.
+const maxUniqueRerolls = 100
+
+// uniqueNode rolls each of its dice independently, then rerolls any die that shows the same
+// result as an earlier die in the pool, e.g. "unique(5d20)". Unlike exclusive dice, which are
+// dealt without replacement, the pool may have more dice than faces: a die that still matches
+// after maxUniqueRerolls rerolls is kept and marked as a duplicate.
+type uniqueNode struct {
+	arg *poolNode
+}
+
+func (n *uniqueNode) eval(result *RollResult) int {
+	start := len(result.DieRolls)
+	total := n.arg.eval(result)
+
+	seen := make(map[int]bool)
+	for i := start; i < len(result.DieRolls); i++ {
+		roll := &result.DieRolls[i]
+		for seen[roll.Result] && roll.Rerolls < maxUniqueRerolls {
+			var reroll RollResult
+			rollPool([]Die{roll.Die}, &reroll)
+			again := reroll.DieRolls[0]
+			total = addScore(result, total, again.Score-roll.Score)
+			roll.Result, roll.Score, roll.FancyValue = again.Result, again.Score, again.FancyValue
+			roll.Tens, roll.Units = again.Tens, again.Units
+			roll.Rerolls++
+		}
+		roll.Duplicate = seen[roll.Result]
+		seen[roll.Result] = true
+	}
+	return total
+}
+
+func (n *uniqueNode) dice() []Die {
+	return n.arg.dice()
+}
+
+func (n *uniqueNode) bounds() (int, int) {
+	return n.arg.bounds()
+}
+
+func (n *uniqueNode) canonical() string {
+	return "unique(" + n.arg.canonical() + ")"
+}
+
+// newUniqueNode builds a unique(...) node from the arguments of the call, which must be dice that
+// roll once each, so that every die can be rerolled in place.
+func newUniqueNode(args []node) (node, error) {
+	var pool []Die
+	for _, arg := range args {
+		dice, isPool := arg.(*poolNode)
+		if !isPool {
+			return nil, fmt.Errorf("unique() takes dice only, e.g. unique(5d20)")
+		}
+		for _, die := range dice.pool {
+			if die.Explode != ExplodeNone {
+				return nil, fmt.Errorf("unique() cannot reroll exploding dice")
+			}
+		}
+		pool = append(pool, dice.pool...)
+	}
+	return &uniqueNode{arg: &poolNode{pool: pool}}, nil
+}
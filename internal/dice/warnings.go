@@ -0,0 +1,55 @@
+package dice
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Warnings returns notes on parts of the dice set that are valid but probably not what was meant,
+// such as "d1 always shows 1" or "3D3 deals every face, so only the order varies". They are meant
+// to be shown alongside the roll, which is unaffected. A valid set with nothing odd has none.
+func (ds DiceSet) Warnings() []string {
+	var warnings []string
+	add := func(warning string) {
+		if !slices.Contains(warnings, warning) {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	for _, die := range ds.Dice {
+		switch {
+		case len(die.Faces) == 1:
+			add(fmt.Sprintf("%s always shows %s", die.notation(), die.Faces[0].Name))
+		case die.Sides < 0 && die.Sides >= -1000:
+			if values := fancyDiceValues[fmt.Sprintf("f%d", -die.Sides)]; len(values) == 1 {
+				add(fmt.Sprintf("%s always shows %s", die.notation(), values[0].Name))
+			}
+		case die.Sides > 0 && die.Sides <= 1000 && !die.Percentile && die.Min() == die.Max():
+			add(fmt.Sprintf("%s always shows %d", die.notation(), die.Min()))
+		}
+	}
+
+	for _, group := range ds.groupExclusiveDice() {
+		if !group.IsExclusive {
+			continue
+		}
+		faces := group.Dice[0].Sides - 1000
+		if group.IsFancy {
+			faces = len(fancyDiceValues[fmt.Sprintf("f%d", -group.Dice[0].Sides-1000)])
+		}
+		if len(group.Dice) == faces {
+			add(fmt.Sprintf("%d%s deals every face, so only the order varies", len(group.Dice), group.Dice[0].notation()))
+		}
+	}
+	return warnings
+}
+
+// ParseDiceNotationWithWarnings parses dice notation as ParseDiceNotation does, and also returns
+// the set's Warnings, so that a user interface can point out oddities without failing the roll.
+func ParseDiceNotationWithWarnings(notation string) (DiceSet, []string, error) {
+	diceSet, err := ParseDiceNotation(notation)
+	if err != nil {
+		return DiceSet{}, nil, err
+	}
+	return diceSet, diceSet.Warnings(), nil
+}
@@ -0,0 +1,70 @@
+// Package fair implements a commit-reveal scheme for verifiably fair dice rolls.
+//
+// Before rolling, the roller publishes a SHA-256 commitment to a secret seed, a random
+// nonce and the dice expression. The roll is then made with that seed, and revealing the
+// seed and nonce lets anyone check the commitment and reproduce the roll exactly.
+package fair
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nonceBytes is the length of the random nonce, which stops a commitment being brute-forced from the seed alone.
+const nonceBytes = 16
+
+// Secret is the private half of a commitment: the seed the dice are rolled with and a random nonce.
+type Secret struct {
+	Seed  uint64 // Seed for the dice random number generator
+	Nonce string // Random hexadecimal nonce
+}
+
+// NewSecret generates a secret from a cryptographically secure random source.
+func NewSecret() (Secret, error) {
+	buf := make([]byte, 8+nonceBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return Secret{}, fmt.Errorf("cannot generate random secret: %v", err)
+	}
+	return Secret{
+		Seed:  binary.BigEndian.Uint64(buf[:8]),
+		Nonce: hex.EncodeToString(buf[8:]),
+	}, nil
+}
+
+// ParseSecret parses a secret token of the form "<seed>-<nonce>" as produced by Token.
+func ParseSecret(token string) (Secret, error) {
+	seedText, nonce, found := strings.Cut(strings.TrimSpace(token), "-")
+	if !found {
+		return Secret{}, fmt.Errorf("secret must have the form '<seed>-<nonce>'")
+	}
+
+	seed, err := strconv.ParseUint(seedText, 10, 64)
+	if err != nil {
+		return Secret{}, fmt.Errorf("invalid seed in secret: %s", seedText)
+	}
+
+	decoded, err := hex.DecodeString(nonce)
+	if err != nil || len(decoded) != nonceBytes {
+		return Secret{}, fmt.Errorf("invalid nonce in secret: %s", nonce)
+	}
+
+	return Secret{Seed: seed, Nonce: strings.ToLower(nonce)}, nil
+}
+
+// Token returns the secret in the form accepted by ParseSecret.
+func (s Secret) Token() string {
+	return fmt.Sprintf("%d-%s", s.Seed, s.Nonce)
+}
+
+// Commitment returns the hexadecimal SHA-256 commitment to rolling expression with this secret.
+// Whitespace in the expression is normalized so that "3d6 + 2" and "3d6 +  2" commit alike.
+func (s Secret) Commitment(expression string) string {
+	normalized := strings.Join(strings.Fields(expression), " ")
+	sum := sha256.Sum256([]byte(s.Token() + ":" + normalized))
+	return hex.EncodeToString(sum[:])
+}
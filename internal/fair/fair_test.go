@@ -0,0 +1,48 @@
+package fair
+
+import (
+	"testing"
+)
+
+func TestSecretRoundTrip(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret() unexpected error: %v", err)
+	}
+
+	parsed, err := ParseSecret(secret.Token())
+	if err != nil {
+		t.Fatalf("ParseSecret(%q) unexpected error: %v", secret.Token(), err)
+	}
+	if parsed != secret {
+		t.Errorf("ParseSecret() = %+v, expected %+v", parsed, secret)
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	secret := Secret{Seed: 42, Nonce: "000102030405060708090a0b0c0d0e0f"}
+
+	commitment := secret.Commitment("3d6 + 2")
+	if len(commitment) != 64 {
+		t.Errorf("Expected a 64 character SHA-256 commitment, got %q", commitment)
+	}
+	if secret.Commitment("3d6  +  2") != commitment {
+		t.Error("Expected whitespace differences not to change the commitment")
+	}
+	if secret.Commitment("3d6+3") == commitment {
+		t.Error("Expected a different expression to change the commitment")
+	}
+
+	other := Secret{Seed: 43, Nonce: secret.Nonce}
+	if other.Commitment("3d6 + 2") == commitment {
+		t.Error("Expected a different seed to change the commitment")
+	}
+}
+
+func TestParseSecretErrors(t *testing.T) {
+	for _, token := range []string{"", "42", "x-00", "42-zz", "42-0001"} {
+		if _, err := ParseSecret(token); err == nil {
+			t.Errorf("ParseSecret(%q) expected error, got nil", token)
+		}
+	}
+}
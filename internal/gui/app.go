@@ -3,10 +3,16 @@ package gui
 
 import (
 	"fmt"
-	"sort"
+	"image/color"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
@@ -15,49 +21,165 @@ import (
 	"github.com/sfkleach/roll/internal/info"
 )
 
-// hasReplacementCharacters checks if a string contains actual replacement characters
-// that indicate the font doesn't support the Unicode characters.
-func hasReplacementCharacters(text string) bool {
-	for _, r := range text {
-		if r == '\uFFFD' || // Unicode replacement character �
-			r == '\u25A1' || // White square □
-			r == '\u2610' || // Ballot box ☐
-			r == '\u25AF' || // White vertical rectangle ▯
-			r == '\u25AD' || // White rectangle ▭
-			r == '?' || // Question mark fallback
-			r == '\u003F' || // Another question mark representation
-			(r >= '\u2680' && r <= '\u2685') { // Dice face range - often show as replacement
-			return true
-		}
-	}
-	return false
+// hasDiceFaceGlyphs checks if a string contains the dice face characters ⚀ to ⚅ (U+2680 to U+2685).
+// Some fonts draw them too small to read, so they can be shown as numbers on request.
+func hasDiceFaceGlyphs(text string) bool {
+	return strings.ContainsFunc(text, func(r rune) bool {
+		return r >= '\u2680' && r <= '\u2685'
+	})
 }
 
+// Preference keys used to restore the window between sessions.
+const (
+	lastExpressionKey = "lastExpression"
+	windowWidthKey    = "windowWidth"
+	windowHeightKey   = "windowHeight"
+	fontScaleKey      = "fontScale"
+	animateRollsKey   = "animateRolls"
+	highlightKey      = "highlightNaturals"
+	groupDigitsKey    = "groupDigits"
+	colourFacesKey    = "colourFaces"
+	numericFacesKey   = "numericFaces"
+)
+
+// The rolling animation shows this many random totals, one per delay, before the real one.
+var (
+	animationFrames = 8
+	animationDelay  = 60 * time.Millisecond
+)
+
+// dcEntryWidth is the width of the DC field, enough for a two-digit difficulty class.
+const dcEntryWidth = 64
+
 // App represents the main application window and its components.
 type App struct {
 	window      fyne.Window
 	diceEntry   *widget.Entry
+	entryHint   *widget.Label
+	dcEntry     *widget.Entry
 	rollButton  *widget.Button
 	infoButton  *widget.Button
+	zoomIn      *widget.Button
+	zoomOut     *widget.Button
+	animate     *widget.Check
+	highlight   *widget.Check
+	groupDigits *widget.Check
+	colourFaces *widget.Check
+	numericDice *widget.Check
 	resultsCard *widget.Card
 	totalCard   *widget.Card
+	fontScale   float32
+	rolling     atomic.Bool // True while an animated roll is showing; set by the animation's goroutine, so atomic
+
+	// roll rolls a parsed dice set. It is DiceSet.Roll, but tests can replace it to get known results.
+	roll func(dice.DiceSet) dice.RollResult
 }
 
 // NewApp creates a new GUI application instance.
 func NewApp(window fyne.Window) *App {
 	app := &App{
-		window: window,
+		window:    window,
+		fontScale: 1,
+		roll:      dice.DiceSet.Roll,
 	}
 	app.setupUI()
+	app.restoreState()
 	return app
 }
 
+// preferences returns the running application's preferences, or nil if there is no application.
+func (a *App) preferences() fyne.Preferences {
+	if fyne.CurrentApp() == nil {
+		return nil
+	}
+	return fyne.CurrentApp().Preferences()
+}
+
+// Prefill puts an expression in the dice entry, replacing any restored from the last session, and
+// rolls it straight away if roll is set. It lets the command line open the GUI ready to roll.
+func (a *App) Prefill(expression string, roll bool) {
+	a.diceEntry.SetText(expression)
+	if roll {
+		a.onRollButtonClicked()
+	}
+}
+
+// restoreState restores the last-used expression and window size, and saves them again when the window closes.
+func (a *App) restoreState() {
+	prefs := a.preferences()
+	if prefs == nil {
+		return
+	}
+
+	a.diceEntry.SetText(prefs.String(lastExpressionKey))
+	a.setFontScale(float32(prefs.FloatWithFallback(fontScaleKey, 1)))
+	a.animate.SetChecked(prefs.Bool(animateRollsKey))
+	a.highlight.SetChecked(prefs.Bool(highlightKey))
+	a.groupDigits.SetChecked(prefs.Bool(groupDigitsKey))
+	a.colourFaces.SetChecked(prefs.BoolWithFallback(colourFacesKey, true))
+	a.numericDice.SetChecked(prefs.Bool(numericFacesKey))
+
+	width, height := prefs.Float(windowWidthKey), prefs.Float(windowHeightKey)
+	if width > 0 && height > 0 {
+		a.window.Resize(fyne.NewSize(float32(width), float32(height)))
+	}
+
+	a.window.SetCloseIntercept(func() {
+		a.saveState()
+		a.window.Close()
+	})
+}
+
+// saveState stores the current expression and window size for the next launch.
+func (a *App) saveState() {
+	prefs := a.preferences()
+	if prefs == nil {
+		return
+	}
+
+	prefs.SetString(lastExpressionKey, strings.TrimSpace(a.diceEntry.Text))
+	prefs.SetBool(animateRollsKey, a.animate.Checked)
+	prefs.SetBool(highlightKey, a.highlight.Checked)
+	prefs.SetBool(groupDigitsKey, a.groupDigits.Checked)
+	prefs.SetBool(colourFacesKey, a.colourFaces.Checked)
+	prefs.SetBool(numericFacesKey, a.numericDice.Checked)
+
+	size := a.window.Canvas().Size()
+	if size.Width > 0 && size.Height > 0 {
+		prefs.SetFloat(windowWidthKey, float64(size.Width))
+		prefs.SetFloat(windowHeightKey, float64(size.Height))
+	}
+}
+
+// setFontScale applies a text scale to the whole application and stores it for the next launch.
+func (a *App) setFontScale(scale float32) {
+	a.fontScale = clampFontScale(scale)
+	if fyne.CurrentApp() == nil {
+		return
+	}
+	fyne.CurrentApp().Settings().SetTheme(newScaledTheme(a.fontScale))
+	fyne.CurrentApp().Preferences().SetFloat(fontScaleKey, float64(a.fontScale))
+}
+
 // setupUI initializes the user interface components.
 func (a *App) setupUI() {
 	// Create input field for dice notation.
 	a.diceEntry = widget.NewEntry()
 	a.diceEntry.SetPlaceHolder("e.g. 2d6")
-	// No default text - starts empty so placeholder is visible.
+	// No default text unless a previous session saved one, so the placeholder is visible.
+	// Check the notation as it is typed, so that the entry is marked invalid before rolling.
+	a.diceEntry.Validator = validateInput
+
+	// Create a hint below the entry that says what is wrong with invalid notation.
+	a.entryHint = widget.NewLabel("")
+	a.entryHint.Importance = widget.DangerImportance
+	a.entryHint.TextStyle = fyne.TextStyle{Italic: true}
+	a.entryHint.Wrapping = fyne.TextWrapWord
+	a.entryHint.Hide()
+
+	// Create an optional difficulty class to check the total against, for skill checks.
+	a.dcEntry = widget.NewEntry()
+	a.dcEntry.SetPlaceHolder("DC")
 
 	// Create roll button.
 	a.rollButton = widget.NewButton("Roll Dice", a.onRollButtonClicked)
@@ -66,6 +188,30 @@ func (a *App) setupUI() {
 	// Create info button with theme icon.
 	a.infoButton = widget.NewButtonWithIcon("", theme.InfoIcon(), a.onInfoButtonClicked)
 
+	// Create buttons to enlarge or shrink the text for readability.
+	a.zoomOut = widget.NewButtonWithIcon("", theme.ZoomOutIcon(), func() {
+		a.setFontScale(a.fontScale - fontScaleStep)
+	})
+	a.zoomIn = widget.NewButtonWithIcon("", theme.ZoomInIcon(), func() {
+		a.setFontScale(a.fontScale + fontScaleStep)
+	})
+
+	// Create a toggle for the rolling animation; instant results are the default.
+	a.animate = widget.NewCheck("Animate rolls", nil)
+
+	// Create a toggle for flashing the total on a natural 20 or a natural 1.
+	a.highlight = widget.NewCheck("Highlight natural 20s and 1s", nil)
+
+	// Create a toggle for grouping the digits of large totals, e.g. 30,000.
+	a.groupDigits = widget.NewCheck("Group digits", nil)
+
+	// Create a toggle for showing red suits, such as hearts and diamonds, in red.
+	a.colourFaces = widget.NewCheck("Colour faces", nil)
+	a.colourFaces.SetChecked(true)
+
+	// Create a toggle for showing dice faces such as ⚅ as numbers, for fonts that lack them.
+	a.numericDice = widget.NewCheck("Dice faces as numbers", nil)
+
 	// Create results card (will be populated when rolling).
 	a.resultsCard = widget.NewCard("", "", container.NewVBox(
 		widget.NewLabel("Click 'Roll Dice' to get started!"),
@@ -80,13 +226,21 @@ func (a *App) setupUI() {
 	a.diceEntry.OnSubmitted = func(string) {
 		a.onRollButtonClicked()
 	}
+	a.dcEntry.OnSubmitted = a.diceEntry.OnSubmitted
+	a.diceEntry.OnChanged = func(string) {
+		a.updateRollState()
+	}
 
 	// Create layout.
-	buttonsContainer := container.NewHBox(a.infoButton, a.rollButton)
+	dcContainer := container.NewGridWrap(fyne.NewSize(dcEntryWidth, a.dcEntry.MinSize().Height), a.dcEntry)
+	buttonsContainer := container.NewHBox(dcContainer, a.zoomOut, a.zoomIn, a.infoButton, a.rollButton)
 	inputContainer := container.NewBorder(nil, nil, nil, buttonsContainer, a.diceEntry)
 
 	content := container.NewVBox(
 		inputContainer,
+		a.entryHint,
+		a.createDicePicker(),
+		container.NewHBox(a.animate, a.highlight, a.groupDigits, a.colourFaces, a.numericDice),
 		widget.NewSeparator(),
 		a.resultsCard,
 		a.totalCard,
@@ -95,6 +249,51 @@ func (a *App) setupUI() {
 	a.window.SetContent(content)
 }
 
+// commonDice are the regular dice offered by the dice picker.
+var commonDice = []string{"d4", "d6", "d8", "d10", "d12", "d20", "d100"}
+
+// createDicePicker creates a grid of buttons, one per common die and per fancy die, that add
+// the die to the expression when clicked.
+func (a *App) createDicePicker() fyne.CanvasObject {
+	var regular, fancy []fyne.CanvasObject
+	for _, die := range commonDice {
+		regular = append(regular, a.newDieButton(die))
+	}
+	for _, fancyDie := range dice.ListFancyDice() {
+		fancy = append(fancy, a.newDieButton(fancyDie.Type))
+	}
+
+	return container.NewVBox(
+		container.NewGridWithColumns(len(commonDice), regular...),
+		container.NewGridWithColumns(len(commonDice), fancy...),
+	)
+}
+
+// newDieButton creates a dice picker button that adds the die to the expression.
+func (a *App) newDieButton(die string) *widget.Button {
+	return widget.NewButton(die, func() {
+		a.diceEntry.SetText(addDieToExpression(a.diceEntry.Text, die))
+	})
+}
+
+// addDieToExpression adds one die to the end of an expression. If the expression already ends
+// with a group of the same die, its count is incremented instead, so clicking d6 twice gives "2d6".
+func addDieToExpression(expression, die string) string {
+	fields := strings.Fields(expression)
+	if len(fields) > 0 {
+		last := regexp.MustCompile(`^(\d*)` + regexp.QuoteMeta(die) + `$`).FindStringSubmatch(fields[len(fields)-1])
+		if last != nil {
+			count := 1
+			if last[1] != "" {
+				count, _ = strconv.Atoi(last[1])
+			}
+			fields[len(fields)-1] = fmt.Sprintf("%d%s", count+1, die)
+			return strings.Join(fields, " ")
+		}
+	}
+	return strings.Join(append(fields, die), " ")
+}
+
 // parseFlagsFromInput extracts sorting flags from the input text and returns cleaned dice notation and sorting preferences.
 func parseFlagsFromInput(input string) (diceNotation string, ascending bool, descending bool, err error) {
 	parts := strings.Fields(input)
@@ -121,8 +320,45 @@ func parseFlagsFromInput(input string) (diceNotation string, ascending bool, des
 	return diceNotation, ascending, descending, nil
 }
 
-// onRollButtonClicked handles the roll button click event.
+// validateInput checks the text of the dice entry, including any flags, without rolling it.
+// An empty entry is not marked invalid, so the placeholder shows as usual.
+func validateInput(input string) error {
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+	notation, _, _, err := parseFlagsFromInput(input)
+	if err != nil {
+		return err
+	}
+	_, err = dice.Validate(notation)
+	return err
+}
+
+// updateRollState checks the dice entry as it changes, disabling the roll button and showing a
+// hint while the text cannot be rolled. An empty entry is left alone, and the button stays
+// disabled while an animated roll is showing.
+func (a *App) updateRollState() {
+	err := validateInput(a.diceEntry.Text)
+	if err != nil {
+		a.entryHint.SetText(err.Error())
+		a.entryHint.Show()
+	} else {
+		a.entryHint.Hide()
+	}
+
+	if err != nil || a.rolling.Load() {
+		a.rollButton.Disable()
+	} else {
+		a.rollButton.Enable()
+	}
+}
+
+// onRollButtonClicked handles the roll button click event. Enter in the entries calls it too,
+// so it ignores the request while an animated roll is showing, as the disabled button does.
 func (a *App) onRollButtonClicked() {
+	if a.rolling.Load() {
+		return
+	}
 	input := strings.TrimSpace(a.diceEntry.Text)
 
 	if input == "" {
@@ -142,48 +378,66 @@ func (a *App) onRollButtonClicked() {
 		return
 	}
 
-	// Parse the dice notation.
-	diceSet, err := dice.ParseDiceNotation(notation)
+	if _, _, err := parseDC(a.dcEntry.Text); err != nil {
+		a.showError(fmt.Sprintf("DC error: %v", err))
+		return
+	}
+
+	// Parse the dice notation, noting anything odd above the results.
+	diceSet, warnings, err := dice.ParseDiceNotationWithWarnings(notation)
 	if err != nil {
 		a.showError(fmt.Sprintf("Invalid dice notation: %v", err))
 		return
 	}
+	a.resultsCard.SetSubTitle(strings.Join(warnings, "; "))
 
 	// Roll the dice.
-	result := diceSet.Roll()
+	result := a.roll(diceSet)
+	if result.Err != nil {
+		a.showError(fmt.Sprintf("Cannot roll the dice: %v", result.Err))
+		return
+	}
+	if result.Overflow {
+		a.showError("The total is too large to compute")
+		return
+	}
 
-	// Sort if requested.
+	// Sort if requested. Sorting only reorders the dice, so the total is unaffected.
 	if ascending || descending {
-		sortedRolls := make([]dice.DieRoll, len(result.DieRolls))
-		copy(sortedRolls, result.DieRolls)
-
-		if ascending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result < sortedRolls[j].Result
-			})
-		} else if descending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result > sortedRolls[j].Result
-			})
-		}
+		result = result.Sorted(descending)
+	}
+
+	// Update the display, letting the total settle first if animation is on.
+	if a.animate.Checked {
+		low, high := diceSet.Range()
+		a.animateResults(result, low, high)
+		return
+	}
+	a.updateResults(result)
+}
 
-		// Create a new result with sorted rolls.
-		sortedResult := dice.RollResult{
-			DieRolls:        sortedRolls,
-			IndividualRolls: result.IndividualRolls, // Keep original for compatibility.
-			Total:           result.Total,
+// animateResults shows a few random totals between low and high before the real result,
+// for a moment of suspense. The roll button is disabled until the result is shown.
+func (a *App) animateResults(result dice.RollResult, low, high int) {
+	a.rolling.Store(true)
+	a.rollButton.Disable()
+	a.resultsCard.SetContent(widget.NewLabel("Rolling..."))
+
+	go func() {
+		for i := 0; i < animationFrames; i++ {
+			a.setTotal(fmt.Sprintf("Total: %d", low+rand.IntN(high-low+1)))
+			time.Sleep(animationDelay)
 		}
-		a.updateResults(sortedResult)
-	} else {
-		// Update the display with original order.
 		a.updateResults(result)
-	}
+		a.rolling.Store(false)
+		a.updateRollState()
+	}()
 }
 
 // updateResults updates the result display with separate areas for dice rolls and total.
 func (a *App) updateResults(result dice.RollResult) {
-	// Create the dice results grid (pre-allocate with capacity for die rolls).
-	gridContent := make([]fyne.CanvasObject, 0, len(result.DieRolls)*2)
+	// Create the dice results grid (pre-allocate with capacity for die rolls and the modifier).
+	gridContent := make([]fyne.CanvasObject, 0, len(result.DieRolls)*2+2)
 
 	// Add each individual die roll as a row in the grid.
 	for _, dieRoll := range result.DieRolls {
@@ -198,35 +452,201 @@ func (a *App) updateResults(result dice.RollResult) {
 
 		// Right column: roll result (fancy value or numeric).
 		if dieRoll.FancyValue != "" {
-			// For fancy dice, check if Unicode characters render as replacement characters
+			// For fancy dice, check that the fonts can draw every character of the face.
 			displayText := dieRoll.FancyValue
-			if hasReplacementCharacters(dieRoll.FancyValue) {
-				// Fall back to showing the score if Unicode shows replacement characters
+			if !canRender(dieRoll.FancyValue) || (a.numericDice.Checked && hasDiceFaceGlyphs(dieRoll.FancyValue)) {
+				// Fall back to showing the number of the face if it would show replacement characters, or if asked to
 				displayText = fmt.Sprintf("%d", dieRoll.Result)
 			}
 
 			rollValue := widget.NewLabel(displayText)
 			rollValue.Alignment = fyne.TextAlignTrailing
 			// No special TextStyle to allow system font with natural colors
+			if a.colourFaces.Checked {
+				rollValue.Importance = faceImportance(displayText)
+			}
+			markDropped(dieRoll, diceType, rollValue)
 			gridContent = append(gridContent, diceType, rollValue)
 		} else {
-			// Regular numeric value
-			rollValue := widget.NewLabel(fmt.Sprintf("%d", dieRoll.Result))
+			// Regular numeric value, with the chain of rolls summed into a compounding die
+			rollValue := widget.NewLabel(fmt.Sprintf("%d", dieRoll.Result) + formatChain(dieRoll.Rolls))
 			rollValue.Alignment = fyne.TextAlignTrailing
+			markDropped(dieRoll, diceType, rollValue)
 			gridContent = append(gridContent, diceType, rollValue)
 		}
 	}
 
+	// Show the flat modifier as its own row, so the rows add up to the total.
+	if result.Modifier != 0 {
+		modifierType := widget.NewLabel("modifier")
+		modifierType.Alignment = fyne.TextAlignLeading
+		modifierValue := widget.NewLabel(fmt.Sprintf("%+d", result.Modifier))
+		modifierValue.Alignment = fyne.TextAlignTrailing
+		gridContent = append(gridContent, modifierType, modifierValue)
+	}
+
 	// Create a 2-column grid for dice results.
 	diceGrid := container.NewGridWithColumns(2, gridContent...)
 
 	// Update the results card content.
 	a.resultsCard.SetContent(diceGrid)
 
-	// Create total display.
-	totalLabel := widget.NewLabel(fmt.Sprintf("Total: %d", result.Total))
-	totalLabel.Alignment = fyne.TextAlignCenter
-	totalLabel.TextStyle = fyne.TextStyle{Bold: true}
+	total := strconv.Itoa(result.Total)
+	if a.groupDigits.Checked {
+		total = dice.GroupDigits(result.Total)
+	}
+	if result.RollUnder != nil {
+		a.setTotal(result.RollUnder.String())
+	} else {
+		total = "Total: " + total
+		if result.Scaled != nil {
+			total += fmt.Sprintf(" (%s)", result.Scaled)
+		}
+		if result.Clamped != nil {
+			total += fmt.Sprintf(" (%s)", result.Clamped)
+		}
+		a.setTotal(total)
+	}
+	subtitle := result.Label
+	if result.Bonus != nil {
+		// Say whether a conditional bonus was rolled, after any label.
+		if subtitle != "" {
+			subtitle += ": "
+		}
+		subtitle += result.Bonus.String()
+	}
+	if result.KeptIf != nil {
+		// Say how many dice a conditional keep kept.
+		if subtitle != "" {
+			subtitle += ": "
+		}
+		subtitle += result.KeptIf.String()
+	}
+	if result.Symbols != nil {
+		// Show the symbols left once opposing ones cancel.
+		if subtitle != "" {
+			subtitle += ": "
+		}
+		subtitle += result.Symbols.String()
+	}
+	a.totalCard.SetSubTitle(subtitle)
+	if dc, hasDC, _ := parseDC(a.dcEntry.Text); hasDC && result.RollUnder == nil {
+		a.showCheck(result.Total, dc)
+	}
+	if a.highlight.Checked {
+		a.highlightNaturals(result)
+	}
+}
+
+// parseDC parses the difficulty class in the DC field, reporting false if it is blank.
+func parseDC(text string) (int, bool, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, false, nil
+	}
+	dc, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, false, fmt.Errorf("the DC must be a whole number, e.g. 15")
+	}
+	return dc, true, nil
+}
+
+// formatCheck says whether a total passes a check against a difficulty class and by how much.
+// As in D&D, meeting the DC exactly is a success.
+func formatCheck(total, dc int) string {
+	if total >= dc {
+		return fmt.Sprintf("Success by %d (DC %d)", total-dc, dc)
+	}
+	return fmt.Sprintf("Failure by %d (DC %d)", dc-total, dc)
+}
+
+// showCheck adds the outcome of a check against the DC below the total, green for a success and
+// red for a failure.
+func (a *App) showCheck(total, dc int) {
+	check := widget.NewLabel(formatCheck(total, dc))
+	check.Alignment = fyne.TextAlignCenter
+	check.TextStyle = fyne.TextStyle{Bold: true}
+	check.Importance = widget.DangerImportance
+	if total >= dc {
+		check.Importance = widget.SuccessImportance
+	}
+	a.totalCard.SetContent(container.NewVBox(a.totalCard.Content, check))
+}
+
+// formatChain formats the rolls summed into a compounding die such as "3d6!!", e.g. " (6+6+2)",
+// or returns an empty string if the die rolled only once.
+func formatChain(rolls []int) string {
+	if len(rolls) < 2 {
+		return ""
+	}
+	chain := make([]string, len(rolls))
+	for i, value := range rolls {
+		chain[i] = strconv.Itoa(value)
+	}
+	return " (" + strings.Join(chain, "+") + ")"
+}
+
+// faceImportance returns the importance that colours a fancy face: red for the red suits of
+// playing cards, such as "A♥" or "♦", and the usual text colour, black in the light theme, otherwise.
+// Fyne draws a label in a single colour, so the whole face takes the colour of its suit.
+func faceImportance(face string) widget.Importance {
+	if strings.ContainsAny(face, "♥♦♡♢") {
+		return widget.DangerImportance
+	}
+	return widget.MediumImportance
+}
+
+// markDropped greys out the row of a die that was rolled but does not count towards the total,
+// such as one dropped by "4d6kh3". Fyne labels cannot be struck through, so the value says so instead.
+func markDropped(dieRoll dice.DieRoll, diceType, rollValue *widget.Label) {
+	if !dieRoll.Dropped {
+		return
+	}
+	diceType.Importance = widget.LowImportance
+	rollValue.Importance = widget.LowImportance
+	rollValue.SetText(rollValue.Text + " (dropped)")
+}
+
+// highlightNaturals flashes the total card and labels it when a kept d20 shows a natural 20 or a natural 1.
+// It is purely visual, so it works the same on every platform.
+func (a *App) highlightNaturals(result dice.RollResult) {
+	twenties, ones := result.NaturalD20s()
+
+	var flash color.Color
+	switch {
+	case twenties > 0:
+		a.totalCard.SetSubTitle("Natural 20!")
+		flash = theme.SuccessColor()
+	case ones > 0:
+		a.totalCard.SetSubTitle("Natural 1!")
+		flash = theme.ErrorColor()
+	default:
+		return
+	}
+
+	// Fade a coloured background behind the total out to transparent.
+	background := canvas.NewRectangle(flash)
+	a.totalCard.SetContent(container.NewStack(background, a.totalCard.Content))
+
+	start := color.NRGBAModel.Convert(flash).(color.NRGBA)
+	end := start
+	end.A = 0
+	canvas.NewColorRGBAAnimation(start, end, 800*time.Millisecond, func(c color.Color) {
+		background.FillColor = c
+		background.Refresh()
+	}).Start()
+}
+
+// setTotal shows the text in the total card at heading size, which follows the font scale.
+func (a *App) setTotal(text string) {
+	totalLabel := widget.NewRichText(&widget.TextSegment{
+		Text: text,
+		Style: widget.RichTextStyle{
+			Alignment: fyne.TextAlignCenter,
+			SizeName:  theme.SizeNameHeadingText,
+			TextStyle: fyne.TextStyle{Bold: true},
+		},
+	})
 
 	// Update the total card content.
 	a.totalCard.SetContent(totalLabel)
@@ -237,6 +657,7 @@ func (a *App) showError(message string) {
 	errorLabel := widget.NewLabel(message)
 	errorLabel.Wrapping = fyne.TextWrapWord
 	a.resultsCard.SetContent(errorLabel)
+	a.resultsCard.SetSubTitle("")
 
 	// Clear the total area.
 	a.totalCard.SetContent(widget.NewLabel(""))
@@ -1,7 +1,18 @@
 package gui
 
 import (
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/sfkleach/roll/internal/dice"
 )
 
 func TestParseFlagsFromInput(t *testing.T) {
@@ -21,6 +32,7 @@ func TestParseFlagsFromInput(t *testing.T) {
 		{"3d6 --descending", "3d6", false, true, false},
 		{"-a 2d10 d6", "2d10 d6", true, false, false},
 		{"--descending 2d20 3d4", "2d20 3d4", false, true, false},
+		{"d20 f4 3D6 -a", "d20 f4 3D6", true, false, false},
 		{"-a -d 3d6", "", false, false, true}, // Error: both flags
 		{"--ascending --descending 3d6", "", false, false, true}, // Error: both flags
 		{"-a --descending 3d6", "", false, false, true}, // Error: both flags
@@ -55,3 +67,489 @@ func TestParseFlagsFromInput(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateInput(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"", true},
+		{"2d6+3", true},
+		{"-a 4d6 #stats", true},
+		{"2d", false},
+		{"3d6 +", false},
+		{"-a -d 3d6", false},
+		{"-a", false},
+	}
+	for _, tt := range tests {
+		app.diceEntry.SetText(tt.input)
+		if err := app.diceEntry.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Input %q: validation error %v, want valid %v", tt.input, err, tt.valid)
+		}
+	}
+}
+
+func TestLiveValidation(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	tests := []struct {
+		input string
+		hint  string
+	}{
+		{"2d", "invalid dice notation"},
+		{"2d6", ""},
+		{"-a -d 2d6", "cannot specify both"},
+		{"", ""},
+		{"-d 4d6 #stats", ""},
+	}
+	for _, tt := range tests {
+		app.diceEntry.SetText(tt.input)
+
+		invalid := tt.hint != ""
+		if app.rollButton.Disabled() != invalid {
+			t.Errorf("Input %q: roll button disabled %v, want %v", tt.input, app.rollButton.Disabled(), invalid)
+		}
+		if app.entryHint.Visible() != invalid || !strings.Contains(strings.ToLower(app.entryHint.Text), tt.hint) {
+			t.Errorf("Input %q: hint %q (visible %v), want one containing %q", tt.input, app.entryHint.Text, app.entryHint.Visible(), tt.hint)
+		}
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	testApp.Preferences().SetString(lastExpressionKey, "2d6+3")
+	testApp.Preferences().SetFloat(windowWidthKey, 500)
+	testApp.Preferences().SetFloat(windowHeightKey, 400)
+
+	window := testApp.NewWindow("Roll")
+	app := NewApp(window)
+
+	if app.diceEntry.Text != "2d6+3" {
+		t.Errorf("Expected restored expression '2d6+3', got '%s'", app.diceEntry.Text)
+	}
+	if size := window.Canvas().Size(); size.Width != 500 || size.Height != 400 {
+		t.Errorf("Expected restored size 500x400, got %vx%v", size.Width, size.Height)
+	}
+
+	app.diceEntry.SetText("4d6")
+	window.Resize(fyne.NewSize(300, 200))
+	app.saveState()
+
+	if got := testApp.Preferences().String(lastExpressionKey); got != "4d6" {
+		t.Errorf("Expected saved expression '4d6', got '%s'", got)
+	}
+	if got := testApp.Preferences().Float(windowWidthKey); got != 300 {
+		t.Errorf("Expected saved width 300, got %v", got)
+	}
+}
+
+func TestFontScale(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	testApp.Preferences().SetFloat(fontScaleKey, 1.5)
+	app := NewApp(testApp.NewWindow("Roll"))
+
+	if app.fontScale != 1.5 {
+		t.Errorf("Expected restored font scale 1.5, got %v", app.fontScale)
+	}
+
+	scaled := newScaledTheme(2)
+	base := theme.DefaultTheme()
+	if got, want := scaled.Size(theme.SizeNameText), base.Size(theme.SizeNameText)*2; got != want {
+		t.Errorf("Expected scaled text size %v, got %v", want, got)
+	}
+	if got, want := scaled.Size(theme.SizeNamePadding), base.Size(theme.SizeNamePadding); got != want {
+		t.Errorf("Expected unscaled padding %v, got %v", want, got)
+	}
+
+	// Zooming is clamped to the supported range and persisted.
+	for i := 0; i < 10; i++ {
+		app.zoomIn.OnTapped()
+	}
+	if app.fontScale != maxFontScale {
+		t.Errorf("Expected font scale clamped to %v, got %v", maxFontScale, app.fontScale)
+	}
+	if got := testApp.Preferences().Float(fontScaleKey); got != maxFontScale {
+		t.Errorf("Expected saved font scale %v, got %v", maxFontScale, got)
+	}
+}
+
+func TestExclusiveOverflowError(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7D6", "cannot roll 7 exclusive dice with only 6 sides"},
+		{"-a 3F2", "cannot roll 3 exclusive f2 dice with only 2 values"},
+	}
+
+	for _, tc := range tests {
+		app.diceEntry.SetText(tc.input)
+		app.onRollButtonClicked()
+
+		label, isLabel := app.resultsCard.Content.(*widget.Label)
+		if !isLabel {
+			t.Errorf("Input '%s': expected an error label, got %T", tc.input, app.resultsCard.Content)
+			continue
+		}
+		if !strings.Contains(label.Text, tc.expected) {
+			t.Errorf("Input '%s': expected error containing '%s', got '%s'", tc.input, tc.expected, label.Text)
+		}
+	}
+}
+
+func TestSpacedInput(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	app.diceEntry.SetText("-a 3 d 1 + 2")
+	app.onRollButtonClicked()
+
+	total, isRichText := app.totalCard.Content.(*widget.RichText)
+	if !isRichText || total.String() != "Total: 5" {
+		t.Errorf("Expected 'Total: 5' for spaced input, got %v", app.totalCard.Content)
+	}
+}
+
+func TestPrefill(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	testApp.Preferences().SetString(lastExpressionKey, "2d6+3")
+	app := NewApp(testApp.NewWindow("Roll"))
+
+	app.Prefill("3d1+1", false)
+	if app.diceEntry.Text != "3d1+1" {
+		t.Errorf("Expected the prefilled expression '3d1+1', got '%s'", app.diceEntry.Text)
+	}
+	if _, rolled := app.totalCard.Content.(*widget.RichText); rolled {
+		t.Errorf("Expected no roll without auto-roll, got %v", app.totalCard.Content)
+	}
+
+	app.Prefill("3d1+1", true)
+	total, isRichText := app.totalCard.Content.(*widget.RichText)
+	if !isRichText || total.String() != "Total: 4" {
+		t.Errorf("Expected 'Total: 4' after auto-rolling, got %v", app.totalCard.Content)
+	}
+}
+
+func TestAddDieToExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		die        string
+		expected   string
+	}{
+		{"", "d6", "d6"},
+		{"d6", "d6", "2d6"},
+		{"2d6", "d6", "3d6"},
+		{"2d6", "d8", "2d6 d8"},
+		{"d10", "d100", "d10 d100"},
+		{"d100", "d10", "d100 d10"},
+		{"-a 3f4", "f4", "-a 4f4"},
+		{"1d20+5", "d20", "1d20+5 d20"},
+	}
+
+	for _, tc := range tests {
+		if got := addDieToExpression(tc.expression, tc.die); got != tc.expected {
+			t.Errorf("addDieToExpression(%q, %q) = %q, expected %q", tc.expression, tc.die, got, tc.expected)
+		}
+	}
+}
+
+func TestDicePicker(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	app.diceEntry.SetText("")
+
+	button := app.newDieButton("d6")
+	button.OnTapped()
+	button.OnTapped()
+	app.newDieButton("f4").OnTapped()
+
+	if app.diceEntry.Text != "2d6 f4" {
+		t.Errorf("Expected '2d6 f4' after clicking d6 twice and f4, got '%s'", app.diceEntry.Text)
+	}
+}
+
+func TestAnimatedRoll(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	savedDelay := animationDelay
+	animationDelay = time.Millisecond
+	defer func() { animationDelay = savedDelay }()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	var rolls atomic.Int32
+	app.roll = func(diceSet dice.DiceSet) dice.RollResult {
+		rolls.Add(1)
+		return diceSet.Roll()
+	}
+	app.animate.SetChecked(true)
+	app.diceEntry.SetText("3d1+2")
+	app.onRollButtonClicked()
+
+	if !app.rollButton.Disabled() {
+		t.Error("Expected the roll button to be disabled while the roll animates")
+	}
+	// Enter in the entries bypasses the disabled button, so it must not start a second roll.
+	app.diceEntry.OnSubmitted(app.diceEntry.Text)
+	app.dcEntry.OnSubmitted(app.dcEntry.Text)
+	if n := rolls.Load(); n != 1 {
+		t.Errorf("Expected Enter to be ignored while the roll animates, got %d rolls", n)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for app.rollButton.Disabled() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if app.rollButton.Disabled() {
+		t.Fatal("Expected the animation to finish and re-enable the roll button")
+	}
+
+	total, isRichText := app.totalCard.Content.(*widget.RichText)
+	if !isRichText || total.String() != "Total: 5" {
+		t.Errorf("Expected the final total 'Total: 5' after the animation, got %v", app.totalCard.Content)
+	}
+}
+
+func TestRollButton(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	app.roll = func(diceSet dice.DiceSet) dice.RollResult {
+		// Every die shows its highest face, so the result is known in advance.
+		var result dice.RollResult
+		for _, die := range diceSet.Dice {
+			result.DieRolls = append(result.DieRolls, dice.DieRoll{Die: die, Type: "d6", Result: die.Max(), Score: die.Max()})
+			result.Total += die.Max()
+		}
+		return result
+	}
+	app.diceEntry.SetText("2d6")
+	test.Tap(app.rollButton)
+
+	grid, isContainer := app.resultsCard.Content.(*fyne.Container)
+	if !isContainer || len(grid.Objects) != 4 {
+		t.Fatalf("Expected a grid of two dice, got %v", app.resultsCard.Content)
+	}
+	if value := grid.Objects[1].(*widget.Label).Text; value != "6" {
+		t.Errorf("Expected the first die to show 6, got %q", value)
+	}
+	total, isRichText := app.totalCard.Content.(*widget.RichText)
+	if !isRichText || total.String() != "Total: 12" {
+		t.Errorf("Expected 'Total: 12', got %v", app.totalCard.Content)
+	}
+	if app.resultsCard.Subtitle != "" {
+		t.Errorf("Expected no warnings for 2d6, got %q", app.resultsCard.Subtitle)
+	}
+
+	app.diceEntry.SetText("2d6 d1")
+	test.Tap(app.rollButton)
+	if app.resultsCard.Subtitle != "d1 always shows 1" {
+		t.Errorf("Expected a warning about the d1, got %q", app.resultsCard.Subtitle)
+	}
+}
+
+func TestDifficultyClass(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	app.roll = func(diceSet dice.DiceSet) dice.RollResult {
+		return dice.RollResult{Total: 15}
+	}
+	app.diceEntry.SetText("1d20+3")
+
+	tests := []struct {
+		dc   string
+		want string
+	}{
+		{"", ""},
+		{"12", "Success by 3 (DC 12)"},
+		{" 15 ", "Success by 0 (DC 15)"},
+		{"18", "Failure by 3 (DC 18)"},
+	}
+	for _, tt := range tests {
+		app.dcEntry.SetText(tt.dc)
+		test.Tap(app.rollButton)
+
+		check := ""
+		if box, isContainer := app.totalCard.Content.(*fyne.Container); isContainer {
+			check = box.Objects[1].(*widget.Label).Text
+		}
+		if check != tt.want {
+			t.Errorf("With DC %q, expected check %q, got %q", tt.dc, tt.want, check)
+		}
+	}
+
+	app.dcEntry.SetText("hard")
+	test.Tap(app.rollButton)
+	if label, isLabel := app.resultsCard.Content.(*widget.Label); !isLabel || !strings.Contains(label.Text, "DC error") {
+		t.Errorf("Expected an error for a DC that is not a number, got %v", app.resultsCard.Content)
+	}
+}
+
+func TestColourFaces(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	result := dice.RollResult{DieRolls: []dice.DieRoll{
+		{Type: "f52", Result: 1, Score: 11, FancyValue: "A♥"},
+		{Type: "f52", Result: 40, Score: 10, FancyValue: "K♠"},
+	}}
+
+	tests := []struct {
+		colour bool
+		want   []widget.Importance
+	}{
+		{true, []widget.Importance{widget.DangerImportance, widget.MediumImportance}},
+		{false, []widget.Importance{widget.MediumImportance, widget.MediumImportance}},
+	}
+	for _, tc := range tests {
+		app.colourFaces.SetChecked(tc.colour)
+		app.updateResults(result)
+		grid := app.resultsCard.Content.(*fyne.Container)
+		for i, want := range tc.want {
+			if got := grid.Objects[2*i+1].(*widget.Label).Importance; got != want {
+				t.Errorf("Colour faces %v, face %s: importance %v, want %v", tc.colour, result.DieRolls[i].FancyValue, got, want)
+			}
+		}
+	}
+}
+
+func TestNumericDiceFaces(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	result := dice.RollResult{DieRolls: []dice.DieRoll{
+		{Type: "f6", Result: 6, Score: 6, FancyValue: "6⚅"},
+		{Type: "f4", Result: 1, Score: 4, FancyValue: "♠"},
+	}}
+
+	// Without the toggle, a dice face is shown as itself if the fonts can draw it.
+	face := "6"
+	if canRender("6⚅") {
+		face = "6⚅"
+	}
+	tests := []struct {
+		numeric bool
+		want    []string
+	}{
+		{false, []string{face, "♠"}},
+		{true, []string{"6", "♠"}},
+	}
+	for _, tc := range tests {
+		app.numericDice.SetChecked(tc.numeric)
+		app.updateResults(result)
+		grid := app.resultsCard.Content.(*fyne.Container)
+		for i, want := range tc.want {
+			if got := grid.Objects[2*i+1].(*widget.Label).Text; got != want {
+				t.Errorf("Dice faces as numbers %v: face %d shows %q, want %q", tc.numeric, i, got, want)
+			}
+		}
+	}
+}
+
+func TestCanRender(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"heads", true},
+		{"A♥", true},
+		{"♈", true},
+		{"success advantage", true},
+		{"\uFFFD", false},
+		{"x□", false}, // The bundled fonts have no white square
+	}
+	for _, tt := range tests {
+		if got := canRender(tt.text); got != tt.want {
+			t.Errorf("canRender(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestModifierAndDroppedRows(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+	app.updateResults(dice.RollResult{
+		DieRolls: []dice.DieRoll{
+			{Die: dice.NewDie(6), Type: "d6", Result: 5, Score: 5},
+			{Die: dice.NewDie(6), Type: "d6", Result: 1, Score: 1, Dropped: true},
+			{Type: "d6", Result: 8, Score: 8, Rolls: []int{6, 2}},
+		},
+		Modifier: 2,
+		Total:    15,
+	})
+
+	grid, isContainer := app.resultsCard.Content.(*fyne.Container)
+	if !isContainer {
+		t.Fatalf("Expected a grid of results, got %T", app.resultsCard.Content)
+	}
+	var texts []string
+	for _, object := range grid.Objects {
+		texts = append(texts, object.(*widget.Label).Text)
+	}
+	want := []string{"d6", "5", "d6", "1 (dropped)", "d6", "8 (6+2)", "modifier", "+2"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("Expected rows %q, got %q", want, texts)
+	}
+	if dropped := grid.Objects[3].(*widget.Label); dropped.Importance != widget.LowImportance {
+		t.Errorf("Expected the dropped die to be greyed out, got importance %v", dropped.Importance)
+	}
+}
+
+func TestHighlightNaturals(t *testing.T) {
+	testApp := test.NewApp()
+	defer testApp.Quit()
+
+	app := NewApp(testApp.NewWindow("Roll"))
+
+	tests := []struct {
+		highlight bool
+		roll      int
+		subtitle  string
+	}{
+		{true, 20, "Natural 20!"},
+		{true, 1, "Natural 1!"},
+		{true, 12, ""},
+		{false, 20, ""},
+	}
+
+	for _, tc := range tests {
+		app.highlight.SetChecked(tc.highlight)
+		app.updateResults(dice.RollResult{
+			DieRolls: []dice.DieRoll{{Die: dice.NewDie(20), Type: "d20", Result: tc.roll, Score: tc.roll}},
+			Total:    tc.roll,
+		})
+		if app.totalCard.Subtitle != tc.subtitle {
+			t.Errorf("Highlight %v, roll %d: expected subtitle '%s', got '%s'", tc.highlight, tc.roll, tc.subtitle, app.totalCard.Subtitle)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package gui
+
+import (
+	"bytes"
+	"image/color"
+	"sync"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"github.com/go-text/typesetting/font"
+)
+
+// Font scale limits and the step used by the zoom buttons.
+const (
+	minFontScale  = 0.75
+	maxFontScale  = 2.5
+	fontScaleStep = 0.25
+)
+
+// scaledTheme wraps another theme and multiplies its text sizes, so that low-vision users can enlarge the results.
+type scaledTheme struct {
+	base  fyne.Theme
+	scale float32
+}
+
+// newScaledTheme returns the default theme with text sizes multiplied by scale.
+func newScaledTheme(scale float32) *scaledTheme {
+	return &scaledTheme{base: theme.DefaultTheme(), scale: scale}
+}
+
+// Color returns the base theme's colour.
+func (t *scaledTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	return t.base.Color(name, variant)
+}
+
+// Font returns the base theme's font.
+func (t *scaledTheme) Font(style fyne.TextStyle) fyne.Resource {
+	return t.base.Font(style)
+}
+
+// Icon returns the base theme's icon.
+func (t *scaledTheme) Icon(name fyne.ThemeIconName) fyne.Resource {
+	return t.base.Icon(name)
+}
+
+// Size returns the base theme's size, scaled for text and the padding around it.
+func (t *scaledTheme) Size(name fyne.ThemeSizeName) float32 {
+	size := t.base.Size(name)
+	switch name {
+	case theme.SizeNameText, theme.SizeNameHeadingText, theme.SizeNameSubHeadingText,
+		theme.SizeNameCaptionText, theme.SizeNameInlineIcon:
+		return size * t.scale
+	}
+	return size
+}
+
+// clampFontScale limits a font scale to the supported range.
+func clampFontScale(scale float32) float32 {
+	return min(max(scale, minFontScale), maxFontScale)
+}
+
+// labelFonts are the fonts Fyne tries in turn when drawing a label: the theme's text font, the
+// built-in text font and, if bundled, the emoji font. They are loaded once, when first needed.
+var labelFonts = sync.OnceValue(func() []font.Font {
+	resources := []fyne.Resource{theme.TextFont(), theme.DefaultTextFont(), theme.DefaultEmojiFont()}
+	fonts := make([]font.Font, 0, len(resources))
+	for _, resource := range resources {
+		if resource == nil {
+			continue
+		}
+		face, err := font.ParseTTF(bytes.NewReader(resource.Content()))
+		if err != nil {
+			fyne.LogError("cannot read font "+resource.Name(), err)
+			continue
+		}
+		fonts = append(fonts, face.Font)
+	}
+	return fonts
+})
+
+// canRender reports whether one of the label fonts has a glyph for every character of the text,
+// so that it will not be drawn as a replacement character. Spaces and other invisible characters
+// need no glyph. If no font can be read, the text is assumed to be drawable.
+func canRender(text string) bool {
+	fonts := labelFonts()
+	if len(fonts) == 0 {
+		return true
+	}
+	for _, r := range text {
+		if r == unicode.ReplacementChar {
+			return false
+		}
+		if unicode.IsSpace(r) || !unicode.IsGraphic(r) {
+			continue
+		}
+		found := false
+		for _, f := range fonts {
+			if _, found = f.NominalGlyph(r); found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
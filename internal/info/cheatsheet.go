@@ -5,13 +5,36 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/sfkleach/roll/internal/dice"
 )
 
 // Version information - will be set at build time via ldflags.
 // Default value is used for development builds.
 var Version = "dev"
 
+// maxPreviewFaces is the most faces listed for a fancy die in the cheatsheet before it is abbreviated.
+const maxPreviewFaces = 13
+
+// fancyDiceSection lists every registered fancy die, built-in and custom, with a preview of its faces,
+// so the cheatsheet always matches the dice that can actually be rolled.
+func fancyDiceSection() string {
+	var lines []string
+	for _, fancy := range dice.ListFancyDice() {
+		names := make([]string, len(fancy.Faces))
+		for i, face := range fancy.Faces {
+			names[i] = face.Name
+		}
+		if len(names) > maxPreviewFaces {
+			names = append(names[:4:4], "...", names[len(names)-1])
+		}
+		lines = append(lines, fmt.Sprintf("- **%s** - %d faces: %s  ", fancy.Type, len(fancy.Faces), strings.Join(names, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // getCheatsheetMarkdownSource returns the single source of truth for cheatsheet content.
+// The fancy dice section is generated from the dice registry, so it includes any loaded custom dice.
 func getCheatsheetMarkdownSource() string {
 	return fmt.Sprintf(`# Roll Dice Application v%s
 
@@ -22,38 +45,116 @@ func getCheatsheetMarkdownSource() string {
 - **3d6** - Roll three 6-sided dice  
 - **2d10 d6** - Roll two 10-sided dice and one 6-sided die  
 - **1d20,7d4** - Roll one 20-sided die and seven 4-sided dice  
+- **d%%%%** - Percentile roll: a tens d10 and a units d10 read together, 1 to 100 (00 and 0 is 100)  
+- **d10z** - Zero-based die reading 0 to 9 instead of 1 to 10  
+- **2d[3-8]** - Dice reading every whole number from 3 to 8  
+- **pool(d6 d6 d8 d10)** - Roll a mixed pool of dice together  
+- **bestpertype(4d6 3d8)** - Keep the best die of each type: the best d6 and the best d8  
+- **unique(5d20)** - Reroll any die that duplicates an earlier one, giving up after 100 rerolls  
 
 ### FANCY DICE (Custom Unicode Characters):
-- **f2** - Two-sided coin (heads/tails)  
-- **f4** - Four-sided die with suit symbols (♠♥♦♣)  
-- **f6** - Six-sided die with dot patterns (⚀⚁⚂⚃⚄⚅)  
-- **f7** - Seven-sided die with days of week (Mon-Sun)  
-- **f12** - Twelve-sided die with zodiac signs  
-- **f13** - Thirteen-sided die with card ranks (A,2-10,J,Q,K)  
-- **f52** - Fifty-two-sided die with playing cards  
+%s
 
 ### CUSTOM FANCY DICE:
 - **--fancy=GLOB** - Load custom fancy dice from files matching pattern  
 - File format: one line per value as "name, value" or just "name"  
 - Example: **--fancy='*.dice'** loads all .dice files  
 
+### INLINE DICE:
+- **d{2,3,5,7}** - Roll a one-off die with the listed faces  
+- **2d{red,green,blue}** - Numeric faces score their number, others their position  
+- Put a backslash before any comma, brace or backslash inside a face  
+
 ### EXCLUSIVE DICE (No Repeats in Group):
 - **3D6** - Roll three 6-sided dice with no duplicate values  
 - **5D20** - Roll five 20-sided dice with no duplicate values  
 - **13F52** - Roll thirteen cards with no duplicates  
+- **shared(3D6 d4 2D6)** - One group for every exclusive d6, even apart  
+- **separate(3D6, 2D6)** - A group per argument, so the d6s may repeat  
+
+### MODIFIERS AND SELECTION:
+- **1d20+5** - Add a flat modifier to the total  
+- **3d6-2** - Subtract a flat modifier from the total  
+- **highest(2d20)** - Keep only the single highest die  
+- **lowest(2d20)** - Keep only the single lowest die  
+- **highest(2d20)+5** - Functions combine with other terms  
+- **adv** or **1d20adv** - Advantage: roll two d20s and keep the higher  
+- **dis+5** or **1d20dis+5** - Disadvantage: roll two d20s, keep the lower and add 5  
+- **{4d6 2d8}kh3** - Keep the highest three dice of the whole group (also **kl**, **dl**, **dh**)  
+- **5F52kh3** - Deal five distinct cards and keep the three highest-scoring  
+- **5d6 mid3** or **{5d6}mid3** - Keep the middle three dice, dropping from both ends  
+- **6d10>=7** - Count the dice showing 7 or more (also **<=**, **>**, **<**, **=**)  
+- **6d6 keep>=5** - Keep and sum only the dice showing 5 or more  
+- **d100<=45** - Roll-under check: critical success, success, failure or fumble  
+- **best(2d6+1, 1d12, 3d4)** - Roll every option and keep the highest total (**worst** keeps the lowest)  
+- **8d6/2** or **2d6*3** - Divide or multiply the whole total; **--rounding=floor|ceil|nearest** (floor by default)  
+- **1d100%%10** - Remainder of the whole total, e.g. the units digit; **1d100/10%%10** gives the tens digit  
+- **1d20+5 clamp(1,20)** - Limit the final total to a range, after all dice and modifiers  
+- **1d20+5 on>=18 add 1d6** - Roll and add the bonus only on a natural 18 or more on the d20  
+
+### EXPLODING DICE:
+- **3d6!** - Roll again whenever a die shows its maximum, adding each roll as a new die  
+- **3d6!!** - Compounding: extra rolls are summed into the original die  
+- **3d6!p** - Penetrating: each extra roll counts one less, shown as e.g. 3 (exploded, rolled 4 - 1)  
+- **3d10!>=9** - Explode on any roll meeting the condition; **3d10! >=9** counts successes instead  
+- **sw d8** - Savage Worlds trait die that aces (compounds) on its maximum  
+- **swwild d8** - Savage Worlds trait die plus an acing d6 wild die, keeping the higher  
+- Explosion chains stop after 100 extra rolls  
+
+### CRITICAL HITS:
+- **1d20+5 crit 2d6+3** - Roll an attack, then damage  
+- A natural 20 on the attack d20 doubles the damage dice (not the modifiers)  
 
 ### SORTING OPTIONS:
 - **-a** or **--ascending** - Sort results in ascending order  
 - **-d** or **--descending** - Sort results in descending order  
+- **--sort=value** - Sort fancy dice by their scoring value (the default), ties in face order, so f13 ranks run 2 to A  
+- **--sort=roll** - Sort fancy dice by face position, e.g. a card's place in the deck  
+
+### OUTPUT OPTIONS:
+- **--compact** or **--oneline** - Print each roll on one line, e.g. 3d6: 4+2+6 = 12  
+- **-q** or **--quiet** - Print only the total, for scripts  
+- **--json** - Print each roll as a line of JSON, for bots and scripts  
+- **--template '{{.Total}}'** - Format each roll with a Go template given the --json fields  
+- **1d20+5 #attack** - Label a roll; the label appears in every output format  
+- **--seed=N** - Seed the dice so the same rolls are produced every time  
+- **--show-seed** - Print the generator and seed after each roll, to reproduce it with --seed  
+- **--subtotals** - Print a subtotal for each dice group, e.g. 2d6 subtotal: 9  
+- **--no-total** - List the individual dice without the Total line  
+- **--exit-on-success** - Exit with status 0 if a success target was met, 1 if not, 2 on error  
+- **--faces** - Count how many times each face came up, per die type  
+- **--grouped** - One line per die type, e.g. 10d6: [3, 5, 1, ...] = 35  
+- **--percentile** - Read d100 results like percentile dice, from 01 to 00 (which is 100)  
+- **--chance** - Print the probability of meeting a target, e.g. --chance '3d6+2 >= 15'  
+- **--range** - Show the minimum and maximum possible totals without rolling  
+- **--list-dice** - List every fancy die, including custom ones, with its faces and scores  
+- **ROLL_DEFAULT=3d6** - Roll this expression when no dice are given; **--gui** opens the GUI instead  
+- **--gui 3d6** - Open the GUI with 3d6 in the entry field; add **--auto-roll** to roll it at once  
+- **--timestamp** - Prefix each result with the time it was rolled (a field with --json); add **--utc** for UTC  
+- **--chance --odds '1d20 >= 18'** - Give the probability as odds too, e.g. about 1 in 7  
+- **--use-average** - Show every die's rounded average instead of rolling, for stable examples  
+- **--no-dice-pack** - Skip the fancy dice files in ~/.config/roll/dice, which load at startup  
+- **--group-digits** - Group the digits of totals in thousands, e.g. 30,000  
+- **--narrate** - Describe each roll in a sentence, e.g. You rolled a coin showing heads for a total of 1.  
+- **--interactive --log session.log** - Append every roll of the session to a transcript file  
+- **--jsonl rolls.jsonl** - Append every roll to a file as a line of JSON with its time and seed  
 
 ### EXAMPLES:
 - roll 3d6 2d10  
+- roll roll 3d6 (the same, with the **roll** command; **roll list-dice** lists the fancy dice)  
 - roll --ascending 5D20  
 - roll f52 f52 f52  
+- roll 'highest(2d20)+5'  
+- roll vs '1d20+5' '1d20+3' (contested roll, reports the winner and margin)  
+- roll init 'Goblin: 1d20+2' 'Hero: 1d20+5' (initiative order, ties broken by the d20)  
+- roll completion bash (print a shell completion script; **roll man** prints a man page)  
+- roll batch combat (roll each expression of a batch from the config file)  
+- roll --file encounter.txt (roll each expression in a file, one per line; # starts a comment)  
+- roll stats --monte-carlo 100000 '{4d6!}kh3' (estimate the mean, spread and histogram of totals)  
 - roll --fancy='colors.dice' fcolors  
 - -a 3d6 (in GUI)  
 - --descending 2d20 3d4 (in GUI)  
-`, Version)
+`, Version, fancyDiceSection())
 }
 
 // markdownToPlainText converts markdown to plain text using simple string replacement.
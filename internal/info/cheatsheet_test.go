@@ -1,8 +1,12 @@
 package info
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/sfkleach/roll/internal/dice"
 )
 
 func TestGetVersion(t *testing.T) {
@@ -64,3 +68,26 @@ func TestGetCheatsheetMarkdown(t *testing.T) {
 		t.Error("Markdown cheatsheet content should include current version")
 	}
 }
+
+func TestCheatsheetFancyDice(t *testing.T) {
+	content := GetCheatsheetMarkdown()
+	for _, want := range []string{"**f4** - 4 faces: ♠, ♥, ♦, ♣", "**f52** - 52 faces: 2♣, 3♣, 4♣, 5♣, ..., A♠"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Cheatsheet should list the built-in fancy die %q", want)
+		}
+	}
+
+	// Custom fancy dice appear once they are loaded.
+	path := filepath.Join(t.TempDir(), "colors.dice")
+	if err := os.WriteFile(path, []byte("red\ngreen\nblue\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dice.LoadCustomFancyDice(path); err != nil {
+		t.Fatalf("LoadCustomFancyDice unexpected error: %v", err)
+	}
+	defer dice.ResetFancyDice()
+
+	if content := GetCheatsheetContent(); !strings.Contains(content, "f3 - 3 faces: red, green, blue") {
+		t.Errorf("Cheatsheet should list the custom fancy die, got:\n%s", content)
+	}
+}
@@ -2,140 +2,2339 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"github.com/chzyer/readline"
 
+	"github.com/sfkleach/roll/internal/config"
 	"github.com/sfkleach/roll/internal/dice"
+	"github.com/sfkleach/roll/internal/fair"
 	"github.com/sfkleach/roll/internal/gui"
 	"github.com/sfkleach/roll/internal/info"
 )
 
 func main() {
+	// Load the user's defaults before defining flags, so that flags override them.
+	cfg := loadConfig()
+
 	// Define command line flags with abbreviated versions.
-	var ascending = flag.Bool("ascending", false, "Sort individual dice rolls in ascending order")
-	flag.BoolVar(ascending, "a", false, "Sort individual dice rolls in ascending order (short form)")
-	var descending = flag.Bool("descending", false, "Sort individual dice rolls in descending order")
-	flag.BoolVar(descending, "d", false, "Sort individual dice rolls in descending order (short form)")
+	var ascending = flag.Bool("ascending", cfg.Sort == "ascending", "Sort individual dice rolls in ascending order")
+	flag.BoolVar(ascending, "a", cfg.Sort == "ascending", "Sort individual dice rolls in ascending order (short form)")
+	var descending = flag.Bool("descending", cfg.Sort == "descending", "Sort individual dice rolls in descending order")
+	flag.BoolVar(descending, "d", cfg.Sort == "descending", "Sort individual dice rolls in descending order (short form)")
+	var sortBy = flag.String("sort", "value", "Sort fancy dice by scoring \"value\" or by face position \"roll\" (with -a or -d)")
 	var showHelp = flag.Bool("help", false, "Show help and cheatsheet")
 	var showVersion = flag.Bool("version", false, "Show version information")
 	var fancyFiles = flag.String("fancy", "", "Load custom fancy dice from files matching glob pattern")
+	var noDicePack = flag.Bool("no-dice-pack", false, "Do not load the fancy dice files in ~/.config/roll/dice at startup")
+	var rollFile = flag.String("file", "", "Roll each dice expression in a file, one per line")
 	var interactive = flag.Bool("interactive", false, "Run in interactive mode")
+	var forceGUI = flag.Bool("gui", false, "Open the GUI even when dice are given, with them in the entry field, or instead of rolling $"+defaultExpressionEnv)
+	var autoRoll = flag.Bool("auto-roll", false, "With --gui, roll the given dice as soon as the GUI opens")
 	flag.BoolVar(interactive, "i", false, "Run in interactive mode (short form)")
+	var jsonOutput = flag.Bool("json", false, "Print each roll as a JSON object, including any label such as \"1d20+5 #attack\"")
+	var compact = flag.Bool("compact", cfg.Compact, "Print each roll on a single line (e.g. \"3d6: 4+2+6 = 12\")")
+	flag.BoolVar(compact, "oneline", cfg.Compact, "Print each roll on a single line (alias for --compact)")
+	var showRange = flag.Bool("range", false, "Show the minimum and maximum possible totals instead of rolling")
+	var chance = flag.Bool("chance", false, "Print the probability that an expression meets a target, e.g. --chance '3d6+2 >= 15'")
+	var odds = flag.Bool("odds", false, "Also give the --chance probability as odds, e.g. about 1 in 4")
+	var quiet = flag.Bool("quiet", false, "Print only the total")
+	flag.BoolVar(quiet, "q", false, "Print only the total (short form)")
+	var seed = flag.Uint64("seed", 0, "Seed the random number generator for reproducible rolls")
+	var useAverage = flag.Bool("use-average", false, "Show every die's rounded average instead of rolling, for stable example output")
+	var showSeed = flag.Bool("show-seed", false, "Print the generator and seed after each roll so it can be reproduced with --seed")
+	var faces = flag.Bool("faces", false, "Print how many times each face came up, per die type")
+	var grouped = flag.Bool("grouped", false, "Print one line per die type listing its rolls and subtotal (e.g. \"10d6: [3, 5, ...] = 35\")")
+	var percentile = flag.Bool("percentile", false, "Show d100 results as percentile dice readings, \"00\" to \"99\", where \"00\" is 100")
+	var subtotals = flag.Bool("subtotals", cfg.Subtotals, "Print a subtotal for each dice group")
+	var exitOnSuccess = flag.Bool("exit-on-success", false, "Exit with status 0 if a success target such as 6d10>=7 was met, 1 if not, 2 on error")
+	var noTotal = flag.Bool("no-total", false, "Print the individual dice without the \"Total:\" line")
+	var listDice = flag.Bool("list-dice", false, "List every known fancy die with its faces and scores")
+	var timestamp = flag.Bool("timestamp", false, "Prefix each result with an ISO-8601 timestamp of when it was rolled")
+	var utc = flag.Bool("utc", false, "Show timestamps in UTC rather than local time (with --timestamp)")
+	var logFile = flag.String("log", "", "Append every interactive roll to a transcript file, with its time and seed (with --interactive)")
+	var jsonlFile = flag.String("jsonl", "", "Append every roll to a file as one line of JSON, with its time and seed")
+	var groupDigits = flag.Bool("group-digits", false, "Group the digits of totals in thousands, e.g. 30,000")
+	var roundingRule = flag.String("rounding", "floor", "Round divided totals such as 1d6/2 \"floor\" (down), \"ceil\" (up) or \"nearest\"")
+	var outputTemplate = flag.String("template", "", "Format each roll with a Go text/template given the --json fields, e.g. '{{.Total}}'")
+	flag.StringVar(outputTemplate, "output-template", "", "Format each roll with a Go text/template (alias for --template)")
+	var narrate = flag.Bool("narrate", false, "Describe each roll in a sentence, e.g. \"You rolled three six-sided dice getting 4, 2, and 6 for a total of 12.\"")
 	flag.Parse()
 
-	// Handle version flag.
-	if *showVersion {
-		fmt.Printf("Roll Dice Application v%s\n", info.GetVersion())
-		os.Exit(0)
+	// A sort order given on the command line replaces the configured one.
+	if isFlagSet("ascending") || isFlagSet("a") {
+		*descending = isFlagSet("descending") || isFlagSet("d")
+	} else if isFlagSet("descending") || isFlagSet("d") {
+		*ascending = false
+	}
+
+	// Apply the configured separators, which the GUI also uses.
+	if err := dice.SetSeparators(cfg.Separators); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file %s: separators: %v\n", config.DefaultPath(), err)
+		os.Exit(1)
+	}
+
+	// Apply the configured pairs of opposing symbols, which the GUI also uses.
+	if err := dice.SetCancellations(cfg.Cancel); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file %s: cancel: %v\n", config.DefaultPath(), err)
+		os.Exit(1)
+	}
+
+	// Apply the rounding rule for divided totals, which the GUI also uses.
+	roundingMode, err := dice.ParseRounding(*roundingRule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --%v\n", err)
+		os.Exit(1)
+	}
+	dice.SetRounding(roundingMode)
+
+	// Apply the configured explosion cap.
+	if cfg.ExplosionCap > 0 {
+		dice.SetExplosionCap(cfg.ExplosionCap)
+	}
+
+	// Seed the dice for reproducible rolls if requested.
+	if isFlagSet("seed") {
+		dice.SetSeed(*seed)
+	}
+
+	// Show averages rather than rolling, for deterministic output in documentation and layout tests.
+	if *useAverage {
+		dice.SetAverage(true)
+	}
+
+	// Only interactive sessions are logged.
+	if *logFile != "" && !*interactive {
+		fmt.Fprintf(os.Stderr, "Error: --log only applies to interactive mode (with --interactive)\n")
+		os.Exit(1)
+	}
+
+	sortKey, err := parseSortKey(*sortBy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var tmpl *template.Template
+	if *outputTemplate != "" {
+		if *jsonOutput {
+			fmt.Fprintf(os.Stderr, "Error: --template cannot be combined with --json\n")
+			os.Exit(1)
+		}
+		if tmpl, err = parseOutputTemplate(*outputTemplate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := outputOptions{
+		ascending:     *ascending,
+		descending:    *descending,
+		sortBy:        sortKey,
+		compact:       *compact,
+		json:          *jsonOutput,
+		showRange:     *showRange,
+		quiet:         *quiet,
+		faces:         *faces,
+		grouped:       *grouped,
+		percentile:    *percentile,
+		subtotals:     *subtotals,
+		noTotal:       *noTotal,
+		exitOnSuccess: *exitOnSuccess,
+		showSeed:      *showSeed,
+		reseed:        (*showSeed || *logFile != "" || *jsonlFile != "") && !isFlagSet("seed"),
+		timestamp:     *timestamp,
+		groupDigits:   *groupDigits,
+		narrate:       *narrate,
+		template:      tmpl,
+		odds:          *odds,
+		utc:           *utc,
+		macros:        cfg.Macros,
+		batches:       cfg.Batches,
+	}
+
+	// Handle version flag.
+	if *showVersion {
+		fmt.Printf("Roll Dice Application v%s\n", info.GetVersion())
+		os.Exit(0)
+	}
+
+	// Load the user's own dice library, then any --fancy files, so that the help lists them too.
+	if !*noDicePack {
+		dir := config.DefaultDiceDir()
+		for _, warning := range loadDicePack(dir) {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", dir, warning)
+		}
+	}
+	if *fancyFiles != "" {
+		err := dice.LoadCustomFancyDice(*fancyFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading fancy dice files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Handle help flag.
+	if *showHelp {
+		fmt.Printf("Usage: %s [OPTIONS] [DICE_NOTATION]\n", os.Args[0])
+		fmt.Printf("       %s [OPTIONS] COMMAND [ARGUMENTS]\n\n", os.Args[0])
+		fmt.Println("Commands:")
+		for _, line := range formatSubcommands() {
+			fmt.Println(line)
+		}
+		fmt.Println()
+		fmt.Println("Examples:")
+		fmt.Println("  roll 3d6")
+		fmt.Println("  roll --ascending 2d10 d6")
+		fmt.Println("  roll --descending --sort=roll 5f52")
+		fmt.Println("  roll --fancy='*.dice' 2f6")
+		fmt.Println("  roll --compact 3d6+2")
+		fmt.Println("  roll --json '1d20+5 #attack'")
+		fmt.Println("  roll --range 3d6+2")
+		fmt.Println("  roll --chance '3d6+2 >= 15'")
+		fmt.Println("  roll --chance --odds '1d20 >= 18'")
+		fmt.Println("  roll --quiet --seed=42 3d6+2")
+		fmt.Println("  roll --show-seed 4d6")
+		fmt.Println("  roll --use-average 3d6+2")
+		fmt.Println("  roll --faces 8d6")
+		fmt.Println("  roll --grouped 10d6 4f4")
+		fmt.Println("  roll --percentile d100")
+		fmt.Println("  roll 'd%%'")
+		fmt.Println("  roll '1d20+5 clamp(1,20)'")
+		fmt.Println("  roll --rounding=ceil '8d6/2'")
+		fmt.Println("  roll '1d100%10'")
+		fmt.Println("  roll '1d20+5 on>=18 add 1d6'")
+		fmt.Println("  roll --subtotals 2d6 1d8 f4")
+		fmt.Println("  roll --no-total 6d6")
+		fmt.Println("  roll --exit-on-success '6d10>=7' && echo hit")
+		fmt.Println("  roll --timestamp --utc 1d20")
+		fmt.Println("  roll --group-digits 1000d1000")
+		fmt.Println("  roll --narrate 3d6 f2")
+		fmt.Println("  roll --template '{{.Label}},{{range .Dice}}{{.Result}},{{end}}{{.Total}}' '3d6 #str'")
+		fmt.Println("  roll vs '1d20+5' '1d20+3'")
+		fmt.Println("  roll init 'Goblin: 1d20+2' 'Hero: 1d20+5'")
+		fmt.Println("  roll commit 3d6, then roll reveal SECRET 3d6")
+		fmt.Println("  roll batch combat")
+		fmt.Println("  roll --file encounter.txt")
+		fmt.Println("  roll stats --monte-carlo 100000 --seed 1 '{4d6!}kh3'")
+		fmt.Println("  roll --fancy='*.dice' --list-dice")
+		fmt.Println("  roll --interactive")
+		fmt.Println("  roll --interactive --log session.log")
+		fmt.Println("  roll --jsonl rolls.jsonl 3d6")
+		fmt.Println("  ROLL_DEFAULT=3d6 roll (roll 3d6 when no dice are given; roll --gui opens the GUI)")
+		fmt.Println("  roll --gui --auto-roll 3d6")
+		fmt.Println("  source <(roll completion bash)")
+		fmt.Println("  roll man > roll.1")
+		fmt.Println()
+		fmt.Println(info.GetCheatsheetContent())
+		os.Exit(0)
+	}
+
+	// List the fancy dice, including any custom ones just loaded.
+	if *listDice {
+		fmt.Print(formatDiceList(dice.ListFancyDice()))
+		return
+	}
+
+	// Record every roll as a line of JSON if requested.
+	if *jsonlFile != "" {
+		history, err := openTranscript(*jsonlFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer history.Close()
+		opts.history = history
+	}
+
+	// Roll the expressions in a file if requested.
+	if *rollFile != "" {
+		if err := runFile(*rollFile, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Get remaining arguments (dice expressions).
+	args := flag.Args()
+
+	// Open the GUI with any dice given in the entry field: roll --gui 3d6.
+	if *forceGUI {
+		expression := strings.Join(args, " ")
+		runGUI(expression, *autoRoll && expression != "")
+		return
+	}
+
+	// Handle interactive mode, logging to a transcript if requested.
+	if *interactive {
+		if *logFile != "" {
+			transcript, err := openTranscript(*logFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer transcript.Close()
+			opts.transcript = transcript
+		}
+		runInteractive(opts)
+		return
+	}
+
+	// Run the command named by the first argument, such as "roll stats" or "roll init".
+	if len(args) > 0 {
+		if command, found := findSubcommand(args[0]); found {
+			command.run(args[1:], opts)
+			return
+		}
+	}
+
+	// Handle probability queries: roll --chance '3d6+2 >= 15'.
+	if *chance {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --chance requires a dice expression and a target, e.g. roll --chance '3d6+2 >= 15'\n")
+			os.Exit(1)
+		}
+		if err := runChance(strings.Join(args, " "), opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// If command line arguments are provided, run in command line mode.
+	if len(args) > 0 {
+		runCommandLine(args, opts)
+		return
+	}
+
+	// Without arguments, roll the default expression from the environment.
+	if expression := strings.TrimSpace(os.Getenv(defaultExpressionEnv)); expression != "" {
+		runCommandLine([]string{expression}, opts)
+		return
+	}
+
+	// Otherwise, run the GUI application.
+	runGUI("", false)
+}
+
+// defaultExpressionEnv names the environment variable holding the expression rolled when roll is run
+// without arguments, e.g. ROLL_DEFAULT=3d6.
+const defaultExpressionEnv = "ROLL_DEFAULT"
+
+// loadConfig reads the user's configuration file, printing any warnings.
+// A missing file gives the defaults; a malformed file is a fatal error.
+func loadConfig() config.Config {
+	path := config.DefaultPath()
+	cfg, warnings, err := config.Load(path)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, warning)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// loadDicePack loads every .dice file in dir as custom fancy dice. A missing directory is not an
+// error, and a file that cannot be loaded is reported as a warning so that the others still load.
+func loadDicePack(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.dice"))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var warnings []string
+	for _, file := range files {
+		if err := dice.LoadCustomFancyDice(file); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	return warnings
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// outputOptions controls how roll results are sorted and printed.
+type outputOptions struct {
+	ascending     bool         // Sort individual dice rolls in ascending order
+	descending    bool         // Sort individual dice rolls in descending order
+	sortBy        dice.SortKey // Compare dice by score or by face position when sorting
+	compact       bool         // Print each roll on a single line
+	json          bool         // Print each roll as a JSON object
+	showRange     bool         // Print the minimum and maximum possible totals instead of rolling
+	quiet         bool         // Print only the total
+	faces         bool         // Print a histogram of the faces rolled per die type
+	grouped       bool         // Print one line per die type with its rolls and subtotal
+	percentile    bool         // Show d100 results as percentile readings, "00" to "99"
+	subtotals     bool         // Print a subtotal for each dice group
+	noTotal       bool         // Leave out the total line after the individual dice
+	exitOnSuccess bool         // Set the exit status from whether a success target was met
+	showSeed      bool         // Print the generator and seed after each roll
+	reseed        bool         // Seed each roll afresh so that it can be reproduced on its own
+	timestamp     bool         // Prefix each result with the time it was rolled
+	utc           bool         // Show timestamps in UTC
+	groupDigits   bool         // Group the digits of totals in thousands, e.g. 30,000
+	odds          bool         // Give --chance probabilities as odds too, e.g. about 1 in 4
+	narrate       bool         // Describe each roll in a sentence for new players
+	transcript    io.Writer    // Where interactive rolls are logged with --log, or nil
+	history       io.Writer    // Where every roll is recorded as a line of JSON with --jsonl, or nil
+
+	template  *template.Template // Formats each roll with --template, or nil
+	macros    map[string]string  // Named dice expressions from the config file
+	batches   map[string]string  // Named lists of expressions from the config file or interactive mode
+	variables map[string]int     // Named numbers set in interactive mode, or nil outside it
+}
+
+// macroNameRegex matches a whole word that may name a macro.
+var macroNameRegex = regexp.MustCompile(`\b[A-Za-z_][A-Za-z0-9_]*\b`)
+
+// expandMacros replaces each word of the expression that names a macro with its dice expression.
+// Expansion is not recursive, so a macro cannot refer to another macro.
+func expandMacros(expression string, macros map[string]string) string {
+	if len(macros) == 0 {
+		return expression
+	}
+	return macroNameRegex.ReplaceAllStringFunc(expression, func(word string) string {
+		if replacement, isMacro := macros[word]; isMacro {
+			return replacement
+		}
+		return word
+	})
+}
+
+// batchEntry is one roll of a batch, with an optional label such as "attack".
+type batchEntry struct {
+	label      string
+	expression string
+}
+
+// batchLabelRegex matches the label that may start a batch entry, e.g. "attack" in "attack: 1d20+5".
+var batchLabelRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ]*$`)
+
+// parseBatch splits a batch definition such as "attack: 1d20+5; damage: 2d6+3" into its entries.
+func parseBatch(definition string) ([]batchEntry, error) {
+	var entries []batchEntry
+	for _, part := range strings.Split(definition, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		entry := batchEntry{expression: part}
+		if label, expression, found := strings.Cut(part, ":"); found && batchLabelRegex.MatchString(strings.TrimSpace(label)) {
+			entry = batchEntry{label: strings.TrimSpace(label), expression: strings.TrimSpace(expression)}
+		}
+		if entry.expression == "" {
+			return nil, fmt.Errorf("batch entry '%s' has no dice expression", part)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("batch has no dice expressions")
+	}
+	return entries, nil
+}
+
+// runBatch rolls every expression of the named batch in turn, labelling each result.
+// All the expressions are checked before any are rolled.
+func runBatch(name string, opts outputOptions) error {
+	definition, exists := opts.batches[name]
+	if !exists {
+		return fmt.Errorf("unknown batch: %s", name)
+	}
+	entries, err := parseBatch(definition)
+	if err != nil {
+		return fmt.Errorf("batch %s: %v", name, err)
+	}
+	for _, entry := range entries {
+		if err := parseDiceExpression(expandMacros(entry.expression, opts.macros), opts.variables); err != nil {
+			return fmt.Errorf("batch %s: invalid dice notation '%s': %v", name, entry.expression, err)
+		}
+	}
+
+	for _, entry := range entries {
+		label := entry.label
+		if label == "" {
+			label = entry.expression
+		}
+		switch {
+		case opts.quiet || (opts.compact && entry.label != ""):
+			fmt.Printf("%s: ", label)
+		case opts.compact:
+			// Compact output already starts with the expression.
+		case entry.label != "":
+			fmt.Printf("%s (%s):\n", label, entry.expression)
+		default:
+			fmt.Printf("%s:\n", label)
+		}
+		if err := rollExpression(entry.expression, "", opts); err != nil {
+			return fmt.Errorf("batch %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// formatBatches lists the known batches in name order, one per line.
+func formatBatches(batches map[string]string) []string {
+	names := make([]string, 0, len(batches))
+	for name := range batches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("%s = %s", name, batches[name])
+	}
+	return lines
+}
+
+// runFile rolls each dice expression in a file, one per line. Blank lines and "#" comment lines are
+// skipped as in fancy dice files, and a comment after an expression labels it, as in "1d20+5 #attack".
+// An invalid expression is reported and the rest are still rolled.
+func runFile(path string, opts outputOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open roll file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		expression, label := dice.SplitLabel(strings.TrimSpace(scanner.Text()))
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			continue
+		}
+		// Labelled rolls print their own heading, and machine-readable output has none.
+		if label == "" && !opts.compact && !opts.quiet && !opts.json && opts.template == nil && !opts.narrate {
+			fmt.Printf("%s:\n", expression)
+		}
+		if err := rollExpression(expression, label, opts); err != nil {
+			fmt.Printf("Error parsing dice notation '%s': %v\n", expression, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading roll file %s: %v", path, err)
+	}
+	return nil
+}
+
+// runCommandLine processes dice expressions from command line arguments.
+func runCommandLine(diceExpressions []string, opts outputOptions) {
+	// Validate sorting flags.
+	if opts.ascending && opts.descending {
+		fmt.Fprintf(os.Stderr, "Error: Cannot specify both --ascending and --descending flags\n")
+		os.Exit(1)
+	}
+
+	// Join all arguments into a single dice expression.
+	expression := strings.Join(diceExpressions, " ")
+
+	if opts.exitOnSuccess {
+		os.Exit(runExitOnSuccess(expression, opts))
+	}
+
+	// Parse, roll and print the expression.
+	if err := rollExpression(expression, "", opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+		os.Exit(1)
+	}
+}
+
+// Exit statuses for --exit-on-success.
+const (
+	exitSuccess   = 0 // At least one die met the success target
+	exitNoSuccess = 1 // No die met the success target
+	exitError     = 2 // The expression was invalid or has no success target
+)
+
+// runExitOnSuccess rolls and prints an expression that counts successes, returning the exit
+// status for --exit-on-success.
+func runExitOnSuccess(expression string, opts outputOptions) int {
+	if opts.showRange || dice.IsCritNotation(expression) {
+		fmt.Fprintf(os.Stderr, "Error: --exit-on-success needs a plain dice roll with a success target, e.g. 6d10>=7\n")
+		return exitError
+	}
+
+	diceSet, err := dice.ParseDiceNotationWithVariables(expandMacros(expression, opts.macros), opts.variables)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+		return exitError
+	}
+	if !diceSet.CountsSuccesses() {
+		fmt.Fprintf(os.Stderr, "Error: --exit-on-success needs a success target, e.g. 6d10>=7\n")
+		return exitError
+	}
+
+	seedRoll(opts)
+	result := diceSet.Roll()
+	if result.Err != nil {
+		fmt.Fprintf(os.Stderr, "Error rolling '%s': %v\n", expression, result.Err)
+		return exitError
+	}
+	if result.Overflow {
+		fmt.Fprintf(os.Stderr, "Error: the total of '%s' is too large to compute\n", expression)
+		return exitError
+	}
+	rolledAt := time.Now()
+	printTimestamp(rolledAt, opts)
+	printRollResult(expression, result, rolledAt, opts)
+	printSeed(opts)
+	unlabelled, _ := dice.SplitLabel(expression)
+	recordRoll(rolledAt, unlabelled, result, opts)
+	return successExitCode(result)
+}
+
+// successExitCode returns the --exit-on-success status for a roll.
+func successExitCode(result dice.RollResult) int {
+	if result.Successes > 0 {
+		return exitSuccess
+	}
+	return exitNoSuccess
+}
+
+// runContested rolls two expressions against each other and reports which side wins.
+func runContested(expressions []string, opts outputOptions) {
+	if len(expressions) != 2 {
+		fmt.Fprintf(os.Stderr, "Error: vs requires exactly two dice expressions, e.g. roll vs '1d20+5' '1d20+3'\n")
+		os.Exit(1)
+	}
+
+	seedRoll(opts)
+	var results [2]dice.RollResult
+	for i, expression := range expressions {
+		diceSet, err := dice.ParseDiceNotation(expandMacros(expression, opts.macros))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+			os.Exit(1)
+		}
+		results[i] = diceSet.Roll()
+		if results[i].Err != nil {
+			fmt.Fprintf(os.Stderr, "Error rolling '%s': %v\n", expression, results[i].Err)
+			os.Exit(1)
+		}
+		if results[i].Overflow {
+			fmt.Fprintf(os.Stderr, "Error: the total of '%s' is too large to compute\n", expression)
+			os.Exit(1)
+		}
+	}
+
+	outcome := describeContest(results[0].Total, results[1].Total)
+	rolledAt := time.Now()
+	printTimestamp(rolledAt, opts)
+	for i, expression := range expressions {
+		unlabelled, _ := dice.SplitLabel(expression)
+		recordRoll(rolledAt, unlabelled, results[i], opts)
+	}
+
+	if opts.quiet {
+		fmt.Println(results[0].Total, results[1].Total)
+		printSeed(opts)
+		return
+	}
+
+	if opts.compact {
+		fmt.Printf("Side A %s vs Side B %s: %s\n",
+			formatCompactResult(expressions[0], displayDieRolls(results[0].DieRolls, opts), results[0].Modifier, results[0].Total),
+			formatCompactResult(expressions[1], displayDieRolls(results[1].DieRolls, opts), results[1].Modifier, results[1].Total),
+			outcome)
+		printSeed(opts)
+		return
+	}
+
+	for i, side := range []string{"A", "B"} {
+		fmt.Printf("Side %s (%s):\n", side, expressions[i])
+		printRollResult(expressions[i], results[i], rolledAt, opts)
+	}
+	fmt.Println(outcome)
+	printSeed(opts)
+}
+
+// runCommit prints a commitment to rolling an expression with a freshly generated secret.
+// The commitment is shared before the roll; the secret is kept private until the reveal.
+func runCommit(args []string, opts outputOptions) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: commit requires a dice expression, e.g. roll commit 3d6\n")
+		os.Exit(1)
+	}
+
+	expression := strings.Join(args, " ")
+	if err := parseDiceExpression(expandMacros(expression, opts.macros), nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+		os.Exit(1)
+	}
+
+	secret, err := fair.NewSecret()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Commitment: %s\n", secret.Commitment(expression))
+	fmt.Printf("Secret (keep private until the reveal): %s\n", secret.Token())
+	fmt.Printf("To roll: roll reveal %s '%s'\n", secret.Token(), expression)
+}
+
+// runReveal rolls an expression with the seed from a commitment secret, printing the commitment,
+// seed and nonce so that anyone can check the commitment and reproduce the roll.
+func runReveal(args []string, opts outputOptions) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "Error: reveal requires a secret and a dice expression, e.g. roll reveal SECRET 3d6\n")
+		os.Exit(1)
+	}
+
+	secret, err := fair.ParseSecret(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	expression := strings.Join(args[1:], " ")
+	fmt.Printf("Commitment: %s\n", secret.Commitment(expression))
+	fmt.Printf("Seed: %d\n", secret.Seed)
+	fmt.Printf("Nonce: %s\n", secret.Nonce)
+
+	// The committed seed decides the roll, so it must not be replaced by a fresh one
+	// for --show-seed, --log or --jsonl, or the roll could not be verified.
+	opts.reseed = false
+	dice.SetSeed(secret.Seed)
+	if err := rollExpression(expression, "", opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+		os.Exit(1)
+	}
+}
+
+// chanceSamples is the number of rolls behind a --chance estimate when the exact probability
+// is not feasible to compute.
+const chanceSamples = 100000
+
+// runChance prints the probability that a query such as "3d6+2 >= 15" is met.
+func runChance(query string, opts outputOptions) error {
+	chanceQuery, err := dice.ParseChanceQuery(expandMacros(query, opts.macros))
+	if err != nil {
+		return err
+	}
+	chance, err := chanceQuery.Chance(chanceSamples)
+	if err != nil {
+		return err
+	}
+	fmt.Println(formatChance(chanceQuery, chance, opts.odds))
+	return nil
+}
+
+// formatChance formats the probability of a query as a percentage, labelling estimates,
+// e.g. "3d6+2 >= 15: 16.20%" or "3d6! >= 15: ~9.31% (estimated from 100000 rolls)".
+// With odds set the odds follow the percentage, e.g. "3d6+2 >= 15: 25.93%, about 1 in 4".
+func formatChance(query dice.ChanceQuery, chance dice.Chance, odds bool) string {
+	percentage := fmt.Sprintf("%.2f%%", 100*chance.Probability)
+	if !chance.Exact {
+		percentage = "~" + percentage
+	}
+	if odds {
+		percentage += ", " + formatOdds(chance.Probability, chance.Exact)
+	}
+	if chance.Exact {
+		return fmt.Sprintf("%s: %s", query, percentage)
+	}
+	return fmt.Sprintf("%s: %s (estimated from %d rolls)", query, percentage, chance.Samples)
+}
+
+// formatOdds phrases a probability as odds, e.g. "1 in 6", "about 1 in 4" or "about 3 in 4".
+// Likely outcomes, above one half, read better as a small fraction such as "5 in 6", and unlikely
+// ones as "1 in N" with N rounded to two significant figures once it reaches 100. "about" marks
+// odds that are rounded or come from an estimate.
+func formatOdds(probability float64, exact bool) string {
+	switch {
+	case probability <= 0:
+		return "never"
+	case probability >= 1:
+		return "always"
+	}
+
+	var hits, out float64
+	if probability > 0.5 {
+		// Choose the fraction with the smallest denominator up to 10 that is nearest the probability.
+		bestError := math.Inf(1)
+		for denominator := 2.0; denominator <= 10; denominator++ {
+			numerator := math.Round(probability * denominator)
+			if numerator >= denominator {
+				continue
+			}
+			if err := math.Abs(probability - numerator/denominator); err < bestError-1e-12 {
+				hits, out, bestError = numerator, denominator, err
+			}
+		}
+	} else {
+		hits, out = 1, 1/probability
+		if out >= 100 {
+			scale := math.Pow(10, math.Floor(math.Log10(out))-1)
+			out = math.Round(out/scale) * scale
+		} else {
+			out = math.Round(out)
+		}
+	}
+
+	odds := fmt.Sprintf("%d in %s", int(hits), dice.GroupDigits(int(out)))
+	if !exact || math.Abs(probability-hits/out) > 1e-9 {
+		odds = "about " + odds
+	}
+	return odds
+}
+
+// Histogram settings for roll stats.
+const (
+	histogramWidth  = 40 // Characters in the bar of the most common total
+	reliableSamples = 30 // Totals seen fewer times than this are marked as unreliable
+)
+
+// runStats estimates the distribution of an expression by rolling it many times.
+func runStats(args []string, opts outputOptions) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	samples := flags.Int("monte-carlo", 10000, "Number of times to roll the expression")
+	seed := flags.Uint64("seed", 0, "Seed the random number generator for a reproducible estimate")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: stats requires a dice expression, e.g. roll stats --monte-carlo 10000 3d6!\n")
+		os.Exit(1)
+	}
+	expression := strings.Join(flags.Args(), " ")
+
+	diceSet, err := dice.ParseDiceNotation(expandMacros(expression, opts.macros))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
+		os.Exit(1)
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			dice.SetSeed(*seed)
+		}
+	})
+
+	estimate, err := diceSet.Sample(*samples)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, line := range formatEstimate(expression, estimate) {
+		fmt.Println(line)
+	}
+}
+
+// formatEstimate formats a Monte Carlo estimate as a summary followed by a histogram of totals.
+// The mean is given with a 95% confidence interval, and rare totals are marked with "~".
+func formatEstimate(expression string, estimate dice.Estimate) []string {
+	lines := []string{
+		fmt.Sprintf("%s (%d samples):", strings.Join(strings.Fields(expression), " "), estimate.Samples),
+		fmt.Sprintf("Mean: %.2f ± %.2f (95%% confidence)", estimate.Mean, 1.96*estimate.StdErr),
+		fmt.Sprintf("Std dev: %.2f", estimate.StdDev),
+		fmt.Sprintf("Range seen: %d to %d", estimate.Min, estimate.Max),
+	}
+
+	totals := estimate.Totals()
+	mostCommon, labelWidth := 0, 0
+	for _, total := range totals {
+		mostCommon = max(mostCommon, estimate.Counts[total])
+		labelWidth = max(labelWidth, len(strconv.Itoa(total)))
+	}
+
+	rare := false
+	for _, total := range totals {
+		count := estimate.Counts[total]
+		bar := strings.Repeat("#", max(1, count*histogramWidth/mostCommon))
+		marker := " "
+		if count < reliableSamples {
+			marker = "~"
+			rare = true
+		}
+		percent := 100 * float64(count) / float64(estimate.Samples)
+		lines = append(lines, fmt.Sprintf("%*d: %s%5.1f%% %s", labelWidth, total, marker, percent, bar))
+	}
+	if rare {
+		lines = append(lines, fmt.Sprintf("~ seen fewer than %d times; more samples give a better estimate", reliableSamples))
+	}
+	return lines
+}
+
+// describeContest describes the outcome of a contest between the totals of side A and side B.
+func describeContest(totalA, totalB int) string {
+	switch {
+	case totalA > totalB:
+		return fmt.Sprintf("Side A wins by %d", totalA-totalB)
+	case totalB > totalA:
+		return fmt.Sprintf("Side B wins by %d", totalB-totalA)
+	default:
+		return fmt.Sprintf("Tie at %d", totalA)
+	}
+}
+
+// initiativeEntry is one combatant's initiative roll.
+type initiativeEntry struct {
+	label string // The combatant's name, or the expression if it has none
+	total int    // The total rolled
+	d20   int    // The first kept d20 rolled, which breaks ties, or 0 if there is none
+}
+
+// runInitiative rolls initiative for each combatant, given as "Name: EXPR", and prints them in
+// turn order, highest total first. All the expressions are checked before any are rolled.
+func runInitiative(args []string, opts outputOptions) error {
+	if len(args) == 0 {
+		return fmt.Errorf("init requires one or more combatants, e.g. roll init 'Goblin: 1d20+2' 'Hero: 1d20+5'")
+	}
+
+	var combatants []batchEntry
+	for _, arg := range args {
+		entries, err := parseBatch(arg)
+		if err != nil {
+			return fmt.Errorf("combatant '%s': %v", arg, err)
+		}
+		combatants = append(combatants, entries...)
+	}
+
+	diceSets := make([]dice.DiceSet, len(combatants))
+	for i, combatant := range combatants {
+		diceSet, err := dice.ParseDiceNotationWithVariables(expandMacros(combatant.expression, opts.macros), opts.variables)
+		if err != nil {
+			return fmt.Errorf("invalid dice notation '%s': %v", combatant.expression, err)
+		}
+		diceSets[i] = diceSet
+	}
+
+	seedRoll(opts)
+	entries := make([]initiativeEntry, len(combatants))
+	results := make([]dice.RollResult, len(combatants))
+	for i, combatant := range combatants {
+		result := diceSets[i].Roll()
+		if result.Err != nil {
+			return result.Err
+		}
+		if result.Overflow {
+			return fmt.Errorf("the total of '%s' is too large to compute", combatant.expression)
+		}
+		if combatant.label != "" {
+			result.Label = combatant.label
+		}
+		results[i] = result
+		entries[i] = initiativeEntry{label: combatant.label, total: result.Total, d20: firstKeptD20(result)}
+		if entries[i].label == "" {
+			entries[i].label = combatant.expression
+		}
+	}
+
+	rolledAt := time.Now()
+	printTimestamp(rolledAt, opts)
+	for i, combatant := range combatants {
+		unlabelled, _ := dice.SplitLabel(combatant.expression)
+		recordRoll(rolledAt, unlabelled, results[i], opts)
+	}
+	orderInitiative(entries)
+	if opts.quiet {
+		for _, entry := range entries {
+			fmt.Println(entry.label)
+		}
+	} else {
+		for _, line := range formatInitiative(entries) {
+			fmt.Println(line)
+		}
+	}
+	printSeed(opts)
+	return nil
+}
+
+// firstKeptD20 returns the value of the first kept d20 of a roll, or 0 if there is none.
+func firstKeptD20(result dice.RollResult) int {
+	for _, roll := range result.DieRolls {
+		if !roll.Dropped && roll.Die.Sides == 20 && roll.Die.Offset == 0 && roll.FancyValue == "" {
+			return roll.Result
+		}
+	}
+	return 0
+}
+
+// orderInitiative sorts combatants into turn order: highest total first, then highest d20.
+// Combatants still tied keep the order they were given in.
+func orderInitiative(entries []initiativeEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].total != entries[j].total {
+			return entries[i].total > entries[j].total
+		}
+		return entries[i].d20 > entries[j].d20
+	})
+}
+
+// formatInitiative lists combatants already in turn order, e.g. "1. Hero: 19". Ties broken by
+// the d20 are noted, and combatants still tied are flagged for a reroll.
+func formatInitiative(entries []initiativeEntry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = fmt.Sprintf("%d. %s: %d", i+1, entry.label, entry.total)
+
+		var tied []string
+		brokenByD20 := false
+		for j, other := range entries {
+			if j == i || other.total != entry.total {
+				continue
+			}
+			if other.d20 == entry.d20 {
+				tied = append(tied, other.label)
+			} else {
+				brokenByD20 = true
+			}
+		}
+		switch {
+		case len(tied) > 0:
+			lines[i] += fmt.Sprintf(" (tied with %s, reroll)", strings.Join(tied, ", "))
+		case brokenByD20:
+			lines[i] += fmt.Sprintf(" (tie broken by d20: %d)", entry.d20)
+		}
+	}
+	return lines
+}
+
+// rollExpression parses and rolls a dice expression and prints the results. A label that is not
+// empty replaces any given in the expression itself, as in "1d20+5 #attack".
+// It returns an error if the expression cannot be parsed.
+func rollExpression(expression, label string, opts outputOptions) error {
+	expression = expandMacros(expression, opts.macros)
+
+	// Attack rolls with automatic critical damage have their own notation.
+	if dice.IsCritNotation(expression) {
+		critRoll, err := dice.ParseCritNotationWithVariables(expression, opts.variables)
+		if err != nil {
+			return err
+		}
+		if opts.showRange {
+			return fmt.Errorf("--range does not support crit notation")
+		}
+		if opts.json {
+			return fmt.Errorf("--json does not support crit notation")
+		}
+		if opts.template != nil {
+			return fmt.Errorf("--template does not support crit notation")
+		}
+		seedRoll(opts)
+		result := critRoll.Roll()
+		if result.Attack.Err != nil {
+			return result.Attack.Err
+		}
+		if result.Damage.Err != nil {
+			return result.Damage.Err
+		}
+		if result.Attack.Overflow || result.Damage.Overflow {
+			return fmt.Errorf("the total is too large to compute")
+		}
+		rolledAt := time.Now()
+		printCritResult(critRoll, result, rolledAt, opts)
+		printSeed(opts)
+		logRoll(rolledAt, formatCompactCrit(critRoll, result, opts), opts)
+		recordCrit(rolledAt, critRoll, result, opts)
+		return nil
+	}
+
+	// Parse the dice notation.
+	diceSet, err := dice.ParseDiceNotationWithVariables(expression, opts.variables)
+	if err != nil {
+		return err
+	}
+	for _, warning := range diceSet.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	// Report the possible totals without rolling if requested.
+	if opts.showRange {
+		low, high := diceSet.Range()
+		if opts.quiet {
+			fmt.Println(low, high)
+			return nil
+		}
+		fmt.Printf("%s: min %d, max %d\n", strings.Join(strings.Fields(expression), " "), low, high)
+		return nil
+	}
+
+	// Roll the dice and print the results.
+	seedRoll(opts)
+	result := diceSet.Roll()
+	if result.Err != nil {
+		return result.Err
+	}
+	if result.Overflow {
+		return fmt.Errorf("the total is too large to compute")
+	}
+	if label != "" {
+		result.Label = label
+	}
+	rolledAt := time.Now()
+	printTimestamp(rolledAt, opts)
+	printRollResult(expression, result, rolledAt, opts)
+	printSeed(opts)
+	unlabelled, _ := dice.SplitLabel(expression)
+	logRoll(rolledAt, formatCompactLine(unlabelled, result, displayDieRolls(result.DieRolls, opts)), opts)
+	recordRoll(rolledAt, unlabelled, result, opts)
+	return nil
+}
+
+// logRoll appends a roll, already formatted on one line, to the --log transcript with the time it
+// was rolled and any seed needed to reproduce it. The file is synced after every roll so that a
+// crash loses nothing.
+func logRoll(rolledAt time.Time, line string, opts outputOptions) {
+	if opts.transcript == nil {
+		return
+	}
+	entry := formatTimestamp(rolledAt, opts.utc) + " " + line
+	if seed, isSeeded := dice.Seed(); isSeeded {
+		entry += fmt.Sprintf(" (seed %d)", seed)
+	}
+	if err := appendLine(opts.transcript, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write to the transcript: %v\n", err)
+	}
+}
+
+// jsonLine is a roll as recorded by --jsonl: the fields of --json with the time of the roll and
+// any seed needed to reproduce it. Its time is always given, and hides that of the jsonRoll.
+type jsonLine struct {
+	Time string  `json:"time"`
+	Seed *uint64 `json:"seed,omitempty,string"` // A string, as seeds go beyond the integers JavaScript reads exactly
+	jsonRoll
+}
+
+// jsonCritLine is an attack and its damage, rolled with crit notation, as recorded by --jsonl.
+type jsonCritLine struct {
+	Time       string   `json:"time"`
+	Seed       *uint64  `json:"seed,omitempty,string"`
+	Expression string   `json:"expression"`
+	Critical   bool     `json:"critical"`
+	Attack     jsonRoll `json:"attack"`
+	Damage     jsonRoll `json:"damage"`
+}
+
+// recordRoll appends a roll to the --jsonl history as one line of JSON, with its dice in the order
+// they were rolled. Like the transcript, the file is synced after every roll.
+func recordRoll(rolledAt time.Time, expression string, result dice.RollResult, opts outputOptions) {
+	if opts.history == nil {
+		return
+	}
+	record := jsonLine{Time: formatTimestamp(rolledAt, opts.utc), jsonRoll: newJSONRoll(expression, result, result.DieRolls)}
+	if seed, isSeeded := dice.Seed(); isSeeded {
+		record.Seed = &seed
+	}
+	writeRecord(record, opts)
+}
+
+// recordCrit appends an attack and its damage to the --jsonl history as one line of JSON.
+func recordCrit(rolledAt time.Time, critRoll dice.CritRoll, result dice.CritResult, opts outputOptions) {
+	if opts.history == nil {
+		return
+	}
+	record := jsonCritLine{
+		Time:       formatTimestamp(rolledAt, opts.utc),
+		Expression: critRoll.AttackNotation + " crit " + critRoll.DamageNotation,
+		Critical:   result.Critical,
+		Attack:     newJSONRoll(critRoll.AttackNotation, result.Attack, result.Attack.DieRolls),
+		Damage:     newJSONRoll(critRoll.DamageNotation, result.Damage, result.Damage.DieRolls),
+	}
+	if seed, isSeeded := dice.Seed(); isSeeded {
+		record.Seed = &seed
+	}
+	writeRecord(record, opts)
+}
+
+// writeRecord encodes a --jsonl record and appends it to the history.
+func writeRecord(record any, opts outputOptions) {
+	line, err := encodeJSON(record)
+	if err == nil {
+		err = appendLine(opts.history, line)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write to the JSON lines file: %v\n", err)
+	}
+}
+
+// appendLine writes a line to a --log or --jsonl file, syncing it to disk at once so that a crash
+// loses nothing.
+func appendLine(w io.Writer, line string) error {
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	if file, isFile := w.(*os.File); isFile {
+		return file.Sync()
+	}
+	return nil
+}
+
+// openTranscript opens a --log or --jsonl file for appending, creating it if need be.
+func openTranscript(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// seedRoll seeds the generator afresh before a roll when --show-seed needs each roll to be
+// reproducible on its own.
+func seedRoll(opts outputOptions) {
+	if opts.reseed {
+		dice.Reseed()
+	}
+}
+
+// printSeed prints the generator and seed of the roll just made if --show-seed was given.
+func printSeed(opts outputOptions) {
+	if opts.showSeed {
+		fmt.Println(formatSeed(opts))
+	}
+}
+
+// formatSeed describes the generator the dice are drawn from and, if it is seeded, how to
+// reproduce the rolls. Without --seed or --show-seed, rolls come from Go's global generator,
+// which the runtime seeds randomly and cannot report.
+func formatSeed(opts outputOptions) string {
+	seed, isSeeded := dice.Seed()
+	if !isSeeded {
+		return "Generator: ChaCha8, seeded randomly by the Go runtime; its seed cannot be shown (use --show-seed or --seed)"
+	}
+	origin := "from --seed"
+	if opts.reseed {
+		origin = "crypto-random"
+	}
+	return fmt.Sprintf("Generator: %s, seed %d (%s); reproduce with --seed=%d", dice.GeneratorName(), seed, origin, seed)
+}
+
+// formatTimestamp formats the time of a roll as ISO-8601, optionally converted to UTC.
+func formatTimestamp(rolledAt time.Time, utc bool) string {
+	if utc {
+		rolledAt = rolledAt.UTC()
+	}
+	return rolledAt.Format(time.RFC3339)
+}
+
+// printTimestamp prints the time of a roll if requested. Single-line output continues on the same
+// line as the timestamp; fuller output starts on the line below. --json and --template output
+// carries the time as a field instead, so that it stays machine-readable.
+func printTimestamp(rolledAt time.Time, opts outputOptions) {
+	if !opts.timestamp || opts.json || opts.template != nil {
+		return
+	}
+	if opts.quiet || opts.compact {
+		fmt.Printf("%s ", formatTimestamp(rolledAt, opts.utc))
+		return
+	}
+	fmt.Println(formatTimestamp(rolledAt, opts.utc))
+}
+
+// printCritResult prints an attack roll and its damage, noting whether the attack was a critical hit.
+func printCritResult(critRoll dice.CritRoll, result dice.CritResult, rolledAt time.Time, opts outputOptions) {
+	attack, damage := critRoll.AttackNotation, critRoll.DamageNotation
+
+	if opts.quiet {
+		fmt.Println(result.Attack.Total, result.Damage.Total)
+		return
+	}
+
+	if opts.compact {
+		fmt.Println(formatCompactCrit(critRoll, result, opts))
+		return
+	}
+
+	fmt.Printf("Attack (%s):\n", attack)
+	printRollResult(attack, result.Attack, rolledAt, opts)
+	if result.Critical {
+		fmt.Println("Critical hit! Damage dice are doubled.")
+	}
+	fmt.Printf("Damage (%s):\n", damage)
+	printRollResult(damage, result.Damage, rolledAt, opts)
+}
+
+// formatCompactCrit formats an attack and its damage on a single line for --compact.
+func formatCompactCrit(critRoll dice.CritRoll, result dice.CritResult, opts outputOptions) string {
+	attack, damage := critRoll.AttackNotation, critRoll.DamageNotation
+	line := "Attack " + formatCompactResult(attack, displayDieRolls(result.Attack.DieRolls, opts), result.Attack.Modifier, result.Attack.Total)
+	if result.Critical {
+		line += " CRITICAL!"
+	}
+	return line + "; Damage " + formatCompactResult(damage, displayDieRolls(result.Damage.DieRolls, opts), result.Damage.Modifier, result.Damage.Total)
+}
+
+// displayDieRolls returns the die rolls ready for printing: sorted as requested and, with --percentile,
+// with d100 results shown as percentile readings. The original rolls are returned if neither applies.
+func displayDieRolls(dieRolls []dice.DieRoll, opts outputOptions) []dice.DieRoll {
+	if opts.percentile {
+		dieRolls = percentileDieRolls(dieRolls)
+	}
+	if !opts.ascending && !opts.descending {
+		return dieRolls
+	}
+	return dice.RollResult{DieRolls: dieRolls}.SortedBy(opts.descending, opts.sortBy).DieRolls
+}
+
+// percentileDieRolls returns a copy of the die rolls in which each d100 shows its result the way a
+// pair of percentile dice is read, tens then units, so 7 reads "07" and 100 reads "00". The score is
+// unchanged. A compounded d100, whose result can exceed 100, keeps its numeric result.
+func percentileDieRolls(dieRolls []dice.DieRoll) []dice.DieRoll {
+	display := make([]dice.DieRoll, len(dieRolls))
+	copy(display, dieRolls)
+	for i, roll := range display {
+		if strings.EqualFold(roll.Type, "d100") && roll.FancyValue == "" && roll.Result >= 1 && roll.Result <= 100 {
+			display[i].FancyValue = formatPercentile(roll.Result)
+		}
+	}
+	return display
+}
+
+// formatPercentile formats a d100 result as a two-digit percentile reading from "01" to "99", with 100 as "00".
+func formatPercentile(result int) string {
+	return fmt.Sprintf("%02d", result%100)
+}
+
+// formatCompactLine formats a whole roll on a single line for --compact, starting with any label,
+// e.g. "attack: 1d20+5: 14+5 = 19".
+func formatCompactLine(expression string, result dice.RollResult, dieRolls []dice.DieRoll) string {
+	var line string
+	if result.Label != "" {
+		line = result.Label + ": "
+	}
+	if result.RollUnder != nil {
+		return line + fmt.Sprintf("%s: %s", strings.Join(strings.Fields(expression), " "), result.RollUnder)
+	}
+	line += formatCompactResult(expression, dieRolls, result.Modifier, result.Total)
+	if result.Bonus != nil {
+		line += fmt.Sprintf(" (%s)", result.Bonus)
+	}
+	if result.KeptIf != nil {
+		line += fmt.Sprintf(" (%s)", result.KeptIf)
+	}
+	if result.Symbols != nil {
+		line += fmt.Sprintf(" (%s)", result.Symbols)
+	}
+	if result.Scaled != nil {
+		line += fmt.Sprintf(" (%s)", result.Scaled)
+	}
+	if result.Clamped != nil {
+		line += fmt.Sprintf(" (%s)", result.Clamped)
+	}
+	return line
+}
+
+// numberWords spells out the small numbers used when narrating a roll.
+var numberWords = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen", "twenty",
+}
+
+// fancyDieNames are the everyday names of the built-in fancy dice when narrating a roll.
+var fancyDieNames = map[string]string{
+	"f2":  "coin",
+	"f4":  "suit die",
+	"f6":  "die",
+	"f7":  "day-of-the-week die",
+	"f12": "zodiac die",
+	"f13": "card-rank die",
+	"f52": "playing card",
+}
+
+// regularTypeRegex matches the type of a plain numbered die, such as "d6" or the exclusive "D6".
+var regularTypeRegex = regexp.MustCompile(`^[dD](\d+)$`)
+
+// spellNumber spells out numbers up to twenty and writes larger ones as digits.
+func spellNumber(n int) string {
+	if n >= 0 && n < len(numberWords) {
+		return numberWords[n]
+	}
+	return strconv.Itoa(n)
+}
+
+// dieName gives the everyday name of one die of a type, e.g. "six-sided die" or "coin".
+func dieName(dieType string) string {
+	if name, ok := fancyDieNames[dieType]; ok {
+		return name
+	}
+	if match := regularTypeRegex.FindStringSubmatch(dieType); match != nil {
+		sides, _ := strconv.Atoi(match[1])
+		if sides == 100 {
+			return "hundred-sided die"
+		}
+		return spellNumber(sides) + "-sided die"
+	}
+	switch {
+	case dieType == "d%%":
+		return "percentile die"
+	case strings.HasPrefix(dieType, "d{"):
+		return "custom die"
+	}
+	return dieType + " die"
+}
+
+// countDice says how many dice of a type were rolled, e.g. "a coin", "an eight-sided die" or "three six-sided dice".
+func countDice(count int, dieType string) string {
+	name := dieName(dieType)
+	if count != 1 {
+		if strings.HasSuffix(name, "die") {
+			name = strings.TrimSuffix(name, "die") + "dice"
+		} else {
+			name += "s"
+		}
+		return spellNumber(count) + " " + name
+	}
+	return article(name) + " " + name
+}
+
+// article chooses "a" or "an" by how a name is said aloud rather than how it is spelled,
+// so "a one-sided die" but "an eight-sided die", "an eleven-sided die" and "an 80-sided die".
+func article(name string) string {
+	if strings.HasPrefix(name, "one") {
+		return "a"
+	}
+	if digits := len(name) - len(strings.TrimLeft(name, "0123456789")); digits > 0 {
+		// Numbers written as digits are said from their leading group of up to three digits,
+		// so 8, 80 and 800 start with "eight", and 11 and 18000 with "eleven" and "eighteen".
+		if name[0] == '8' || digits%3 == 2 && (strings.HasPrefix(name, "11") || strings.HasPrefix(name, "18")) {
+			return "an"
+		}
+		return "a"
+	}
+	if strings.ContainsRune("aeiou", rune(name[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// joinWords lists items as in a sentence: "4", "4 and 2", or "4, 2, and 6".
+func joinWords(items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
+
+// capitalize upper-cases the first letter of a sentence.
+func capitalize(text string) string {
+	if text == "" {
+		return text
+	}
+	return strings.ToUpper(text[:1]) + text[1:]
+}
+
+// formatNarration describes a roll in plain English for new players, e.g. "You rolled three
+// six-sided dice getting 4, 2, and 6 for a total of 12." Dice are described a type at a time,
+// fancy dice by the face they show, and modifiers, dropped dice and other details are spelled out.
+func formatNarration(result dice.RollResult, dieRolls []dice.DieRoll, opts outputOptions) string {
+	// Gather the dice by type, in the order each type first appears.
+	var types []string
+	rolls := make(map[string][]dice.DieRoll)
+	for _, roll := range dieRolls {
+		if _, seen := rolls[roll.Type]; !seen {
+			types = append(types, roll.Type)
+		}
+		rolls[roll.Type] = append(rolls[roll.Type], roll)
+	}
+
+	var phrases, dropped []string
+	for _, dieType := range types {
+		var values []string
+		count, extra, fancy := 0, 0, false
+		for _, roll := range rolls[dieType] {
+			value := strconv.Itoa(roll.Result)
+			if roll.FancyValue != "" {
+				value, fancy = roll.FancyValue, true
+			}
+			values = append(values, value)
+			if roll.Dropped {
+				dropped = append(dropped, value)
+			}
+			if roll.Exploded {
+				extra++
+			} else {
+				count++
+			}
+		}
+		verb := "getting"
+		if fancy {
+			verb = "showing"
+		}
+		phrase := fmt.Sprintf("%s %s %s", countDice(count, dieType), verb, joinWords(values))
+		switch {
+		case extra == 1:
+			phrase += " (one of them an extra roll from exploding)"
+		case extra > 1:
+			phrase += fmt.Sprintf(" (%s of them extra rolls from exploding)", spellNumber(extra))
+		}
+		phrases = append(phrases, phrase)
+	}
+
+	var sentence strings.Builder
+	if result.Label != "" {
+		sentence.WriteString("For " + result.Label + ", you")
+	} else {
+		sentence.WriteString("You")
+	}
+	if len(phrases) == 0 {
+		sentence.WriteString(" rolled no dice")
+	} else {
+		sentence.WriteString(" rolled " + joinWords(phrases))
+	}
+	switch {
+	case len(dropped) > 1 && len(dropped) == len(dieRolls):
+		sentence.WriteString(", dropping them all")
+	case len(dropped) > 0:
+		sentence.WriteString(", dropping the " + joinWords(dropped))
+	}
+	switch {
+	case result.Modifier > 0:
+		sentence.WriteString(", plus " + formatNumber(result.Modifier, opts))
+	case result.Modifier < 0:
+		sentence.WriteString(", minus " + formatNumber(-result.Modifier, opts))
+	}
+
+	var details []string
+	switch {
+	case result.RollUnder != nil:
+		details = append(details, result.RollUnder.String())
+	case result.Successes == 1:
+		sentence.WriteString(" for one success")
+	case result.Successes > 1:
+		sentence.WriteString(" for " + spellNumber(result.Successes) + " successes")
+	default:
+		sentence.WriteString(" for a total of " + formatNumber(result.Total, opts))
+	}
+	sentence.WriteString(".")
+
+	if result.Bonus != nil {
+		details = append(details, result.Bonus.String())
+	}
+	if result.KeptIf != nil {
+		details = append(details, result.KeptIf.String())
+	}
+	if result.Symbols != nil {
+		details = append(details, "That leaves "+result.Symbols.String())
+	}
+	if result.Scaled != nil {
+		details = append(details, "Worked out as "+result.Scaled.String())
+	}
+	if result.Clamped != nil {
+		details = append(details, result.Clamped.String())
+	}
+	for _, detail := range details {
+		sentence.WriteString(" " + capitalize(detail) + ".")
+	}
+	return sentence.String()
+}
+
+// parseSortKey parses the value of the --sort flag.
+func parseSortKey(name string) (dice.SortKey, error) {
+	switch strings.ToLower(name) {
+	case "value":
+		return dice.SortByValue, nil
+	case "roll":
+		return dice.SortByRoll, nil
+	default:
+		return dice.SortByValue, fmt.Errorf("--sort must be \"value\" or \"roll\", got %q", name)
+	}
+}
+
+// printRollResult sorts and prints the result of rolling an expression in the requested format.
+// A label given with the expression, as in "1d20+5 #attack", starts the output. With --timestamp,
+// the time it was rolled is a field of --json and --template output.
+func printRollResult(expression string, result dice.RollResult, rolledAt time.Time, opts outputOptions) {
+	dieRolls := displayDieRolls(result.DieRolls, opts)
+	expression, _ = dice.SplitLabel(expression)
+
+	if opts.template != nil || opts.json {
+		roll := newJSONRoll(expression, result, dieRolls)
+		if opts.timestamp {
+			roll.Time = formatTimestamp(rolledAt, opts.utc)
+		}
+		var line string
+		var err error
+		if opts.template != nil {
+			line, err = formatTemplateResult(opts.template, roll)
+		} else {
+			line, err = encodeJSON(roll)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		fmt.Println(line)
+		return
+	}
+
+	if opts.quiet {
+		if result.Label != "" {
+			fmt.Printf("%s: ", result.Label)
+		}
+		fmt.Println(formatNumber(result.Total, opts))
+		return
+	}
+
+	if opts.narrate {
+		fmt.Println(formatNarration(result, dieRolls, opts))
+		return
+	}
+
+	if opts.compact {
+		fmt.Println(formatCompactLine(expression, result, dieRolls))
+		return
+	}
+
+	if result.Label != "" {
+		fmt.Printf("%s (%s):\n", result.Label, strings.Join(strings.Fields(expression), " "))
+	}
+
+	if opts.faces {
+		for _, line := range formatFaceHistogram(dieRolls) {
+			fmt.Println(line)
+		}
+		if !opts.noTotal {
+			fmt.Println(formatTotal(result, opts))
+		}
+		return
+	}
+
+	if opts.grouped {
+		for _, line := range formatGroupedResult(dieRolls) {
+			fmt.Println(line)
+		}
+		if result.Modifier != 0 {
+			fmt.Printf("Modifier: %+d\n", result.Modifier)
+		}
+		if !opts.noTotal {
+			fmt.Println(formatTotal(result, opts))
+		}
+		return
+	}
+
+	for _, line := range formatAlternatives(result.Alternatives) {
+		fmt.Println(line)
+	}
+
+	var subtotals []dice.GroupTotal
+	if opts.subtotals {
+		subtotals = result.GroupTotals()
+	}
+	if result.RollUnder != nil {
+		// A roll-under check reports its graded outcome instead of a count of successes.
+		printCommandLineResults(dieRolls, subtotals, result.Modifier)
+		fmt.Println(result.RollUnder)
+		return
+	}
+	printCommandLineResults(dieRolls, subtotals, result.Modifier)
+	if result.Bonus != nil {
+		bonus := result.Bonus.String()
+		fmt.Println(strings.ToUpper(bonus[:1]) + bonus[1:])
+	}
+	if result.KeptIf != nil {
+		kept := result.KeptIf.String()
+		fmt.Println(strings.ToUpper(kept[:1]) + kept[1:])
+	}
+	if result.Symbols != nil {
+		fmt.Printf("Symbols: %s\n", result.Symbols)
+	}
+	if !opts.noTotal {
+		fmt.Println(formatTotal(result, opts))
+	}
+}
+
+// jsonRoll is the JSON form of a roll printed by --json.
+type jsonRoll struct {
+	Time       string          `json:"time,omitempty"` // When it was rolled, with --timestamp
+	Expression string          `json:"expression"`
+	Label      string          `json:"label,omitempty"`
+	Dice       []jsonDie       `json:"dice"`
+	Modifier   int             `json:"modifier"`
+	Total      int             `json:"total"`
+	Successes  int             `json:"successes,omitempty"`
+	Outcome    string          `json:"outcome,omitempty"`
+	Unscaled   *int            `json:"unscaled,omitempty"`
+	Unclamped  *int            `json:"unclamped,omitempty"`
+	Bonus      *bool           `json:"bonus,omitempty"`
+	Kept       *int            `json:"kept,omitempty"`
+	Symbols    *map[string]int `json:"symbols,omitempty"`
+}
+
+// jsonDie is the JSON form of a single die roll.
+type jsonDie struct {
+	Type       string `json:"type"`
+	Result     int    `json:"result"`
+	Score      int    `json:"score"`
+	Face       string `json:"face,omitempty"`
+	Dropped    bool   `json:"dropped,omitempty"`
+	Exploded   bool   `json:"exploded,omitempty"`
+	Penetrated bool   `json:"penetrated,omitempty"`
+	Success    bool   `json:"success,omitempty"`
+	Tens       *int   `json:"tens,omitempty"`
+	Units      *int   `json:"units,omitempty"`
+	Rerolls    int    `json:"rerolls,omitempty"`
+	Duplicate  bool   `json:"duplicate,omitempty"`
+	Rolls      []int  `json:"rolls,omitempty"`
+}
+
+// newJSONRoll builds the JSON form of a roll, listing the dice in the order given. It is also
+// the data given to a --template.
+func newJSONRoll(expression string, result dice.RollResult, dieRolls []dice.DieRoll) jsonRoll {
+	roll := jsonRoll{
+		Expression: strings.Join(strings.Fields(expression), " "),
+		Label:      result.Label,
+		Dice:       make([]jsonDie, len(dieRolls)),
+		Modifier:   result.Modifier,
+		Total:      result.Total,
+		Successes:  result.Successes,
+	}
+	for i, die := range dieRolls {
+		roll.Dice[i] = jsonDie{
+			Type:       die.Type,
+			Result:     die.Result,
+			Score:      die.Score,
+			Face:       die.FancyValue,
+			Dropped:    die.Dropped,
+			Exploded:   die.Exploded,
+			Penetrated: die.Penetrated,
+			Success:    die.Success,
+			Rerolls:    die.Rerolls,
+			Duplicate:  die.Duplicate,
+		}
+		if die.Die.Percentile {
+			roll.Dice[i].Tens, roll.Dice[i].Units = &die.Tens, &die.Units
+		}
+		if len(die.Rolls) > 1 {
+			// A compounding die lists the chain of rolls summed into its result.
+			roll.Dice[i].Rolls = die.Rolls
+		}
+	}
+	if result.RollUnder != nil {
+		roll.Outcome = result.RollUnder.Degree.String()
+	}
+	if result.Scaled != nil {
+		roll.Unscaled = &result.Scaled.Unscaled
+	}
+	if result.Clamped != nil {
+		roll.Unclamped = &result.Clamped.Unclamped
+	}
+	if result.Bonus != nil {
+		roll.Bonus = &result.Bonus.Triggered
+	}
+	if result.KeptIf != nil {
+		roll.Kept = &result.KeptIf.Kept
+	}
+	if result.Symbols != nil {
+		// Every symbol cancelled still gives an empty object, to tell it apart from no symbol dice.
+		symbols := make(map[string]int, len(result.Symbols.Net))
+		for _, count := range result.Symbols.Net {
+			symbols[count.Symbol] = count.Count
+		}
+		roll.Symbols = &symbols
+	}
+	return roll
+}
+
+// encodeJSON formats a roll as a single line of JSON.
+func encodeJSON(roll any) (string, error) {
+	// Keep comparisons such as ">=" readable rather than escaping them for HTML.
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(roll); err != nil {
+		return "", fmt.Errorf("cannot format the roll as JSON: %v", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// parseOutputTemplate parses a --template. It is tried on an empty roll of one die straight away,
+// so that a misspelt field is reported before any dice are rolled rather than after each roll.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --template: %v", err)
+	}
+	if err := tmpl.Execute(io.Discard, jsonRoll{Dice: make([]jsonDie, 1)}); err != nil {
+		return nil, fmt.Errorf("invalid --template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// formatTemplateResult formats a roll with a --template, which is given the same fields as --json
+// under their Go names, e.g. {{.Total}} or {{range .Dice}}{{.Result}} {{end}}. A newline is added
+// unless the template ends with one.
+func formatTemplateResult(tmpl *template.Template, roll jsonRoll) (string, error) {
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, roll); err != nil {
+		return "", fmt.Errorf("cannot format the roll with --template: %v", err)
 	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
 
-	// Handle help flag.
-	if *showHelp {
-		fmt.Printf("Usage: %s [OPTIONS] [DICE_NOTATION]\n\n", os.Args[0])
-		fmt.Println("Examples:")
-		fmt.Println("  roll 3d6")
-		fmt.Println("  roll --ascending 2d10 d6")
-		fmt.Println("  roll --fancy='*.dice' 2f6")
-		fmt.Println("  roll --interactive")
-		fmt.Println()
-		fmt.Println(info.GetCheatsheetContent())
-		os.Exit(0)
+// formatTotal formats the Total line, noting how the total was multiplied or divided and
+// the unclamped total if a clamp changed it.
+func formatTotal(result dice.RollResult, opts outputOptions) string {
+	line := fmt.Sprintf("Total: %s", formatNumber(result.Total, opts))
+	if result.Scaled != nil {
+		line += fmt.Sprintf(" (%s)", result.Scaled)
+	}
+	if result.Clamped != nil {
+		line += fmt.Sprintf(" (%s)", result.Clamped)
 	}
+	return line
+}
 
-	// Load custom fancy dice files if specified.
-	if *fancyFiles != "" {
-		err := dice.LoadCustomFancyDice(*fancyFiles)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading fancy dice files: %v\n", err)
-			os.Exit(1)
+// formatNumber formats a total, grouping its digits in thousands with --group-digits.
+func formatNumber(n int, opts outputOptions) string {
+	if opts.groupDigits {
+		return dice.GroupDigits(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// formatAlternatives lists the total of every sub-expression of a best(...) or worst(...)
+// selection, marking the ones that were chosen.
+func formatAlternatives(alternatives []dice.Alternative) []string {
+	lines := make([]string, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		line := fmt.Sprintf("Option %s: %d", alternative.Notation, alternative.Total)
+		if alternative.Chosen {
+			line += " (chosen)"
 		}
+		lines = append(lines, line)
 	}
+	return lines
+}
 
-	// Get remaining arguments (dice expressions).
-	args := flag.Args()
+// formatGroupedResult formats the rolls as one line per die type, in order of first appearance,
+// e.g. "10d6: [3, 5, 1, ...] = 35". Fancy dice list their face names, and dropped dice are listed
+// after the subtotal of the kept ones.
+func formatGroupedResult(dieRolls []dice.DieRoll) []string {
+	var types []string
+	counts := make(map[string]int)
+	subtotals := make(map[string]int)
+	kept := make(map[string][]string)
+	dropped := make(map[string][]string)
 
-	// Handle interactive mode.
-	if *interactive {
-		runInteractive(*ascending, *descending)
-		return
+	for _, roll := range dieRolls {
+		if _, seen := counts[roll.Type]; !seen {
+			types = append(types, roll.Type)
+		}
+		counts[roll.Type]++
+
+		value := strconv.Itoa(roll.Result)
+		if roll.FancyValue != "" {
+			value = roll.FancyValue
+		}
+		if roll.Dropped {
+			dropped[roll.Type] = append(dropped[roll.Type], value)
+			continue
+		}
+		kept[roll.Type] = append(kept[roll.Type], value)
+		subtotals[roll.Type] += roll.Score
 	}
 
-	// If command line arguments are provided, run in command line mode.
-	if len(args) > 0 {
-		runCommandLine(args, *ascending, *descending)
-		return
+	lines := make([]string, 0, len(types))
+	for _, dieType := range types {
+		line := fmt.Sprintf("%d%s: [%s] = %d", counts[dieType], dieType, strings.Join(kept[dieType], ", "), subtotals[dieType])
+		if len(dropped[dieType]) > 0 {
+			line += fmt.Sprintf(" (dropped: %s)", strings.Join(dropped[dieType], ", "))
+		}
+		lines = append(lines, line)
 	}
+	return lines
+}
 
-	// Otherwise, run the GUI application.
-	runGUI()
+// formatFaceHistogram counts how many times each face came up, grouped by die type in order of
+// first appearance. Regular dice list every face, including unrolled ones, from 1 (or 0 for a zero-based
+// die) up to their highest.
+func formatFaceHistogram(dieRolls []dice.DieRoll) []string {
+	var types []string
+	counts := make(map[string]map[int]int)
+	labels := make(map[string]map[int]string)
+	sides := make(map[string]int)
+	offsets := make(map[string]int)
+
+	for _, roll := range dieRolls {
+		if _, seen := counts[roll.Type]; !seen {
+			types = append(types, roll.Type)
+			counts[roll.Type] = make(map[int]int)
+			labels[roll.Type] = make(map[int]string)
+			if roll.FancyValue == "" {
+				sides[roll.Type] = roll.Die.Sides
+				offsets[roll.Type] = roll.Die.Offset
+			}
+		}
+		counts[roll.Type][roll.Result]++
+		labels[roll.Type][roll.Result] = roll.FancyValue
+	}
+
+	var lines []string
+	for _, dieType := range types {
+		// Include unrolled faces of regular dice so the histogram is complete.
+		for face := 1 + offsets[dieType]; face <= sides[dieType]+offsets[dieType]; face++ {
+			if _, rolled := counts[dieType][face]; !rolled {
+				counts[dieType][face] = 0
+			}
+		}
+
+		results := make([]int, 0, len(counts[dieType]))
+		dice := 0
+		for result, count := range counts[dieType] {
+			results = append(results, result)
+			dice += count
+		}
+		sort.Ints(results)
+
+		lines = append(lines, fmt.Sprintf("%s (%d rolled):", dieType, dice))
+		for _, result := range results {
+			label := labels[dieType][result]
+			if label == "" {
+				label = strconv.Itoa(result)
+			}
+			lines = append(lines, fmt.Sprintf("  %s: %d", label, counts[dieType][result]))
+		}
+	}
+	return lines
 }
 
-// runCommandLine processes dice expressions from command line arguments.
-func runCommandLine(diceExpressions []string, ascending, descending bool) {
-	// Validate sorting flags.
-	if ascending && descending {
-		fmt.Fprintf(os.Stderr, "Error: Cannot specify both --ascending and --descending flags\n")
+// printCommandLineResults prints the dice roll results to stdout, followed by any group subtotals
+// and the modifier. The caller prints the total, which depends on the kind of roll.
+func printCommandLineResults(dieRolls []dice.DieRoll, subtotals []dice.GroupTotal, modifier int) {
+	for _, roll := range dieRolls {
+		// Annotate compounded chains, exploded dice and dropped dice, which do not count towards the total.
+		notes := ""
+		if len(roll.Rolls) > 1 {
+			chain := make([]string, len(roll.Rolls))
+			for i, value := range roll.Rolls {
+				chain[i] = strconv.Itoa(value)
+			}
+			notes += fmt.Sprintf(" (%s)", strings.Join(chain, "+"))
+		}
+		if roll.Penetrated {
+			// A penetrating die shows what it rolled before one was taken off.
+			notes += fmt.Sprintf(" (exploded, rolled %d - 1)", roll.Result+1)
+		} else if roll.Exploded {
+			notes += " (exploded)"
+		}
+		if roll.Dropped {
+			notes += " (dropped)"
+		}
+		if roll.Success {
+			notes += " (success)"
+		}
+		if roll.Rerolls == 1 {
+			notes += " (rerolled once)"
+		} else if roll.Rerolls > 1 {
+			notes += fmt.Sprintf(" (rerolled %d times)", roll.Rerolls)
+		}
+		if roll.Duplicate {
+			notes += " (duplicate kept)"
+		}
+
+		if roll.Die.Percentile {
+			// For percentile dice, show the tens and units d10s that make up the result.
+			fmt.Printf("%s: tens: %d, units: %d = %d%s\n", roll.Type, roll.Tens, roll.Units, roll.Result, notes)
+		} else if roll.FancyValue != "" {
+			// For fancy dice, show the fancy value.
+			fmt.Printf("%s: %s%s\n", roll.Type, roll.FancyValue, notes)
+		} else {
+			// For regular dice, show the numeric result.
+			fmt.Printf("%s: %d%s\n", roll.Type, roll.Result, notes)
+		}
+	}
+	for _, group := range subtotals {
+		fmt.Printf("%s subtotal: %d\n", group.Notation, group.Subtotal)
+	}
+	if modifier != 0 {
+		fmt.Printf("Modifier: %+d\n", modifier)
+	}
+}
+
+// formatCompactResult formats a roll as a single line, e.g. "3d6+2: 4+2+6+2 = 14".
+// Fancy dice are listed by face name and dropped dice are listed after the total.
+func formatCompactResult(expression string, dieRolls []dice.DieRoll, modifier, total int) string {
+	breakdown := dice.RollResult{DieRolls: dieRolls, Modifier: modifier, Total: total}.Breakdown()
+
+	// Negative scores carry their own sign, e.g. "3-1" rather than "3+-1".
+	sum := ""
+	for i, value := range compactValues(breakdown.Kept) {
+		if i > 0 && !strings.HasPrefix(value, "-") {
+			sum += "+"
+		}
+		sum += value
+	}
+	if breakdown.Modifier != 0 {
+		sum += fmt.Sprintf("%+d", breakdown.Modifier)
+	}
+
+	line := fmt.Sprintf("%s: %s = %d", strings.Join(strings.Fields(expression), " "), sum, breakdown.Total)
+	if dropped := compactValues(breakdown.Dropped); len(dropped) > 0 {
+		line += fmt.Sprintf(" (dropped: %s)", strings.Join(dropped, ", "))
+	}
+	return line
+}
+
+// compactValues lists each die by its face name, or its result if it has none.
+func compactValues(dieRolls []dice.DieRoll) []string {
+	values := make([]string, len(dieRolls))
+	for i, roll := range dieRolls {
+		values[i] = strconv.Itoa(roll.Result)
+		if roll.FancyValue != "" {
+			values[i] = roll.FancyValue
+		}
+	}
+	return values
+}
+
+// formatDiceList formats fancy dice as an aligned table of type, face count and faces with their scores.
+func formatDiceList(list []dice.FancyDie) string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tFACES\tVALUES")
+	for _, fancy := range list {
+		faces := make([]string, len(fancy.Faces))
+		for i, face := range fancy.Faces {
+			faces[i] = fmt.Sprintf("%s=%d", face.Name, face.Value)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", fancy.Type, len(fancy.Faces), strings.Join(faces, ", "))
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// subcommand is a command selected by the first argument, as in "roll stats 3d6".
+type subcommand struct {
+	name  string
+	args  string // The arguments the command takes, for the help and man page
+	usage string
+	run   func(args []string, opts outputOptions)
+}
+
+// subcommands returns the registry of commands recognised as the first argument, which drives
+// dispatch, the help, shell completion and the man page. Any other first argument is dice notation,
+// so "roll 3d6" still works as "roll roll 3d6".
+func subcommands() []subcommand {
+	return []subcommand{
+		{"roll", "EXPR...", "Roll dice notation, the same as giving it without a command", runRollCommand},
+		{"vs", "EXPR_A EXPR_B", "Roll two expressions against each other and report the winner and margin", runContested},
+		{"init", "NAME:EXPR...", "Roll initiative for each combatant and list them in order", exitOnError(runInitiative)},
+		{"commit", "EXPR", "Commit to a roll by printing a hash of a secret, for verifiably fair rolls", runCommit},
+		{"reveal", "SECRET EXPR", "Reveal a committed roll so that it can be checked", runReveal},
+		{"batch", "NAME", "Roll each expression of a named batch from the config file", exitOnError(runBatchCommand)},
+		{"stats", "[--monte-carlo N] [--seed N] EXPR", "Estimate the distribution of an expression's total", runStats},
+		{"list-dice", "", "List every fancy die, including custom ones, with its faces and scores", exitOnError(runListDiceCommand)},
+		{"completion", "bash|zsh|fish", "Print a shell completion script", exitOnError(runCompletionCommand)},
+		{"man", "", "Print the man page", exitOnError(runManCommand)},
+	}
+}
+
+// findSubcommand returns the command with the given name, or false if name is not a command.
+func findSubcommand(name string) (subcommand, bool) {
+	for _, command := range subcommands() {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// exitOnError adapts a command that can fail into one that prints the error and exits with status 1.
+func exitOnError(run func(args []string, opts outputOptions) error) func(args []string, opts outputOptions) {
+	return func(args []string, opts outputOptions) {
+		if err := run(args, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runRollCommand rolls the dice notation given to "roll roll".
+func runRollCommand(args []string, opts outputOptions) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: roll requires dice notation, e.g. roll roll 3d6\n")
 		os.Exit(1)
 	}
+	runCommandLine(args, opts)
+}
+
+// runBatchCommand rolls a named batch from the config file: roll batch NAME.
+func runBatchCommand(args []string, opts outputOptions) error {
+	if len(args) != 1 {
+		return fmt.Errorf("batch requires the name of a batch from the config file, e.g. roll batch combat")
+	}
+	return runBatch(args[0], opts)
+}
 
-	// Join all arguments into a single dice expression.
-	expression := strings.Join(diceExpressions, " ")
+// runListDiceCommand lists the fancy dice, including any custom ones loaded with --fancy.
+func runListDiceCommand(args []string, opts outputOptions) error {
+	if len(args) != 0 {
+		return fmt.Errorf("list-dice takes no arguments; load custom dice with --fancy")
+	}
+	fmt.Print(formatDiceList(dice.ListFancyDice()))
+	return nil
+}
 
-	// Parse the dice notation.
-	diceSet, err := dice.ParseDiceNotation(expression)
+// runCompletionCommand prints a completion script for a shell: roll completion bash.
+func runCompletionCommand(args []string, opts outputOptions) error {
+	if len(args) != 1 {
+		return fmt.Errorf("completion requires a shell, e.g. roll completion bash (or zsh, fish)")
+	}
+	script, err := formatCompletion(args[0], flag.CommandLine)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing dice notation '%s': %v\n", expression, err)
-		os.Exit(1)
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// runManCommand prints the man page: roll man.
+func runManCommand(args []string, opts outputOptions) error {
+	if len(args) != 0 {
+		return fmt.Errorf("man takes no arguments")
 	}
+	fmt.Print(formatManPage(flag.CommandLine))
+	return nil
+}
 
-	// Roll the dice.
-	result := diceSet.Roll()
+// formatSubcommands lists the commands with their arguments and usage, one per line, for the help.
+func formatSubcommands() []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, command := range subcommands() {
+		fmt.Fprintf(w, "  %s\t%s\n", strings.TrimSpace(command.name+" "+command.args), command.usage)
+	}
+	w.Flush()
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
 
-	// Sort individual rolls if requested.
-	if ascending || descending {
-		sortedRolls := make([]dice.DieRoll, len(result.DieRolls))
-		copy(sortedRolls, result.DieRolls)
+// completionDice are common dice expressions offered by shell completion.
+var completionDice = []string{"d20", "2d6", "3d6", "4d6", "d100", "d%%", "adv", "dis", "f52"}
 
-		if ascending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result < sortedRolls[j].Result
-			})
-		} else if descending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result > sortedRolls[j].Result
-			})
-		}
+// flagValueName returns the name of the value a flag takes, e.g. "uint" for --seed, or "" for a boolean flag.
+func flagValueName(f *flag.Flag) string {
+	if boolFlag, isBool := f.Value.(interface{ IsBoolFlag() bool }); isBool && boolFlag.IsBoolFlag() {
+		return ""
+	}
+	name, _ := flag.UnquoteUsage(f)
+	if name == "" {
+		name = "value"
+	}
+	return name
+}
 
-		// Print sorted results.
-		printCommandLineResults(sortedRolls, result.Total)
-	} else {
-		// Print results in original order.
-		printCommandLineResults(result.DieRolls, result.Total)
+// flagSpelling returns the flag as typed on the command line, e.g. "-q" or "--quiet".
+func flagSpelling(f *flag.Flag) string {
+	if len(f.Name) == 1 {
+		return "-" + f.Name
 	}
+	return "--" + f.Name
 }
 
-// printCommandLineResults prints the dice roll results to stdout.
-func printCommandLineResults(dieRolls []dice.DieRoll, total int) {
-	for _, roll := range dieRolls {
-		if roll.FancyValue != "" {
-			// For fancy dice, show the fancy value.
-			fmt.Printf("%s: %s\n", roll.Type, roll.FancyValue)
+// fileFlags are the flags whose value is a file name or glob pattern.
+var fileFlags = map[string]bool{"file": true, "fancy": true}
+
+// formatCompletion returns a completion script for the named shell, generated from the flags so
+// that it always matches the flags the program accepts.
+func formatCompletion(shell string, flags *flag.FlagSet) (string, error) {
+	var names []string
+	for _, command := range subcommands() {
+		names = append(names, command.name)
+	}
+
+	var buf strings.Builder
+	switch shell {
+	case "bash":
+		var spellings, valueFlags []string
+		flags.VisitAll(func(f *flag.Flag) {
+			spellings = append(spellings, flagSpelling(f))
+			if flagValueName(f) != "" && !fileFlags[f.Name] && f.Name != "sort" {
+				valueFlags = append(valueFlags, flagSpelling(f))
+			}
+		})
+		fmt.Fprintln(&buf, "# bash completion for roll; load with: source <(roll completion bash)")
+		fmt.Fprintln(&buf, "_roll() {")
+		fmt.Fprintln(&buf, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+		fmt.Fprintln(&buf, `    case "$prev" in`)
+		fmt.Fprintln(&buf, `        --file|--fancy) COMPREPLY=($(compgen -f -- "$cur")); return ;;`)
+		fmt.Fprintln(&buf, `        --sort) COMPREPLY=($(compgen -W "value roll" -- "$cur")); return ;;`)
+		fmt.Fprintf(&buf, "        %s) return ;;\n", strings.Join(valueFlags, "|"))
+		fmt.Fprintln(&buf, "    esac")
+		fmt.Fprintln(&buf, `    if [[ "$cur" == -* ]]; then`)
+		fmt.Fprintf(&buf, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(spellings, " "))
+		fmt.Fprintln(&buf, "    elif [[ $COMP_CWORD -eq 1 ]]; then")
+		fmt.Fprintf(&buf, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(append(names, completionDice...), " "))
+		fmt.Fprintln(&buf, "    else")
+		fmt.Fprintf(&buf, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(completionDice, " "))
+		fmt.Fprintln(&buf, "    fi")
+		fmt.Fprintln(&buf, "}")
+		fmt.Fprintln(&buf, "complete -F _roll roll")
+	case "zsh":
+		// Brackets and colons have a meaning in _arguments specs, and descriptions are single-quoted.
+		quote := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+		fmt.Fprintln(&buf, "#compdef roll")
+		fmt.Fprintln(&buf, "_roll() {")
+		fmt.Fprintln(&buf, "    _arguments \\")
+		flags.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			spec := fmt.Sprintf("%s[%s]", flagSpelling(f), quote.Replace(usage))
+			switch value := flagValueName(f); {
+			case fileFlags[f.Name]:
+				spec = fmt.Sprintf("%s=[%s]:%s:_files", flagSpelling(f), quote.Replace(usage), value)
+			case f.Name == "sort":
+				spec = fmt.Sprintf("%s=[%s]:%s:(value roll)", flagSpelling(f), quote.Replace(usage), value)
+			case value != "":
+				spec = fmt.Sprintf("%s=[%s]:%s: ", flagSpelling(f), quote.Replace(usage), value)
+			}
+			fmt.Fprintf(&buf, "        '%s' \\\n", spec)
+		})
+		fmt.Fprintf(&buf, "        '1:command or dice:(%s)' \\\n", strings.Join(append(names, completionDice...), " "))
+		fmt.Fprintf(&buf, "        '*:dice:(%s)'\n", strings.Join(completionDice, " "))
+		fmt.Fprintln(&buf, "}")
+		fmt.Fprintln(&buf, `_roll "$@"`)
+	case "fish":
+		quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+		fmt.Fprintln(&buf, "# fish completion for roll; load with: roll completion fish | source")
+		fmt.Fprintln(&buf, "complete -c roll -f")
+		flags.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			option := "-l " + f.Name
+			if len(f.Name) == 1 {
+				option = "-s " + f.Name
+			}
+			switch {
+			case fileFlags[f.Name]:
+				option += " -r -F"
+			case f.Name == "sort":
+				option += " -x -a 'value roll'"
+			case flagValueName(f) != "":
+				option += " -x"
+			}
+			fmt.Fprintf(&buf, "complete -c roll %s -d '%s'\n", option, quote.Replace(usage))
+		})
+		for _, command := range subcommands() {
+			fmt.Fprintf(&buf, "complete -c roll -n __fish_use_subcommand -a %s -d '%s'\n", command.name, quote.Replace(command.usage))
+		}
+		fmt.Fprintf(&buf, "complete -c roll -a '%s'\n", strings.Join(completionDice, " "))
+	default:
+		return "", fmt.Errorf("completion supports bash, zsh and fish, got %q", shell)
+	}
+	return buf.String(), nil
+}
+
+// manBoldRegex matches bold markdown, e.g. "**3d6**", for conversion to roff.
+var manBoldRegex = regexp.MustCompile(`\*\*(.+?)\*\*`)
+
+// formatManPage returns a man page in roff format, generated from the flags, the subcommands and the cheatsheet.
+func formatManPage(flags *flag.FlagSet) string {
+	// Backslashes and hyphens are special in roff, as are a period or quote starting a line.
+	escape := func(text string) string {
+		text = strings.NewReplacer(`\`, `\e`, `-`, `\-`).Replace(text)
+		if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+			text = `\&` + text
+		}
+		return text
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, ".TH ROLL 1 \"\" \"roll %s\" \"User Commands\"\n", escape(info.GetVersion()))
+	fmt.Fprintln(&buf, ".SH NAME")
+	fmt.Fprintln(&buf, `roll \- roll virtual dice`)
+	fmt.Fprintln(&buf, ".SH SYNOPSIS")
+	fmt.Fprintln(&buf, ".B roll")
+	fmt.Fprintln(&buf, `[\fIOPTIONS\fR] [\fIDICE_NOTATION\fR...]`)
+	fmt.Fprintln(&buf, ".br")
+	fmt.Fprintln(&buf, ".B roll")
+	fmt.Fprintln(&buf, `\fICOMMAND\fR [\fIARGUMENTS\fR...]`)
+	fmt.Fprintln(&buf, ".SH DESCRIPTION")
+	fmt.Fprintln(&buf, "Roll the dice written in dice notation, such as 3d6+2, and print each die and the total.")
+	fmt.Fprintln(&buf, escape("Without dice notation or a command, roll rolls the expression in "+defaultExpressionEnv+
+		" if it is set, and otherwise opens its graphical interface. --gui opens the graphical interface"+
+		" with any dice given in its entry field, and --interactive starts an interactive prompt."))
+
+	fmt.Fprintln(&buf, ".SH OPTIONS")
+	flags.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		fmt.Fprintln(&buf, ".TP")
+		if value := flagValueName(f); value != "" {
+			fmt.Fprintf(&buf, ".BI %s= %s\n", escape(flagSpelling(f)), value)
 		} else {
-			// For regular dice, show the numeric result.
-			fmt.Printf("%s: %d\n", roll.Type, roll.Result)
+			fmt.Fprintf(&buf, ".B %s\n", escape(flagSpelling(f)))
+		}
+		fmt.Fprintln(&buf, escape(usage))
+	})
+
+	fmt.Fprintln(&buf, ".SH COMMANDS")
+	for _, command := range subcommands() {
+		fmt.Fprintln(&buf, ".TP")
+		if command.args != "" {
+			fmt.Fprintf(&buf, ".B %s \\fI%s\\fR\n", command.name, escape(command.args))
+		} else {
+			fmt.Fprintf(&buf, ".B %s\n", command.name)
+		}
+		fmt.Fprintln(&buf, escape(command.usage))
+	}
+
+	// The cheatsheet's sections become subsections and its list items indented paragraphs.
+	fmt.Fprintln(&buf, ".SH NOTATION")
+	for _, line := range strings.Split(info.GetCheatsheetMarkdown(), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "### "):
+			fmt.Fprintf(&buf, ".SS %s\n", escape(strings.TrimSuffix(strings.TrimPrefix(line, "### "), ":")))
+		case strings.HasPrefix(line, "- "):
+			item := escape(strings.TrimPrefix(line, "- "))
+			item = manBoldRegex.ReplaceAllString(item, `\fB$1\fR`)
+			fmt.Fprintln(&buf, `.IP \(bu 2`)
+			fmt.Fprintln(&buf, item)
 		}
 	}
-	fmt.Printf("Total: %d\n", total)
+
+	fmt.Fprintln(&buf, ".SH ENVIRONMENT")
+	fmt.Fprintln(&buf, ".TP")
+	fmt.Fprintf(&buf, ".B %s\n", defaultExpressionEnv)
+	fmt.Fprintln(&buf, "The dice expression rolled when roll is run without dice notation or a command, e.g. 3d6.")
+
+	fmt.Fprintln(&buf, ".SH FILES")
+	fmt.Fprintln(&buf, ".TP")
+	fmt.Fprintln(&buf, ".I ~/.config/roll/config.toml")
+	fmt.Fprintln(&buf, "Settings, macros and batches; the directory follows the platform's user configuration directory.")
+	fmt.Fprintln(&buf, ".TP")
+	fmt.Fprintln(&buf, ".I ~/.config/roll/dice/*.dice")
+	fmt.Fprintln(&buf, "Fancy dice files loaded at startup, unless \\-\\-no\\-dice\\-pack is given.")
+	fmt.Fprintln(&buf, ".TP")
+	fmt.Fprintln(&buf, ".I ~/.roll_history")
+	fmt.Fprintln(&buf, "History of the expressions rolled in interactive mode.")
+	return buf.String()
 }
 
 // getHistoryFilePath returns the path for the command history file.
@@ -151,10 +2350,16 @@ func getHistoryFilePath() string {
 	return filepath.Join(currentUser.HomeDir, ".roll_history")
 }
 
+// historyEntry records when an expression was rolled during an interactive session.
+type historyEntry struct {
+	rolledAt   time.Time
+	expression string
+}
+
 // runInteractive starts an interactive REPL for dice rolling.
-func runInteractive(ascending, descending bool) {
+func runInteractive(opts outputOptions) {
 	// Validate sorting flags.
-	if ascending && descending {
+	if opts.ascending && opts.descending {
 		fmt.Fprintf(os.Stderr, "Error: Cannot specify both --ascending and --descending flags\n")
 		os.Exit(1)
 	}
@@ -181,10 +2386,18 @@ func runInteractive(ascending, descending bool) {
 	fmt.Printf("Roll Dice Interactive Mode v%s\n", info.GetVersion())
 	fmt.Println("Enter dice expressions (e.g., 3d6, 2d10 d6) or 'help' for commands.")
 	fmt.Println("Type 'quit' or 'exit' to exit, or press Ctrl+C.")
-	fmt.Println("Press ENTER on empty line to repeat the last dice roll.")
+	fmt.Println("Press ENTER on empty line, or type 'again', to repeat the last dice roll.")
 	fmt.Println()
 
 	var lastDiceExpression string
+	var session []historyEntry
+	opts.variables = make(map[string]int)
+
+	// Batches defined during the session are added to a copy of the configured ones.
+	batches := make(map[string]string, len(opts.batches))
+	maps.Copy(batches, opts.batches)
+	opts.batches = batches
+	pools := make(map[string]int)
 
 	for {
 		line, err := rl.Readline()
@@ -209,9 +2422,62 @@ func runInteractive(ascending, descending bool) {
 		if line == "" {
 			if lastDiceExpression != "" {
 				fmt.Printf("Repeating: %s\n", lastDiceExpression)
-				processDiceExpression(lastDiceExpression, ascending, descending)
+				session = append(session, historyEntry{time.Now(), lastDiceExpression})
+				processDiceExpression(lastDiceExpression, opts)
+			}
+			continue
+		}
+
+		// Handle variable commands: set name=value, unset name.
+		if command, argument, _ := strings.Cut(line, " "); strings.EqualFold(command, "set") {
+			if err := setVariable(argument, opts.variables); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			continue
+		} else if strings.EqualFold(command, "pool") {
+			if err := runPoolCommand(strings.TrimSpace(argument), pools, opts); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			continue
+		} else if strings.EqualFold(command, "batch") {
+			runInteractiveBatch(strings.TrimSpace(argument), opts)
+			continue
+		} else if command == "." || strings.EqualFold(command, "again") {
+			count, err := parseAgainCount(strings.TrimSpace(argument))
+			switch {
+			case err != nil:
+				fmt.Printf("Error: %v\n", err)
+			case lastDiceExpression == "":
+				fmt.Println("Nothing to roll again yet: roll a dice expression first.")
+			default:
+				for i := 1; i <= count; i++ {
+					if count > 1 {
+						fmt.Printf("Roll %d of %d: %s\n", i, count, lastDiceExpression)
+					} else {
+						fmt.Printf("Repeating: %s\n", lastDiceExpression)
+					}
+					session = append(session, historyEntry{time.Now(), lastDiceExpression})
+					processDiceExpression(lastDiceExpression, opts)
+				}
 			}
 			continue
+		} else if strings.EqualFold(command, "total") {
+			switch strings.ToLower(strings.TrimSpace(argument)) {
+			case "on":
+				opts.noTotal = false
+			case "off":
+				opts.noTotal = true
+			default:
+				fmt.Println("Error: usage: total on|off")
+			}
+			continue
+		} else if strings.EqualFold(command, "unset") {
+			name := strings.TrimSpace(argument)
+			if _, exists := opts.variables[name]; !exists {
+				fmt.Printf("Error: undefined variable: %s\n", name)
+			}
+			delete(opts.variables, name)
+			continue
 		}
 
 		// Handle special commands.
@@ -229,6 +2495,27 @@ func runInteractive(ascending, descending bool) {
 			// Don't save version commands to history.
 			fmt.Printf("Roll Dice Application v%s\n", info.GetVersion())
 			continue
+		case "history":
+			// Don't save history commands to history.
+			for _, entry := range session {
+				fmt.Printf("%s %s\n", formatTimestamp(entry.rolledAt, opts.utc), entry.expression)
+			}
+			continue
+		case "seed":
+			// Don't save seed commands to history.
+			fmt.Println(formatSeed(opts))
+			continue
+		case "vars":
+			// Don't save vars commands to history.
+			names := make([]string, 0, len(opts.variables))
+			for name := range opts.variables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("%s = %d\n", name, opts.variables[name])
+			}
+			continue
 		case "cheat", "cheatsheet":
 			// Don't save cheat commands to history.
 			fmt.Println(info.GetCheatsheetContent())
@@ -236,24 +2523,188 @@ func runInteractive(ascending, descending bool) {
 		}
 
 		// Process dice expression and save to history if valid.
-		if isDiceExpression(line) {
+		err = parseDiceExpression(expandMacros(line, opts.macros), opts.variables)
+		if err == nil {
 			lastDiceExpression = line
 			// Manually save only dice expressions to history.
 			rl.SaveHistory(line)
-			processDiceExpression(line, ascending, descending)
+			session = append(session, historyEntry{time.Now(), line})
+			processDiceExpression(line, opts)
+		} else if errors.Is(err, dice.ErrUndefinedVariable) {
+			fmt.Printf("Error: %v\n", err)
 		} else {
 			fmt.Printf("Unknown command: %s. Type 'help' for available commands.\n", line)
 		}
 	}
 }
 
-// isDiceExpression checks if a string looks like a valid dice expression.
-func isDiceExpression(expression string) bool {
-	// Try to parse it - if it succeeds, it's a valid dice expression.
-	_, err := dice.ParseDiceNotation(expression)
-	return err == nil
+// maxAgainCount limits how many times the interactive "again" command rolls in one go.
+const maxAgainCount = 100
+
+// parseAgainCount parses the optional count of the interactive "again" command, e.g. the 5 in "again 5".
+func parseAgainCount(argument string) (int, error) {
+	if argument == "" {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(argument)
+	if err != nil || count < 1 || count > maxAgainCount {
+		return 0, fmt.Errorf("usage: again [COUNT], where COUNT is from 1 to %d, e.g. again 5", maxAgainCount)
+	}
+	return count, nil
+}
+
+// poolCommandRegex matches the argument of the interactive "pool" command, e.g. "monster_hp += 8d8+24".
+var poolCommandRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*(?:([+-]?=)\s*(.*))?$`)
+
+// runPoolCommand handles the interactive "pool" command, which keeps running totals such as a
+// monster's hit points. On its own it lists the pools; "pool NAME" shows one, and "pool NAME = EXPR",
+// "pool NAME += EXPR" and "pool NAME -= EXPR" set, add to or subtract from it. EXPR is a number or a
+// dice expression, which is rolled and shown.
+func runPoolCommand(argument string, pools map[string]int, opts outputOptions) error {
+	if argument == "" {
+		names := make([]string, 0, len(pools))
+		for name := range pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s: %d\n", name, pools[name])
+		}
+		return nil
+	}
+
+	match := poolCommandRegex.FindStringSubmatch(argument)
+	if match == nil {
+		return fmt.Errorf("usage: pool NAME, or pool NAME = EXPR, += EXPR or -= EXPR")
+	}
+	name, op, expression := match[1], match[2], strings.TrimSpace(match[3])
+
+	if op == "" {
+		value, exists := pools[name]
+		if !exists {
+			return fmt.Errorf("undefined pool: %s", name)
+		}
+		fmt.Printf("%s: %d\n", name, value)
+		return nil
+	}
+	if _, exists := pools[name]; !exists && op != "=" {
+		return fmt.Errorf("undefined pool: %s (create it with pool %s = EXPR)", name, name)
+	}
+	if expression == "" {
+		return fmt.Errorf("pool %s %s needs a number or dice expression", name, op)
+	}
+
+	amount, err := poolAmount(expression, opts)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "=":
+		pools[name] = amount
+	case "+=":
+		pools[name] += amount
+	case "-=":
+		pools[name] -= amount
+	}
+	fmt.Printf("%s: %d\n", name, pools[name])
+	return nil
+}
+
+// poolAmount returns the value of a number, or rolls a dice expression and prints the result.
+func poolAmount(expression string, opts outputOptions) (int, error) {
+	if value, err := strconv.Atoi(expression); err == nil {
+		return value, nil
+	}
+	if value, isVariable := opts.variables[expression]; isVariable {
+		return value, nil
+	}
+
+	diceSet, err := dice.ParseDiceNotationWithVariables(expandMacros(expression, opts.macros), opts.variables)
+	if err != nil {
+		return 0, err
+	}
+	seedRoll(opts)
+	result := diceSet.Roll()
+	if result.Err != nil {
+		return 0, result.Err
+	}
+	if result.Overflow {
+		return 0, fmt.Errorf("the total is too large to compute")
+	}
+	rolledAt := time.Now()
+	printRollResult(expression, result, rolledAt, opts)
+	unlabelled, _ := dice.SplitLabel(expression)
+	logRoll(rolledAt, formatCompactLine(unlabelled, result, displayDieRolls(result.DieRolls, opts)), opts)
+	recordRoll(rolledAt, unlabelled, result, opts)
+	return result.Total, nil
+}
+
+// runInteractiveBatch handles the interactive "batch" command: on its own it lists the batches,
+// "batch NAME = EXPR; EXPR" defines a batch for the session, and "batch NAME" rolls one.
+func runInteractiveBatch(argument string, opts outputOptions) {
+	if argument == "" {
+		for _, line := range formatBatches(opts.batches) {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	if name, definition, found := strings.Cut(argument, "="); found {
+		name = strings.TrimSpace(name)
+		if !variableNameRegex.MatchString(name) {
+			fmt.Printf("Error: invalid batch name '%s': use letters, digits and underscores\n", name)
+			return
+		}
+		if _, err := parseBatch(definition); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		opts.batches[name] = strings.TrimSpace(definition)
+		return
+	}
+
+	if err := runBatch(argument, opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// parseDiceExpression checks whether a string is a valid dice expression, returning the parse error if not.
+func parseDiceExpression(expression string, variables map[string]int) error {
+	if dice.IsCritNotation(expression) {
+		_, err := dice.ParseCritNotationWithVariables(expression, variables)
+		return err
+	}
+	_, err := dice.ParseDiceNotationWithVariables(expression, variables)
+	return err
+}
+
+// setVariable handles the interactive "set name=value" command.
+func setVariable(assignment string, variables map[string]int) error {
+	name, valueText, found := strings.Cut(assignment, "=")
+	if !found {
+		return fmt.Errorf("usage: set name=value, e.g. set str=3")
+	}
+	name, valueText = strings.TrimSpace(name), strings.TrimSpace(valueText)
+
+	if !variableNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid variable name '%s': use letters, digits and underscores", name)
+	}
+	if parseDiceExpression(name, nil) == nil {
+		return fmt.Errorf("'%s' is dice notation and cannot be a variable name", name)
+	}
+
+	value, err := strconv.Atoi(valueText)
+	if err != nil {
+		return fmt.Errorf("invalid value '%s': must be an integer", valueText)
+	}
+
+	variables[name] = value
+	return nil
 }
 
+// variableNameRegex matches valid variable names.
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // createAutoCompleter creates an autocompleter for the readline interface.
 func createAutoCompleter() readline.AutoCompleter {
 	return readline.NewPrefixCompleter(
@@ -261,6 +2712,18 @@ func createAutoCompleter() readline.AutoCompleter {
 		readline.PcItem("version"),
 		readline.PcItem("cheat"),
 		readline.PcItem("cheatsheet"),
+		readline.PcItem("history"),
+		readline.PcItem("seed"),
+		readline.PcItem("set"),
+		readline.PcItem("unset"),
+		readline.PcItem("vars"),
+		readline.PcItem("pool"),
+		readline.PcItem("batch"),
+		readline.PcItem("total",
+			readline.PcItem("on"),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("again"),
 		readline.PcItem("quit"),
 		readline.PcItem("exit"),
 		// Common dice expressions
@@ -299,8 +2762,19 @@ func printInteractiveHelp() {
 	fmt.Println("  help           - Show this help")
 	fmt.Println("  version        - Show version information")
 	fmt.Println("  cheat          - Show dice notation cheatsheet")
+	fmt.Println("  history        - List this session's rolls with the time of each")
+	fmt.Println("  seed           - Show the generator and seed of the last roll")
+	fmt.Println("  set str=3      - Set a variable for use in expressions, e.g. 1d20+str")
+	fmt.Println("  unset str      - Remove a variable")
+	fmt.Println("  vars           - List the variables that are set")
+	fmt.Println("  pool hp = 8d8+24 - Start a running total (also pool hp += 2d6, pool hp -= 12)")
+	fmt.Println("  pool hp        - Show a running total (pool alone lists them all)")
+	fmt.Println("  batch combat = attack: 1d20+5; damage: 2d6+3 - Define a batch of rolls")
+	fmt.Println("  batch combat   - Roll every expression in a batch (batch alone lists them)")
+	fmt.Println("  total off      - Hide the total after the dice (total on shows it again)")
 	fmt.Println("  quit, exit     - Exit interactive mode")
 	fmt.Println("  <ENTER>        - Repeat the last dice roll")
+	fmt.Println("  again, .       - Repeat the last dice roll (again 5 rolls it five times)")
 	fmt.Println()
 	fmt.Println("History Features:")
 	fmt.Println("  • UP/DOWN arrows - Navigate command history")
@@ -313,54 +2787,39 @@ func printInteractiveHelp() {
 	fmt.Println("  1d20,7d4       - Roll one twenty-sided die and seven four-sided dice")
 	fmt.Println("  f2             - Roll a two-sided fancy die (heads/tails)")
 	fmt.Println("  3D6            - Roll three exclusive six-sided dice (no repeats)")
+	fmt.Println("  highest(2d20)+5 - Keep the single highest d20 and add 5")
+	fmt.Println("  adv+5, dis     - Advantage or disadvantage on a d20")
+	fmt.Println("  {4d6 2d8}kh3   - Keep the highest three dice across both groups")
+	fmt.Println("  best(2d6+1, 1d12) - Roll each option and keep the highest total")
+	fmt.Println("  6d10>=7        - Count the dice showing 7 or more")
+	fmt.Println("  d100<=45       - Roll-under check with critical successes and fumbles")
+	fmt.Println("  1d20+5 crit 2d6+3 - Attack roll; a natural 20 doubles the damage dice")
+	fmt.Println("  3d6!           - Exploding dice: roll again on a 6 and add it")
+	fmt.Println("  swwild d8      - Savage Worlds trait roll with a wild die")
 	fmt.Println()
 }
 
 // processDiceExpression parses and executes a dice expression.
-func processDiceExpression(expression string, ascending, descending bool) {
-	// Parse the dice notation.
-	diceSet, err := dice.ParseDiceNotation(expression)
-	if err != nil {
+func processDiceExpression(expression string, opts outputOptions) {
+	if err := rollExpression(expression, "", opts); err != nil {
 		fmt.Printf("Error parsing dice notation '%s': %v\n", expression, err)
-		return
-	}
-
-	// Roll the dice.
-	result := diceSet.Roll()
-
-	// Sort individual rolls if requested.
-	if ascending || descending {
-		sortedRolls := make([]dice.DieRoll, len(result.DieRolls))
-		copy(sortedRolls, result.DieRolls)
-
-		if ascending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result < sortedRolls[j].Result
-			})
-		} else if descending {
-			sort.Slice(sortedRolls, func(i, j int) bool {
-				return sortedRolls[i].Result > sortedRolls[j].Result
-			})
-		}
-
-		// Print sorted results.
-		printCommandLineResults(sortedRolls, result.Total)
-	} else {
-		// Print results in original order.
-		printCommandLineResults(result.DieRolls, result.Total)
 	}
 }
 
-// runGUI starts the graphical user interface.
-func runGUI() {
+// runGUI starts the graphical user interface, with expression in the entry field if it is not empty
+// and rolled straight away if roll is set.
+func runGUI(expression string, roll bool) {
 	myApp := app.NewWithID("com.github.sfkleach.roll")
 
 	myWindow := myApp.NewWindow("Roll - Virtual Dice")
 	myWindow.Resize(fyne.NewSize(450, 350))
 	myWindow.CenterOnScreen()
 
-	// Create and setup the GUI.
-	gui.NewApp(myWindow)
+	// Create and setup the GUI, with any expression given on the command line.
+	guiApp := gui.NewApp(myWindow)
+	if expression != "" {
+		guiApp.Prefill(expression, roll)
+	}
 
 	myWindow.ShowAndRun()
 }
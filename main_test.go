@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sfkleach/roll/internal/dice"
+	"github.com/sfkleach/roll/internal/fair"
 )
 
 func TestDiceIntegration(t *testing.T) {
@@ -52,7 +59,7 @@ func TestProcessDiceExpression(t *testing.T) {
 	os.Stdout = w
 
 	// Test a simple dice expression.
-	processDiceExpression("1d6", false, false)
+	processDiceExpression("1d6", outputOptions{})
 
 	// Restore stdout and read the output.
 	w.Close()
@@ -70,6 +77,125 @@ func TestProcessDiceExpression(t *testing.T) {
 	}
 }
 
+func TestProcessDiceExpressionNoTotal(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	processDiceExpression("2d6+1", outputOptions{noTotal: true})
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	// The dice and modifier are still listed; only the total is left out.
+	if strings.Count(output, "d6:") != 2 || !strings.Contains(output, "Modifier: +1") {
+		t.Errorf("Expected two d6 lines and the modifier, got: %s", output)
+	}
+	if strings.Contains(output, "Total:") {
+		t.Errorf("Expected no 'Total:' line, got: %s", output)
+	}
+}
+
+func TestProcessPercentileDice(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	processDiceExpression("d%%", outputOptions{})
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	// The tens and units d10s are both reported.
+	if !strings.Contains(output, "d%%: tens: ") || !strings.Contains(output, ", units: ") {
+		t.Errorf("Expected the tens and units of the percentile roll, got: %s", output)
+	}
+}
+
+func TestProcessSpacedDiceExpression(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	processDiceExpression("3 d 1 + 2", outputOptions{})
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if strings.Count(output, "d1: 1") != 3 || !strings.Contains(output, "Total: 5") {
+		t.Errorf("Expected three d1 rolls and a total of 5, got: %s", output)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolls.txt")
+	content := "# Encounter\n\n3d1+2  # attack\n  \n2d1\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Cannot write roll file: %v", err)
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := runFile(path, outputOptions{compact: true})
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if err != nil {
+		t.Fatalf("runFile unexpected error: %v", err)
+	}
+	if output != "attack: 3d1+2: 1+1+1+2 = 5\n2d1: 1+1 = 2\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+
+	// With --json every line is a JSON object carrying its label, with no headings between them.
+	r, w, _ = os.Pipe()
+	os.Stdout = w
+
+	err = runFile(path, outputOptions{json: true})
+
+	w.Close()
+	os.Stdout = oldStdout
+	buf.Reset()
+	io.Copy(&buf, r)
+
+	if err != nil {
+		t.Fatalf("runFile unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	wantLabels := []string{"attack", ""}
+	if len(lines) != len(wantLabels) {
+		t.Fatalf("Expected %d lines of JSON, got: %q", len(wantLabels), lines)
+	}
+	for i, line := range lines {
+		var roll jsonRoll
+		if err := json.Unmarshal([]byte(line), &roll); err != nil {
+			t.Fatalf("Line %d is not JSON: %q", i+1, line)
+		}
+		if roll.Label != wantLabels[i] {
+			t.Errorf("Line %d label = %q, want %q", i+1, roll.Label, wantLabels[i])
+		}
+	}
+
+	if err := runFile(filepath.Join(t.TempDir(), "missing.txt"), outputOptions{}); err == nil || !strings.Contains(err.Error(), "cannot open roll file") {
+		t.Errorf("Expected a clear error for a missing file, got %v", err)
+	}
+}
+
 func TestProcessDiceExpressionError(t *testing.T) {
 	// Test error handling in processDiceExpression.
 
@@ -78,7 +204,7 @@ func TestProcessDiceExpressionError(t *testing.T) {
 	os.Stdout = w
 
 	// Test an invalid dice expression.
-	processDiceExpression("invalid", false, false)
+	processDiceExpression("invalid", outputOptions{})
 
 	// Restore stdout and read the output.
 	w.Close()
@@ -92,3 +218,1039 @@ func TestProcessDiceExpressionError(t *testing.T) {
 		t.Errorf("Expected output to contain error message, got: %s", output)
 	}
 }
+
+func TestFormatCompactResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		dieRolls   []dice.DieRoll
+		modifier   int
+		total      int
+		want       string
+	}{
+		{
+			"regular dice",
+			"3d6",
+			[]dice.DieRoll{{Type: "d6", Result: 4}, {Type: "d6", Result: 2}, {Type: "d6", Result: 6}},
+			0, 12,
+			"3d6: 4+2+6 = 12",
+		},
+		{
+			"modifier",
+			"1d20 - 2",
+			[]dice.DieRoll{{Type: "d20", Result: 15}},
+			-2, 13,
+			"1d20 - 2: 15-2 = 13",
+		},
+		{
+			"fancy dice",
+			"2f4",
+			[]dice.DieRoll{{Type: "f4", Result: 1, FancyValue: "♠"}, {Type: "f4", Result: 3, FancyValue: "♦"}},
+			0, 6,
+			"2f4: ♠+♦ = 6",
+		},
+		{
+			"dropped dice",
+			"highest(2d20)+5",
+			[]dice.DieRoll{{Type: "d20", Result: 3, Dropped: true}, {Type: "d20", Result: 17}},
+			5, 22,
+			"highest(2d20)+5: 17+5 = 22 (dropped: 3)",
+		},
+		{
+			"negative scores",
+			"3d{3,0,-1}-2",
+			[]dice.DieRoll{{Type: "d{3,0,-1}", Result: 1, FancyValue: "3"}, {Type: "d{3,0,-1}", Result: 3, FancyValue: "-1"}, {Type: "d{3,0,-1}", Result: 3, FancyValue: "-1"}},
+			-2, -1,
+			"3d{3,0,-1}-2: 3-1-1-2 = -1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatCompactResult(tt.expression, tt.dieRolls, tt.modifier, tt.total)
+			if got != tt.want {
+				t.Errorf("formatCompactResult() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeContest(t *testing.T) {
+	tests := []struct {
+		totalA int
+		totalB int
+		want   string
+	}{
+		{17, 13, "Side A wins by 4"},
+		{8, 15, "Side B wins by 7"},
+		{12, 12, "Tie at 12"},
+		{-1, -3, "Side A wins by 2"},
+	}
+
+	for _, tt := range tests {
+		if got := describeContest(tt.totalA, tt.totalB); got != tt.want {
+			t.Errorf("describeContest(%d, %d) = %q, want %q", tt.totalA, tt.totalB, got, tt.want)
+		}
+	}
+}
+
+func TestProcessDiceExpressionQuiet(t *testing.T) {
+	// Test that quiet mode prints only the total.
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	processDiceExpression("1d1+2", outputOptions{quiet: true})
+
+	// Restore stdout and read the output.
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if output != "3\n" {
+		t.Errorf("Expected quiet output '3\\n', got: %q", output)
+	}
+}
+
+func TestFormatTotal(t *testing.T) {
+	result := dice.RollResult{Total: 30000}
+	if got := formatTotal(result, outputOptions{}); got != "Total: 30000" {
+		t.Errorf("formatTotal() = %q, want %q", got, "Total: 30000")
+	}
+	if got := formatTotal(result, outputOptions{groupDigits: true}); got != "Total: 30,000" {
+		t.Errorf("formatTotal() with --group-digits = %q, want %q", got, "Total: 30,000")
+	}
+
+	result = dice.RollResult{Total: 3, Scaled: &dice.Scale{Unscaled: 7, Scaled: 3, Steps: []string{"/ 2"}, Rounded: true}}
+	if got := formatTotal(result, outputOptions{}); got != "Total: 3 (7 / 2 = 3 rounded down)" {
+		t.Errorf("formatTotal() of a divided total = %q", got)
+	}
+}
+
+func TestProcessLabelledDiceExpression(t *testing.T) {
+	// Test that a label survives sorting and appears in compact and JSON output.
+	tests := []struct {
+		opts outputOptions
+		want string
+	}{
+		{outputOptions{compact: true, descending: true}, "sneak attack: 2d1+1: 1+1+1 = 3\n"},
+		{outputOptions{quiet: true}, "sneak attack: 3\n"},
+		{outputOptions{json: true, ascending: true}, `{"expression":"2d1+1","label":"sneak attack","dice":[{"type":"d1","result":1,"score":1},{"type":"d1","result":1,"score":1}],"modifier":1,"total":3}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		processDiceExpression("2d1+1  # sneak attack", tt.opts)
+
+		// Restore stdout and read the output.
+		w.Close()
+		os.Stdout = oldStdout
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+
+		if output := buf.String(); output != tt.want {
+			t.Errorf("Expected %q, got: %q", tt.want, output)
+		}
+	}
+}
+
+func TestFormatJSONResultCompounding(t *testing.T) {
+	result := dice.RollResult{
+		DieRolls: []dice.DieRoll{
+			{Type: "d6", Result: 14, Score: 14, Rolls: []int{6, 6, 2}},
+			{Type: "d6", Result: 3, Score: 3, Rolls: []int{3}},
+		},
+		Total: 17,
+	}
+	want := `{"expression":"2d6!!","dice":[{"type":"d6","result":14,"score":14,"rolls":[6,6,2]},{"type":"d6","result":3,"score":3}],"modifier":0,"total":17}`
+
+	got, err := encodeJSON(newJSONRoll("2d6!!", result, result.DieRolls))
+	if err != nil || got != want {
+		t.Errorf("encodeJSON() = %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestTimestampedJSON(t *testing.T) {
+	rolledAt := time.Date(2026, 10, 17, 20, 20, 35, 0, time.UTC)
+	result := dice.RollResult{DieRolls: []dice.DieRoll{{Type: "d6", Result: 4, Score: 4}}, Total: 4}
+	tmpl, err := parseOutputTemplate("{{.Time}} {{.Total}}")
+	if err != nil {
+		t.Fatalf("parseOutputTemplate unexpected error: %v", err)
+	}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// The time is a field of the output, with no timestamp line before it.
+	for _, opts := range []outputOptions{{json: true, timestamp: true, utc: true}, {template: tmpl, timestamp: true, utc: true}} {
+		printTimestamp(rolledAt, opts)
+		printRollResult("1d6", result, rolledAt, opts)
+	}
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+
+	want := `{"time":"2026-10-17T20:20:35Z","expression":"1d6","dice":[{"type":"d6","result":4,"score":4}],"modifier":0,"total":4}` + "\n2026-10-17T20:20:35Z 4\n"
+	if buf.String() != want {
+		t.Errorf("Timestamped output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFormatTemplateResult(t *testing.T) {
+	unclamped := 23
+	result := dice.RollResult{
+		DieRolls: []dice.DieRoll{
+			{Type: "d6", Result: 6, Score: 6},
+			{Type: "d6", Result: 1, Score: 1, Dropped: true},
+			{Type: "f2", Result: 1, Score: 1, FancyValue: "heads"},
+		},
+		Modifier: 10,
+		Total:    17,
+		Label:    "str",
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{.Total}}", "17"},
+		{"{{.Label}} ({{.Expression}}):{{range .Dice}} {{.Type}}={{or .Face .Result}}{{if .Dropped}}x{{end}}{{end}}\n", "str (1d6 1d6 f2+10): d6=6 d6=1x f2=heads"},
+		{"{{len .Dice}} dice{{with .Unclamped}}, unclamped {{.}}{{end}}", "3 dice"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseOutputTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("parseOutputTemplate(%q) unexpected error: %v", tt.template, err)
+		}
+		got, err := formatTemplateResult(tmpl, newJSONRoll("1d6  1d6 f2+10", result, result.DieRolls))
+		if err != nil || got != tt.want {
+			t.Errorf("formatTemplateResult(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+
+	result.Clamped = &dice.Clamp{Unclamped: unclamped, Low: 1, High: 18}
+	tmpl, _ := parseOutputTemplate("{{with .Unclamped}}unclamped {{.}}{{end}}")
+	if got, err := formatTemplateResult(tmpl, newJSONRoll("3d6+10", result, result.DieRolls)); err != nil || got != "unclamped 23" {
+		t.Errorf("formatTemplateResult() with a clamp = %q, %v", got, err)
+	}
+
+	// Both syntax errors and unknown fields are caught before rolling.
+	for _, text := range []string{"{{.Total", "{{.Totl}}", "{{range .Dice}}{{.Value}}{{end}}"} {
+		if _, err := parseOutputTemplate(text); err == nil {
+			t.Errorf("parseOutputTemplate(%q) expected an error", text)
+		}
+	}
+}
+
+func TestFormatAlternatives(t *testing.T) {
+	alternatives := []dice.Alternative{
+		{Notation: "2d6+1", Total: 9, Chosen: true},
+		{Notation: "1d12", Total: 4},
+	}
+	want := []string{"Option 2d6+1: 9 (chosen)", "Option 1d12: 4"}
+
+	got := formatAlternatives(alternatives)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatAlternatives() = %q, want %q", got, want)
+	}
+}
+
+func TestSuccessExitCode(t *testing.T) {
+	if code := successExitCode(dice.MustRollNotation("3d1>=1")); code != exitSuccess {
+		t.Errorf("Expected exit status %d with successes, got %d", exitSuccess, code)
+	}
+	if code := successExitCode(dice.MustRollNotation("3d1>1")); code != exitNoSuccess {
+		t.Errorf("Expected exit status %d without successes, got %d", exitNoSuccess, code)
+	}
+}
+
+func TestFormatEstimate(t *testing.T) {
+	estimate := dice.Estimate{
+		Samples: 100,
+		Mean:    9.5,
+		StdDev:  1.25,
+		StdErr:  0.125,
+		Min:     8,
+		Max:     10,
+		Counts:  map[int]int{8: 20, 10: 80},
+	}
+	want := []string{
+		"2d6 (100 samples):",
+		"Mean: 9.50 ± 0.24 (95% confidence)",
+		"Std dev: 1.25",
+		"Range seen: 8 to 10",
+		" 8: ~ 20.0% ##########",
+		"10:   80.0% ########################################",
+		"~ seen fewer than 30 times; more samples give a better estimate",
+	}
+
+	got := formatEstimate("2d6", estimate)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatEstimate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFormatInitiative(t *testing.T) {
+	entries := []initiativeEntry{
+		{label: "Goblin", total: 12, d20: 10},
+		{label: "Hero", total: 19, d20: 14},
+		{label: "Orc", total: 12, d20: 11},
+		{label: "Wolf", total: 8, d20: 6},
+		{label: "Bat", total: 8, d20: 6},
+	}
+	want := []string{
+		"1. Hero: 19",
+		"2. Orc: 12 (tie broken by d20: 11)",
+		"3. Goblin: 12 (tie broken by d20: 10)",
+		"4. Wolf: 8 (tied with Bat, reroll)",
+		"5. Bat: 8 (tied with Wolf, reroll)",
+	}
+
+	orderInitiative(entries)
+	got := formatInitiative(entries)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatInitiative() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFormatChance(t *testing.T) {
+	query, err := dice.ParseChanceQuery("3d6+2>=15")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		chance dice.Chance
+		want   string
+	}{
+		{dice.Chance{Probability: 56.0 / 216, Exact: true}, "3d6+2 >= 15: 25.93%"},
+		{dice.Chance{Probability: 0.25, Samples: 1000}, "3d6+2 >= 15: ~25.00% (estimated from 1000 rolls)"},
+	}
+	for _, tt := range tests {
+		if got := formatChance(query, tt.chance, false); got != tt.want {
+			t.Errorf("formatChance(%+v) = %q, want %q", tt.chance, got, tt.want)
+		}
+	}
+}
+
+func TestFormatOdds(t *testing.T) {
+	tests := []struct {
+		probability float64
+		exact       bool
+		want        string
+	}{
+		{1.0 / 6, true, "1 in 6"},
+		{56.0 / 216, true, "about 1 in 4"},
+		{0.25, false, "about 1 in 4"},
+		{5.0 / 6, true, "5 in 6"},
+		{0.7, true, "7 in 10"},
+		{0.72, false, "about 5 in 7"},
+		{1.0 / 216, true, "about 1 in 220"},
+		{1.0 / 20000, true, "1 in 20,000"},
+		{0.5, true, "1 in 2"},
+		{0, true, "never"},
+		{1, true, "always"},
+	}
+	for _, tt := range tests {
+		if got := formatOdds(tt.probability, tt.exact); got != tt.want {
+			t.Errorf("formatOdds(%v, %v) = %q, want %q", tt.probability, tt.exact, got, tt.want)
+		}
+	}
+
+	query, err := dice.ParseChanceQuery("1d6 >= 6")
+	if err != nil {
+		t.Fatalf("ParseChanceQuery unexpected error: %v", err)
+	}
+	if got := formatChance(query, dice.Chance{Probability: 1.0 / 6, Exact: true}, true); got != "1d6 >= 6: 16.67%, 1 in 6" {
+		t.Errorf("formatChance() with odds = %q", got)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	entries, err := parseBatch("attack: 1d20+5; 2d6+3 ;; big hit: 2d{a:b}; 1d{x:y}")
+	if err != nil {
+		t.Fatalf("parseBatch unexpected error: %v", err)
+	}
+	want := []batchEntry{
+		{label: "attack", expression: "1d20+5"},
+		{expression: "2d6+3"},
+		{label: "big hit", expression: "2d{a:b}"},
+		{expression: "1d{x:y}"},
+	}
+	if !slices.Equal(entries, want) {
+		t.Errorf("parseBatch() = %v, want %v", entries, want)
+	}
+
+	for _, definition := range []string{"", " ; ", "attack:"} {
+		if _, err := parseBatch(definition); err == nil {
+			t.Errorf("parseBatch(%q) expected an error", definition)
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	opts := outputOptions{batches: map[string]string{"combat": "attack: 1d1+5; 2d1"}}
+	err := runBatch("combat", opts)
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if err != nil {
+		t.Fatalf("runBatch unexpected error: %v", err)
+	}
+	if !strings.Contains(output, "attack (1d1+5):\n") || !strings.Contains(output, "Total: 6\n2d1:\n") {
+		t.Errorf("Expected labelled results, got: %s", output)
+	}
+
+	if err := runBatch("missing", opts); err == nil {
+		t.Error("Expected an error for an unknown batch")
+	}
+	opts.batches["broken"] = "1d20; 2x6"
+	if err := runBatch("broken", opts); err == nil {
+		t.Error("Expected an error for a batch with invalid notation")
+	}
+}
+
+func TestRunPoolCommand(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	pools := make(map[string]int)
+	opts := outputOptions{variables: map[string]int{"dmg": 4}}
+	commands := []string{"monster_hp = 8d1+24", "monster_hp -= 12", "monster_hp += dmg", "monster_hp"}
+	for _, command := range commands {
+		if err := runPoolCommand(command, pools, opts); err != nil {
+			t.Errorf("runPoolCommand(%q) unexpected error: %v", command, err)
+		}
+	}
+
+	w.Close()
+	os.Stdout = oldStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	output := buf.String()
+
+	if pools["monster_hp"] != 24 {
+		t.Errorf("Expected monster_hp to be 24, got %d", pools["monster_hp"])
+	}
+	if !strings.Contains(output, "Total: 32\nmonster_hp: 32\nmonster_hp: 20\n") {
+		t.Errorf("Expected the roll and each new value, got: %s", output)
+	}
+
+	for _, command := range []string{"goblin", "goblin += 3", "monster_hp +=", "monster_hp += 2x6", "2bad = 3"} {
+		if err := runPoolCommand(command, pools, opts); err == nil {
+			t.Errorf("runPoolCommand(%q) expected an error", command)
+		}
+	}
+}
+
+func TestFormatSeed(t *testing.T) {
+	defer dice.Reseed() // Leave the dice on a fresh random seed for other tests.
+
+	dice.SetSeed(42)
+	if got := formatSeed(outputOptions{}); got != "Generator: PCG, seed 42 (from --seed); reproduce with --seed=42" {
+		t.Errorf("formatSeed() = %q", got)
+	}
+	if got := formatSeed(outputOptions{reseed: true}); !strings.Contains(got, "(crypto-random)") {
+		t.Errorf("Expected a crypto-random seed, got %q", got)
+	}
+}
+
+func TestFormatGroupedResult(t *testing.T) {
+	dieRolls := []dice.DieRoll{
+		{Type: "d6", Result: 3, Score: 3},
+		{Type: "f4", Result: 1, Score: 4, FancyValue: "♠"},
+		{Type: "d6", Result: 5, Score: 5},
+		{Type: "d6", Result: 1, Score: 1, Dropped: true},
+		{Type: "f4", Result: 3, Score: 2, FancyValue: "♦"},
+	}
+	want := []string{
+		"3d6: [3, 5] = 8 (dropped: 1)",
+		"2f4: [♠, ♦] = 6",
+	}
+
+	got := formatGroupedResult(dieRolls)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatGroupedResult() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCountDiceArticle(t *testing.T) {
+	tests := []struct {
+		dieType string
+		want    string
+	}{
+		{"d1", "a one-sided die"},
+		{"d8", "an eight-sided die"},
+		{"d11", "an eleven-sided die"},
+		{"d18", "an eighteen-sided die"},
+		{"d6", "a six-sided die"},
+		{"d80", "an 80-sided die"},
+		{"d30", "a 30-sided die"},
+		{"d110", "a 110-sided die"},
+		{"d11000", "an 11000-sided die"},
+		{"d100", "a hundred-sided die"},
+		{"f2", "a coin"},
+	}
+	for _, tt := range tests {
+		if got := countDice(1, tt.dieType); got != tt.want {
+			t.Errorf("countDice(1, %q) = %q, want %q", tt.dieType, got, tt.want)
+		}
+	}
+}
+
+func TestFormatNarration(t *testing.T) {
+	tests := []struct {
+		name   string
+		result dice.RollResult
+		want   string
+	}{
+		{
+			name: "several dice",
+			result: dice.RollResult{Total: 12, DieRolls: []dice.DieRoll{
+				{Type: "d6", Result: 4, Score: 4}, {Type: "d6", Result: 2, Score: 2}, {Type: "d6", Result: 6, Score: 6},
+			}},
+			want: "You rolled three six-sided dice getting 4, 2, and 6 for a total of 12.",
+		},
+		{
+			name:   "one die with a modifier and label",
+			result: dice.RollResult{Total: 22, Modifier: 5, Label: "attack", DieRolls: []dice.DieRoll{{Type: "d20", Result: 17, Score: 17}}},
+			want:   "For attack, you rolled a twenty-sided die getting 17, plus 5 for a total of 22.",
+		},
+		{
+			name:   "an article before a vowel",
+			result: dice.RollResult{Total: 1, Modifier: -2, DieRolls: []dice.DieRoll{{Type: "d8", Result: 3, Score: 3}}},
+			want:   "You rolled an eight-sided die getting 3, minus 2 for a total of 1.",
+		},
+		{
+			name: "fancy dice of two types",
+			result: dice.RollResult{Total: 5, DieRolls: []dice.DieRoll{
+				{Type: "f2", Result: 1, Score: 1, FancyValue: "heads"},
+				{Type: "f4", Result: 1, Score: 4, FancyValue: "♠"},
+			}},
+			want: "You rolled a coin showing heads and a suit die showing ♠ for a total of 5.",
+		},
+		{
+			name: "dropped and exploded dice",
+			result: dice.RollResult{Total: 13, DieRolls: []dice.DieRoll{
+				{Type: "d6", Result: 6, Score: 6}, {Type: "d6", Result: 1, Score: 1, Dropped: true},
+				{Type: "d6", Result: 7, Score: 7}, {Type: "d6", Result: 1, Score: 1, Exploded: true},
+			}},
+			want: "You rolled three six-sided dice getting 6, 1, 7, and 1 (one of them an extra roll from exploding), dropping the 1 for a total of 13.",
+		},
+		{
+			name: "successes",
+			result: dice.RollResult{Total: 2, Successes: 2, DieRolls: []dice.DieRoll{
+				{Type: "d10", Result: 9, Score: 9, Success: true}, {Type: "d10", Result: 3, Score: 3}, {Type: "d10", Result: 7, Score: 7, Success: true},
+			}},
+			want: "You rolled three ten-sided dice getting 9, 3, and 7 for two successes.",
+		},
+		{
+			name: "a roll-under check",
+			result: dice.RollResult{Total: 29, RollUnder: &dice.RollUnder{Roll: 29, Target: 45, Degree: dice.Success},
+				DieRolls: []dice.DieRoll{{Type: "d100", Result: 29, Score: 29}}},
+			want: "You rolled a hundred-sided die getting 29. Success: rolled 29 against 45.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatNarration(tt.result, tt.result.DieRolls, outputOptions{}); got != tt.want {
+				t.Errorf("formatNarration() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentileDieRolls(t *testing.T) {
+	dieRolls := []dice.DieRoll{
+		{Type: "d100", Result: 7, Score: 7},
+		{Type: "d100", Result: 100, Score: 100},
+		{Type: "D100", Result: 45, Score: 45},
+		{Type: "d100", Result: 130, Score: 130, Rolls: []int{100, 30}},
+		{Type: "d10", Result: 7, Score: 7},
+	}
+	want := []string{"07", "00", "45", "", ""}
+
+	got := percentileDieRolls(dieRolls)
+	for i, roll := range got {
+		if roll.FancyValue != want[i] {
+			t.Errorf("percentileDieRolls()[%d] shows %q, want %q", i, roll.FancyValue, want[i])
+		}
+		if roll.Score != dieRolls[i].Score {
+			t.Errorf("percentileDieRolls()[%d] scores %d, want %d", i, roll.Score, dieRolls[i].Score)
+		}
+	}
+	if dieRolls[0].FancyValue != "" {
+		t.Errorf("percentileDieRolls() changed the original rolls")
+	}
+}
+
+func TestFormatFaceHistogram(t *testing.T) {
+	dieRolls := []dice.DieRoll{
+		{Die: dice.NewDie(4), Type: "d4", Result: 2},
+		{Die: dice.NewDie(8), Type: "d8", Result: 8},
+		{Die: dice.NewDie(4), Type: "d4", Result: 4},
+		{Die: dice.NewDie(4), Type: "d4", Result: 2},
+		{Type: "f2", Result: 1, FancyValue: "heads"},
+	}
+
+	want := []string{
+		"d4 (3 rolled):",
+		"  1: 0",
+		"  2: 2",
+		"  3: 0",
+		"  4: 1",
+		"d8 (1 rolled):",
+		"  1: 0",
+		"  2: 0",
+		"  3: 0",
+		"  4: 0",
+		"  5: 0",
+		"  6: 0",
+		"  7: 0",
+		"  8: 1",
+		"f2 (1 rolled):",
+		"  heads: 1",
+	}
+
+	got := formatFaceHistogram(dieRolls)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatFaceHistogram() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	macros := map[string]string{"fireball": "8d6", "attack": "1d20+5"}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fireball", "8d6"},
+		{"fireball+2", "8d6+2"},
+		{"attack crit 2d6", "1d20+5 crit 2d6"},
+		{"3d6 fireballs", "3d6 fireballs"},
+		{"highest(2d20)", "highest(2d20)"},
+	}
+
+	for _, test := range tests {
+		if got := expandMacros(test.input, macros); got != test.expected {
+			t.Errorf("expandMacros(%q) = %q, expected %q", test.input, got, test.expected)
+		}
+	}
+
+	if got := expandMacros("fireball", nil); got != "fireball" {
+		t.Errorf("expandMacros() with no macros = %q, expected the input unchanged", got)
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	rolledAt := time.Date(2024, 3, 9, 14, 30, 5, 0, time.FixedZone("CET", 3600))
+
+	if got := formatTimestamp(rolledAt, false); got != "2024-03-09T14:30:05+01:00" {
+		t.Errorf("formatTimestamp() local = %q", got)
+	}
+	if got := formatTimestamp(rolledAt, true); got != "2024-03-09T13:30:05Z" {
+		t.Errorf("formatTimestamp() UTC = %q", got)
+	}
+}
+
+func TestRevealKeepsCommittedSeed(t *testing.T) {
+	defer dice.Reseed() // Leave the dice on a fresh random seed for other tests.
+
+	secret := fair.Secret{Seed: 12345, Nonce: "00112233445566778899aabbccddeeff"}
+	opts := outputOptions{showSeed: true, reseed: true}
+	reveal := func() string {
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+		runReveal([]string{secret.Token(), "20d20"}, opts)
+		w.Close()
+		os.Stdout = oldStdout
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		return buf.String()
+	}
+
+	first, second := reveal(), reveal()
+	if first != second {
+		t.Errorf("Revealing twice gave different rolls:\n%s\n%s", first, second)
+	}
+	if !strings.Contains(first, "reproduce with --seed=12345") {
+		t.Errorf("Expected the committed seed to be reported, got:\n%s", first)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	defer dice.Reseed() // Leave the dice on a fresh random seed for other tests.
+
+	path := filepath.Join(t.TempDir(), "session.log")
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Each session appends to the transcript rather than replacing it.
+	dice.SetSeed(42)
+	for _, expression := range []string{"1d6+3 #attack", "2d6 #damage"} {
+		transcript, err := openTranscript(path)
+		if err != nil {
+			t.Fatalf("openTranscript() error: %v", err)
+		}
+		processDiceExpression(expression, outputOptions{transcript: transcript, utc: true})
+		transcript.Close()
+	}
+
+	// Pool rolls in an interactive session are logged too.
+	transcript, err := openTranscript(path)
+	if err != nil {
+		t.Fatalf("openTranscript() error: %v", err)
+	}
+	poolErr := runPoolCommand("hp = 4d1", make(map[string]int), outputOptions{transcript: transcript, utc: true})
+	transcript.Close()
+
+	w.Close()
+	os.Stdout = oldStdout
+	io.Copy(io.Discard, r)
+
+	if poolErr != nil {
+		t.Fatalf("runPoolCommand unexpected error: %v", poolErr)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	want := []string{"attack: 1d6+3: ", "damage: 2d6: ", "4d1: 1+1+1+1 = 4"}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d transcript lines, got: %q", len(want), lines)
+	}
+	for i, line := range lines {
+		timestamp, entry, _ := strings.Cut(line, " ")
+		if _, err := time.Parse(time.RFC3339, timestamp); err != nil {
+			t.Errorf("Transcript line %d has no timestamp: %q", i, line)
+		}
+		if !strings.HasPrefix(entry, want[i]) || !strings.HasSuffix(entry, " (seed 42)") {
+			t.Errorf("Transcript line %d = %q, want %q... (seed 42)", i, entry, want[i])
+		}
+	}
+
+	if _, err := openTranscript(filepath.Join(t.TempDir(), "missing", "session.log")); err == nil {
+		t.Error("openTranscript() expected an error for a missing directory")
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	defer dice.Reseed() // Leave the dice on a fresh random seed for other tests.
+
+	path := filepath.Join(t.TempDir(), "rolls.jsonl")
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// Each run appends to the file rather than replacing it.
+	dice.SetSeed(42)
+	for _, expression := range []string{"1d6+3 #attack", "1d20+5 crit 2d6"} {
+		history, err := openTranscript(path)
+		if err != nil {
+			t.Fatalf("openTranscript() error: %v", err)
+		}
+		processDiceExpression(expression, outputOptions{history: history, quiet: true})
+		history.Close()
+	}
+
+	w.Close()
+	os.Stdout = oldStdout
+	io.Copy(io.Discard, r)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines of JSON, got: %q", lines)
+	}
+
+	var roll struct {
+		Time       string
+		Seed       string
+		Expression string
+		Label      string
+		Dice       []jsonDie
+		Total      int
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &roll); err != nil {
+		t.Fatalf("Line 1 is not JSON: %v", err)
+	}
+	if _, err := time.Parse(time.RFC3339, roll.Time); err != nil {
+		t.Errorf("Line 1 has no timestamp: %q", lines[0])
+	}
+	if roll.Seed != "42" || roll.Expression != "1d6+3" || roll.Label != "attack" || len(roll.Dice) != 1 || roll.Total != roll.Dice[0].Result+3 {
+		t.Errorf("Line 1 = %s", lines[0])
+	}
+
+	var crit struct {
+		Expression string
+		Attack     jsonRoll
+		Damage     jsonRoll
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &crit); err != nil {
+		t.Fatalf("Line 2 is not JSON: %v", err)
+	}
+	if crit.Expression != "1d20+5 crit 2d6" || crit.Attack.Expression != "1d20+5" || len(crit.Damage.Dice) < 2 {
+		t.Errorf("Line 2 = %s", lines[1])
+	}
+}
+
+func TestJSONLinesEveryRoll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rolls.jsonl")
+	history, err := openTranscript(path)
+	if err != nil {
+		t.Fatalf("openTranscript() error: %v", err)
+	}
+	defer history.Close()
+	opts := outputOptions{history: history, quiet: true}
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	runContested([]string{"1d1+5", "2d1"}, opts)
+	initErr := runInitiative([]string{"Goblin: 1d1+2", "3d1"}, opts)
+	poolErr := runPoolCommand("hp = 4d1", make(map[string]int), opts)
+
+	w.Close()
+	os.Stdout = oldStdout
+	io.Copy(io.Discard, r)
+
+	if initErr != nil || poolErr != nil {
+		t.Fatalf("Unexpected errors: %v, %v", initErr, poolErr)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	want := []jsonRoll{
+		{Expression: "1d1+5", Total: 6},
+		{Expression: "2d1", Total: 2},
+		{Expression: "1d1+2", Label: "Goblin", Total: 3},
+		{Expression: "3d1", Total: 3},
+		{Expression: "4d1", Total: 4},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d lines of JSON, got: %q", len(want), lines)
+	}
+	for i, line := range lines {
+		var roll jsonRoll
+		if err := json.Unmarshal([]byte(line), &roll); err != nil {
+			t.Fatalf("Line %d is not JSON: %q", i+1, line)
+		}
+		if roll.Expression != want[i].Expression || roll.Label != want[i].Label || roll.Total != want[i].Total {
+			t.Errorf("Line %d = %s, want %s #%s totalling %d", i+1, line, want[i].Expression, want[i].Label, want[i].Total)
+		}
+	}
+}
+
+func TestLoadDicePack(t *testing.T) {
+	defer dice.ResetFancyDice()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "colours.dice"), []byte("red\ngreen\nblue\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "empty.dice"), []byte("# nothing here\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := loadDicePack(dir)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "empty.dice") {
+		t.Errorf("Expected one warning for empty.dice, got %q", warnings)
+	}
+	if faces, found := dice.FancyDieFaces("f3"); !found || faces[0].Name != "red" {
+		t.Errorf("Expected colours.dice to define f3, got %v, %v", faces, found)
+	}
+
+	if warnings := loadDicePack(filepath.Join(dir, "missing")); warnings != nil {
+		t.Errorf("Expected a missing directory to be ignored, got %q", warnings)
+	}
+}
+
+func TestFormatDiceList(t *testing.T) {
+	list := []dice.FancyDie{
+		{Type: "f2", Faces: []dice.FancyDieValue{{Name: "heads", Value: 1}, {Name: "tails", Value: 0}}},
+		{Type: "f13", Faces: make([]dice.FancyDieValue, 13)},
+	}
+	list[1].Faces[0] = dice.FancyDieValue{Name: "A", Value: 4}
+
+	lines := strings.Split(strings.TrimSuffix(formatDiceList(list), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two rows, got %q", lines)
+	}
+	if lines[0] != "TYPE  FACES  VALUES" {
+		t.Errorf("Unexpected header %q", lines[0])
+	}
+	if lines[1] != "f2    2      heads=1, tails=0" {
+		t.Errorf("Unexpected f2 row %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "f13   13     A=4, =0") {
+		t.Errorf("Unexpected f13 row %q", lines[2])
+	}
+}
+
+func TestParseSortKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    dice.SortKey
+		wantErr bool
+	}{
+		{"value", dice.SortByValue, false},
+		{"roll", dice.SortByRoll, false},
+		{"ROLL", dice.SortByRoll, false},
+		{"score", dice.SortByValue, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSortKey(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSortKey(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSortKey(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAgainCount(t *testing.T) {
+	for argument, want := range map[string]int{"": 1, "1": 1, "5": 5, "100": 100} {
+		if count, err := parseAgainCount(argument); err != nil || count != want {
+			t.Errorf("parseAgainCount(%q) = %d, %v, want %d", argument, count, err, want)
+		}
+	}
+	for _, argument := range []string{"0", "-2", "101", "five", "3d6"} {
+		if _, err := parseAgainCount(argument); err == nil {
+			t.Errorf("parseAgainCount(%q) expected an error", argument)
+		}
+	}
+}
+
+func TestSetVariable(t *testing.T) {
+	variables := make(map[string]int)
+
+	if err := setVariable("str=3", variables); err != nil || variables["str"] != 3 {
+		t.Errorf("setVariable(str=3) = %v, variables %v", err, variables)
+	}
+	if err := setVariable(" penalty = -2 ", variables); err != nil || variables["penalty"] != -2 {
+		t.Errorf("setVariable(penalty = -2) = %v, variables %v", err, variables)
+	}
+
+	for _, assignment := range []string{"str", "d6=3", "adv=1", "2x=1", "str=three", "=3"} {
+		if err := setVariable(assignment, variables); err == nil {
+			t.Errorf("setVariable(%q) expected error, got nil", assignment)
+		}
+	}
+
+	if err := parseDiceExpression("1d20+str-penalty", variables); err != nil {
+		t.Errorf("parseDiceExpression with variables unexpected error: %v", err)
+	}
+	if err := parseDiceExpression("1d20+dex", variables); !errors.Is(err, dice.ErrUndefinedVariable) || !strings.Contains(err.Error(), "undefined variable: dex") {
+		t.Errorf("Expected an undefined variable error, got %v", err)
+	}
+}
+
+// testFlagSet returns a small flag set standing in for the program's flags.
+func testFlagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet("roll", flag.ContinueOnError)
+	flags.Bool("quiet", false, "Print only the total")
+	flags.Bool("q", false, "Print only the total (short form)")
+	flags.Uint64("seed", 0, "Seed the random number generator for reproducible rolls")
+	flags.String("file", "", "Roll each dice expression in a file, one per line")
+	flags.Bool("chance", false, "Print the probability, e.g. --chance '3d6+2 >= 15'")
+	return flags
+}
+
+func TestFormatCompletion(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"bash", []string{"complete -F _roll roll", "-q --quiet", "--seed) return ;;", "vs init"}},
+		{"zsh", []string{"#compdef roll", "'--seed=[Seed the random number generator for reproducible rolls]:uint: '", "'-q[Print only the total (short form)]'", `'\''3d6+2 >= 15'\''`}},
+		{"fish", []string{"complete -c roll -s q -d", "complete -c roll -l file -r -F", "-a vs -d", `\'3d6+2 >= 15\'`}},
+	}
+	for _, tt := range tests {
+		got, err := formatCompletion(tt.shell, testFlagSet())
+		if err != nil {
+			t.Fatalf("formatCompletion(%q) unexpected error: %v", tt.shell, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("formatCompletion(%q) missing %q in:\n%s", tt.shell, want, got)
+			}
+		}
+	}
+
+	if _, err := formatCompletion("tcsh", testFlagSet()); err == nil {
+		t.Error("Expected an error for an unsupported shell")
+	}
+}
+
+func TestFindSubcommand(t *testing.T) {
+	for _, name := range []string{"roll", "stats", "list-dice", "vs", "man"} {
+		if command, found := findSubcommand(name); !found || command.name != name || command.run == nil {
+			t.Errorf("findSubcommand(%q) = %q, %v, want the %q command", name, command.name, found, name)
+		}
+	}
+	for _, name := range []string{"3d6", "d20", "serve", ""} {
+		if _, found := findSubcommand(name); found {
+			t.Errorf("findSubcommand(%q) found a command, want dice notation", name)
+		}
+	}
+}
+
+func TestAutoCompleterCommands(t *testing.T) {
+	completer := createAutoCompleter()
+	for _, command := range []string{"help", "version", "cheat", "history", "seed", "set", "unset", "vars", "pool", "batch", "total", "again", "quit", "exit"} {
+		candidates, length := completer.Do([]rune(command), len(command))
+		found := false
+		for _, candidate := range candidates {
+			if string(candidate) == " " {
+				found = true
+			}
+		}
+		if !found || length != len(command) {
+			t.Errorf("%q does not complete as a command: %q", command, candidates)
+		}
+	}
+	candidates, _ := completer.Do([]rune("total o"), len("total o"))
+	if len(candidates) != 2 {
+		t.Errorf("total o completes to %q, want on and off", candidates)
+	}
+}
+
+func TestFormatManPage(t *testing.T) {
+	got := formatManPage(testFlagSet())
+	for _, want := range []string{".TH ROLL 1", ".B \\-\\-quiet", ".BI \\-\\-seed= uint", ".B vs \\fIEXPR_A EXPR_B\\fR", ".SS BASIC DICE NOTATION", "\\fB3d6\\fR \\- Roll three 6\\-sided dice", "in ROLL_DEFAULT if it is set", ".B ROLL_DEFAULT"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatManPage() missing %q", want)
+		}
+	}
+}